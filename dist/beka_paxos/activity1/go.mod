@@ -0,0 +1,7 @@
+module github.com/beka-birhanu/paxos-lab/activity1
+
+go 1.23.4
+
+require github.com/beka-birhanu/paxos-lab-activity2 v0.0.0
+
+replace github.com/beka-birhanu/paxos-lab-activity2 => ../activity2
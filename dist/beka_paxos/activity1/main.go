@@ -7,9 +7,12 @@ import (
 )
 
 func main() {
-	acceptors := []*paxos.Acceptor{{}, {}, {}, {}, {}}
+	acceptors := make([]*paxos.Acceptor, 5)
+	for i := range acceptors {
+		acceptors[i] = paxos.NewAcceptor(fmt.Sprintf("acceptor%d", i+1))
+	}
 
-	proposer := paxos.Proposer{ProposalNumber: 1}
+	proposer := paxos.Proposer{ID: "proposer1", ProposalNumber: 1}
 	value := proposer.Propose("Distributed Systems is cool!", acceptors)
 
 	if value != nil {
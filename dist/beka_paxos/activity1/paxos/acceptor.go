@@ -1,23 +1,31 @@
 package paxos
 
-import "sync"
+import (
+	"sync"
 
+	"github.com/beka-birhanu/paxos-lab-activity2/core"
+)
+
+// Acceptor answers prepare and accept messages synchronously, keeping the state
+// of its core.Acceptor in memory.
 type Acceptor struct {
-	mu             sync.Mutex
-	promisedNumber int
-	acceptedNumber int
-	acceptedValue  interface{}
+	mu    sync.Mutex
+	state *core.Acceptor
+}
+
+// NewAcceptor creates an acceptor. Proposers count votes by id, so every acceptor
+// needs its own.
+func NewAcceptor(id string) *Acceptor {
+	return &Acceptor{state: core.NewAcceptor(id, nil)}
 }
 
 func (a *Acceptor) HandlePrepare(p Prepare) *Promise {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	if p.ProposalNumber > a.promisedNumber {
-		a.promisedNumber = p.ProposalNumber
-		return &Promise{ProposalNumber: a.promisedNumber, AcceptedValue: a.acceptedNumber}
+	if promise, ok := a.state.HandlePrepare(p); ok {
+		return &promise
 	}
-
 	return nil
 }
 
@@ -25,11 +33,8 @@ func (a *Acceptor) HandleAccept(ac Accept) *Accepted {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	if ac.ProposalNumber >= a.promisedNumber {
-		a.promisedNumber = ac.ProposalNumber
-		a.acceptedNumber = ac.ProposalNumber
-		a.acceptedValue = ac.Value
-		return &Accepted{ProposalNumber: a.promisedNumber, Value: a.acceptedValue}
+	if accepted, ok := a.state.HandleAccept(ac); ok {
+		return &accepted
 	}
 	return nil
 }
@@ -1,20 +1,12 @@
 package paxos
 
-type Prepare struct {
-	ProposalNumber int
-}
-
-type Promise struct {
-	ProposalNumber int
-	AcceptedValue  interface{}
-}
-
-type Accept struct {
-	ProposalNumber int
-	Value          interface{}
-}
-
-type Accepted struct {
-	ProposalNumber int
-	Value          interface{}
-}
+import "github.com/beka-birhanu/paxos-lab-activity2/core"
+
+// The messages are those of the core state machines, which activity2 shares.
+type (
+	Prepare        = core.Prepare
+	Promise        = core.Promise
+	Accept         = core.Accept
+	Accepted       = core.Accepted
+	ProposalNumber = core.ProposalNumber
+)
@@ -1,33 +1,43 @@
 package paxos
 
+import "github.com/beka-birhanu/paxos-lab-activity2/core"
+
+// instance is the only instance: activity1 decides a single value.
+const instance = 1
+
 type Proposer struct {
+	ID             string
 	ProposalNumber int
 }
 
+// Propose runs one round of Paxos against acceptors and returns the chosen value,
+// or nil. If a majority promised and one of them already accepted a value, that
+// value is proposed instead of value.
 func (p *Proposer) Propose(value interface{}, acceptors []*Acceptor) interface{} {
-	promises := 0
-	prepare := Prepare{ProposalNumber: p.ProposalNumber}
+	number := core.ProposalNumber{BallotNumber: p.ProposalNumber, ProposerID: p.ID}
+	round := core.NewRound(instance, number, core.Configuration{Size: len(acceptors)})
+
+	prepare := round.Prepare()
 	for _, acceptor := range acceptors {
-		promise := acceptor.HandlePrepare(prepare)
-		if promise != nil && promise.ProposalNumber == p.ProposalNumber {
-			promises += 1
+		if promise := acceptor.HandlePrepare(prepare); promise != nil {
+			round.AddPromise(*promise)
 		}
 	}
+	if !round.Promised() {
+		return nil
+	}
+	if adopted := round.AdoptedValue(); adopted != nil {
+		value = adopted
+	}
 
-	if promises > len(acceptors)/2 { // majored accpted
-		accepted := 0
-		accept := Accept{Value: value, ProposalNumber: p.ProposalNumber}
-		for _, acceptors := range acceptors {
-			ack := acceptors.HandleAccept(accept)
-			if ack != nil && ack.ProposalNumber == p.ProposalNumber {
-				accepted++
-			}
-		}
-
-		if accepted > len(acceptors)/2 {
-			return value
+	accept := round.Accept(value)
+	for _, acceptor := range acceptors {
+		if ack := acceptor.HandleAccept(accept); ack != nil {
+			round.AddAccepted(*ack)
 		}
 	}
-
+	if round.Chosen() {
+		return value
+	}
 	return nil
 }
@@ -0,0 +1,390 @@
+// Package client talks to the HTTP API of a Paxos cluster. It finds the leader
+// through any server it knows and sends requests straight to it, follows leader
+// changes and retries requests that failed for want of a leader. Proposals carry
+// an idempotency key, so a retried proposal is decided once. Failed proposals
+// unwrap to ErrLost when another value won, which retrying cannot change, or to
+// ErrNoConsensus when retrying may help.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/paxos"
+)
+
+// Config configures a Client. Zero fields take the defaults below.
+type Config struct {
+	// Nodes are the host:port addresses of the HTTP API of some of the servers.
+	Nodes []string
+	// Timeout bounds every request. Default 5s.
+	Timeout time.Duration
+	// MaxRetries is how often a request that failed for want of a leader, or a
+	// proposal that was not decided, is retried. Default 5.
+	MaxRetries int
+	// Backoff is the wait before the first retry, doubled per retry up to
+	// MaxBackoff. Defaults 100ms and 2s.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// HTTPClient sends the requests. Default http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Client sends requests to a Paxos cluster. It is safe for concurrent use.
+type Client struct {
+	cfg  Config
+	http *http.Client
+
+	mu    sync.Mutex
+	nodes []string
+	// leader is the address of the last known leader, or empty.
+	leader string
+	next   int
+}
+
+// New creates a client of the cluster cfg.Nodes belong to.
+func New(cfg Config) (*Client, error) {
+	if len(cfg.Nodes) == 0 {
+		return nil, errors.New("no nodes given")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 5
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = 100 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 2 * time.Second
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{cfg: cfg, http: httpClient, nodes: slices.Clone(cfg.Nodes)}, nil
+}
+
+// Leader is what a server knows about the leader and its own progress.
+type Leader struct {
+	ID           string `json:"ID"`
+	LeaderID     string `json:"leader_ID"`
+	Address      string `json:"address"`
+	IsLeader     bool   `json:"is_leader"`
+	HasLease     bool   `json:"has_lease"`
+	LastInstance int    `json:"last_instance"`
+	Applied      int    `json:"applied"`
+}
+
+// Members are the configuration a server decides instances under and the latest
+// one it knows of, which takes effect a few instances later.
+type Members struct {
+	Current paxos.Configuration `json:"current"`
+	Latest  paxos.Configuration `json:"latest"`
+}
+
+// NodeStatus is the state of one server. Err is set when it did not answer.
+type NodeStatus struct {
+	Node    string
+	Leader  Leader
+	Members Members
+	Err     error
+}
+
+// Discover asks the known servers for the leader and remembers its address, adding
+// it to the known servers.
+func (c *Client) Discover(ctx context.Context) (string, error) {
+	var errs []error
+	for _, node := range c.knownNodes() {
+		var leader Leader
+		if err := c.get(ctx, "discover", node, "/leader", &leader); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if leader.Address == "" {
+			errs = append(errs, &Error{Op: "discover", Node: node, Err: ErrNoLeader})
+			continue
+		}
+		c.mu.Lock()
+		c.leader = leader.Address
+		if !slices.Contains(c.nodes, leader.Address) {
+			c.nodes = append(c.nodes, leader.Address)
+		}
+		c.mu.Unlock()
+		return leader.Address, nil
+	}
+	return "", errors.Join(errs...)
+}
+
+// Propose gets value decided in the log and returns its instance.
+func (c *Client) Propose(ctx context.Context, value interface{}) (int, error) {
+	return c.ProposeWithKey(ctx, NewKey(), value)
+}
+
+// ProposeWithKey is Propose with the idempotency key key. Proposals with the same
+// key are decided once, within the last instances the servers remember keys for;
+// a retry returns the instance of the first.
+func (c *Client) ProposeWithKey(ctx context.Context, key string, value interface{}) (int, error) {
+	header := http.Header{paxos.IdempotencyHeader: {key}}
+	response, err := c.propose(ctx, paxos.ProposalRequest{Value: value}, header)
+	return response.Instance, err
+}
+
+// ProposeAt gets value decided in instance, which has to be decided already or be
+// the next free instance, and returns the value decided there. When another value
+// won the instance it returns that value and an error wrapping ErrLost. Proposals
+// for an instance are idempotent, so failures short of ErrLost are retried.
+func (c *Client) ProposeAt(ctx context.Context, instance int, value interface{}) (interface{}, error) {
+	response, err := c.propose(ctx, paxos.ProposalRequest{Value: value, Instance: &instance}, nil)
+	var failure *Error
+	if errors.As(err, &failure) && errors.Is(err, ErrLost) {
+		return paxos.Unkeyed(failure.Value), err
+	}
+	return response.Value, err
+}
+
+func (c *Client) propose(ctx context.Context, request paxos.ProposalRequest, header http.Header) (paxos.ProposalResponse, error) {
+	var response paxos.ProposalResponse
+	body, err := json.Marshal(request)
+	if err != nil {
+		return response, err
+	}
+	result, err := c.do(ctx, "propose", http.MethodPost, "/v1/proposals", header, body, true)
+	if err != nil {
+		return response, err
+	}
+	if err := json.Unmarshal(result, &response); err != nil {
+		return response, fmt.Errorf("propose: invalid response: %w", err)
+	}
+	return response, nil
+}
+
+// Decision returns the value decided in instance.
+func (c *Client) Decision(ctx context.Context, instance int) (interface{}, error) {
+	var entry paxos.LogEntry
+	if err := c.doJSON(ctx, "decision", http.MethodGet, "/decisions/"+strconv.Itoa(instance), nil, &entry); err != nil {
+		return nil, err
+	}
+	return paxos.Unkeyed(entry.Value), nil
+}
+
+// Log returns the decided entries from instance from up to instance to; to 0 means
+// up to the last decided one. Proposed values are returned without their keys.
+func (c *Client) Log(ctx context.Context, from, to int) ([]paxos.LogEntry, error) {
+	query := url.Values{"from": {strconv.Itoa(from)}}
+	if to > 0 {
+		query.Set("to", strconv.Itoa(to))
+	}
+	var entries []paxos.LogEntry
+	if err := c.doJSON(ctx, "log", http.MethodGet, "/log?"+query.Encode(), nil, &entries); err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].Value = paxos.Unkeyed(entries[i].Value)
+	}
+	return entries, nil
+}
+
+// Status asks every known server for its state. Servers are asked once, so a
+// server that does not answer shows up with Err set.
+func (c *Client) Status(ctx context.Context) []NodeStatus {
+	nodes := c.knownNodes()
+	statuses := make([]NodeStatus, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status := NodeStatus{Node: node}
+			status.Err = c.get(ctx, "status", node, "/leader", &status.Leader)
+			if status.Err == nil {
+				status.Err = c.get(ctx, "status", node, "/members", &status.Members)
+			}
+			statuses[i] = status
+		}()
+	}
+	wg.Wait()
+	return statuses
+}
+
+// AddMember adds the acceptor id to the configuration and returns the new one.
+// Adding a member again changes nothing.
+func (c *Client) AddMember(ctx context.Context, id string) (paxos.Configuration, error) {
+	body, err := json.Marshal(map[string]string{"id": id})
+	if err != nil {
+		return paxos.Configuration{}, err
+	}
+	var response struct {
+		Latest paxos.Configuration `json:"latest"`
+	}
+	err = c.doJSON(ctx, "add member", http.MethodPost, "/members", body, &response)
+	return response.Latest, err
+}
+
+// RemoveMember removes the acceptor id from the configuration and returns the new
+// one. It fails with ErrNotFound if id is not a member.
+func (c *Client) RemoveMember(ctx context.Context, id string) (paxos.Configuration, error) {
+	var response struct {
+		Latest paxos.Configuration `json:"latest"`
+	}
+	err := c.doJSON(ctx, "remove member", http.MethodDelete, "/members/"+url.PathEscape(id), nil, &response)
+	return response.Latest, err
+}
+
+// NewKey returns a random idempotency key.
+func NewKey() string {
+	key := make([]byte, 16)
+	rand.Read(key)
+	return hex.EncodeToString(key)
+}
+
+func (c *Client) doJSON(ctx context.Context, op, method, path string, body []byte, v interface{}) error {
+	response, err := c.do(ctx, op, method, path, nil, body, false)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(response, v); err != nil {
+		return fmt.Errorf("%s: invalid response: %w", op, err)
+	}
+	return nil
+}
+
+// do sends the request to the leader, or to a known server when the leader is
+// unknown, which forwards it. Requests that fail for want of a leader are retried
+// after looking the leader up again, and so are those not decided when
+// retryConflict is set, which only idempotent requests may set.
+func (c *Client) do(ctx context.Context, op, method, path string, header http.Header, body []byte, retryConflict bool) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		node := c.target()
+		status, response, err := c.send(ctx, method, node, path, header, body)
+		if err == nil && status < 300 {
+			return response, nil
+		}
+
+		var failure *Error
+		if err != nil {
+			failure = &Error{Op: op, Node: node, Err: err}
+		} else {
+			failure = statusError(op, node, status, response)
+		}
+		retry := err != nil || errors.Is(failure, ErrNoLeader) || retryConflict && errors.Is(failure, ErrNoConsensus)
+		if !retry || attempt >= c.cfg.MaxRetries || ctx.Err() != nil {
+			return nil, failure
+		}
+		if err != nil || errors.Is(failure, ErrNoLeader) {
+			c.forgetLeader(node)
+		}
+		if !sleep(ctx, c.backoff(attempt)) {
+			return nil, failure
+		}
+		if c.leaderAddress() == "" {
+			c.Discover(ctx)
+		}
+	}
+}
+
+func (c *Client) get(ctx context.Context, op, node, path string, v interface{}) error {
+	status, response, err := c.send(ctx, http.MethodGet, node, path, nil, nil)
+	if err != nil {
+		return &Error{Op: op, Node: node, Err: err}
+	}
+	if status >= 300 {
+		return statusError(op, node, status, response)
+	}
+	if err := json.Unmarshal(response, v); err != nil {
+		return &Error{Op: op, Node: node, Err: fmt.Errorf("invalid response: %w", err)}
+	}
+	return nil
+}
+
+func (c *Client) send(ctx context.Context, method, node, path string, header http.Header, body []byte) (int, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+node+path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	response, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, response, err
+}
+
+// target returns the leader if known, else the next known server in turn.
+func (c *Client) target() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.leader != "" {
+		return c.leader
+	}
+	node := c.nodes[c.next%len(c.nodes)]
+	c.next++
+	return node
+}
+
+func (c *Client) leaderAddress() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.leader
+}
+
+func (c *Client) forgetLeader(node string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.leader == node {
+		c.leader = ""
+	}
+}
+
+func (c *Client) knownNodes() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.nodes)
+}
+
+// backoff returns a random wait between half and all of Backoff doubled per
+// earlier retry, capped at MaxBackoff.
+func (c *Client) backoff(retry int) time.Duration {
+	d := min(c.cfg.Backoff<<min(retry, 16), c.cfg.MaxBackoff)
+	return d/2 + mathrand.N(d/2+1)
+}
+
+// sleep waits for d and reports whether ctx was still running afterwards.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,306 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/paxos"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// newTestClient returns a client of nodes that retries without waiting long.
+func newTestClient(t *testing.T, nodes ...string) *Client {
+	t.Helper()
+	c, err := New(Config{Nodes: nodes, Timeout: 5 * time.Second, Backoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func address(server *httptest.Server) string {
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestProposeFollowsLeader(t *testing.T) {
+	var leaderProposals, followerProposals atomic.Int32
+	keys := make(chan string, 2)
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaderProposals.Add(1)
+		keys <- r.Header.Get(paxos.IdempotencyHeader)
+		fmt.Fprint(w, `{"instance": 4, "value": "v"}`)
+	}))
+	defer leader.Close()
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/leader" {
+			fmt.Fprintf(w, `{"address": %q}`, address(leader))
+			return
+		}
+		followerProposals.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, `{"code": %q, "error": "Leadership lost"}`, paxos.CodeNotLeader)
+	}))
+	defer follower.Close()
+
+	c := newTestClient(t, address(follower))
+	instance, err := c.ProposeWithKey(context.Background(), "key", "v")
+	if err != nil || instance != 4 {
+		t.Fatalf("propose: got %d, %v", instance, err)
+	}
+	if followerProposals.Load() != 1 || leaderProposals.Load() != 1 {
+		t.Fatalf("%d proposals to the follower and %d to the leader, want one each",
+			followerProposals.Load(), leaderProposals.Load())
+	}
+	if key := <-keys; key != "key" {
+		t.Fatalf("leader got idempotency key %q", key)
+	}
+	if got := c.leaderAddress(); got != address(leader) {
+		t.Fatalf("leader %q, want %q", got, address(leader))
+	}
+}
+
+func TestDoRetries(t *testing.T) {
+	tests := []struct {
+		name          string
+		statuses      []int
+		retryConflict bool
+		wantErr       error
+		wantRequests  int
+	}{
+		{"success", []int{http.StatusOK}, false, nil, 1},
+		{"no leader, then success", []int{http.StatusServiceUnavailable, http.StatusOK}, false, nil, 2},
+		{"no leader throughout", []int{http.StatusServiceUnavailable}, false, ErrNoLeader, 4},
+		{"conflict retried", []int{http.StatusConflict, http.StatusConflict, http.StatusOK}, true, nil, 3},
+		{"conflict not retried", []int{http.StatusConflict, http.StatusOK}, false, ErrNoConsensus, 1},
+		{"not found", []int{http.StatusNotFound}, true, ErrNotFound, 1},
+		{"compacted", []int{http.StatusGone}, true, ErrCompacted, 1},
+		{"invalid", []int{http.StatusBadRequest}, true, ErrInvalid, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/leader" {
+					fmt.Fprintf(w, `{"address": %q}`, address(server))
+					return
+				}
+				n := int(requests.Add(1))
+				w.WriteHeader(tt.statuses[min(n, len(tt.statuses))-1])
+				fmt.Fprint(w, "body")
+			}))
+			defer server.Close()
+
+			c, err := New(Config{Nodes: []string{address(server)}, MaxRetries: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond})
+			if err != nil {
+				t.Fatal(err)
+			}
+			response, err := c.do(context.Background(), "test", http.MethodPost, "/test", nil, []byte("{}"), tt.retryConflict)
+			if tt.wantErr == nil && (err != nil || string(response) != "body") {
+				t.Fatalf("got %q, %v", response, err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if n := int(requests.Load()); n != tt.wantRequests {
+				t.Fatalf("%d requests, want %d", n, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestDoStopsWithContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c, err := New(Config{Nodes: []string{address(server)}, MaxRetries: 1000, Backoff: time.Hour, MaxBackoff: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.do(ctx, "test", http.MethodGet, "/test", nil, nil, false); !errors.Is(err, ErrNoLeader) {
+		t.Fatalf("error %v, want %v", err, ErrNoLeader)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		status  int
+		body    string
+		want    error
+		message string
+	}{
+		{http.StatusServiceUnavailable, "Leadership lost", ErrNoLeader, "Leadership lost"},
+		{http.StatusConflict, "Consensus not reached\n", ErrNoConsensus, "Consensus not reached"},
+		{http.StatusNotFound, `{"error": "instance 9 not decided"}`, ErrNotFound, "instance 9 not decided"},
+		{http.StatusGone, `{"error": "compacted"}`, ErrCompacted, "compacted"},
+		{http.StatusBadRequest, "Invalid request payload", ErrInvalid, "Invalid request payload"},
+	}
+	for _, tt := range tests {
+		err := statusError("op", "node", tt.status, []byte(tt.body))
+		if !errors.Is(err, tt.want) || err.Message != tt.message || err.Status != tt.status {
+			t.Errorf("%d %q: got %+v, want %v with message %q", tt.status, tt.body, err, tt.want, tt.message)
+		}
+	}
+	if err := statusError("op", "node", http.StatusTeapot, nil); errors.Is(err, ErrInvalid) || err.Err == nil {
+		t.Errorf("unknown status: got %+v", err)
+	}
+}
+
+func TestStatusErrorCodes(t *testing.T) {
+	tests := []struct {
+		status int
+		code   string
+		want   error
+	}{
+		{http.StatusGatewayTimeout, paxos.CodeTimeout, ErrTimeout},
+		{http.StatusServiceUnavailable, paxos.CodeNoQuorum, ErrNoQuorum},
+		{http.StatusServiceUnavailable, paxos.CodePreempted, ErrPreempted},
+		{http.StatusServiceUnavailable, paxos.CodeShuttingDown, ErrNoLeader},
+		{http.StatusConflict, paxos.CodeLost, ErrLost},
+		{http.StatusConflict, paxos.CodeFutureInstance, ErrFutureInstance},
+		{http.StatusRequestEntityTooLarge, paxos.CodeTooLarge, ErrInvalid},
+		{http.StatusMethodNotAllowed, paxos.CodeMethodNotAllowed, ErrInvalid},
+	}
+	for _, tt := range tests {
+		body := fmt.Sprintf(`{"code": %q, "error": "message", "value": "a"}`, tt.code)
+		err := statusError("op", "node", tt.status, []byte(body))
+		if !errors.Is(err, tt.want) || err.Code != tt.code || err.Message != "message" || err.Value != "a" {
+			t.Errorf("%d %s: got %+v, want %v", tt.status, tt.code, err, tt.want)
+		}
+	}
+	// The reasons a proposal was not decided unwrap to ErrNoConsensus, so callers
+	// that do not care about them need not list them; losing an instance does not.
+	for _, err := range []error{ErrTimeout, ErrNoQuorum, ErrPreempted} {
+		if !errors.Is(err, ErrNoConsensus) {
+			t.Errorf("%v does not unwrap to %v", err, ErrNoConsensus)
+		}
+	}
+	if errors.Is(ErrLost, ErrNoConsensus) {
+		t.Errorf("%v unwraps to %v", ErrLost, ErrNoConsensus)
+	}
+}
+
+// startCluster runs three servers on one memory bus, each behind an HTTP test
+// server, until the test ends, and returns the addresses of their HTTP APIs.
+func startCluster(t *testing.T) []string {
+	t.Helper()
+	dir := t.TempDir()
+	bus := paxos.NewMemoryBus()
+	ctx, stop := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		stop()
+		wg.Wait()
+	})
+
+	nodes := make([]string, 3)
+	for i := range nodes {
+		// The server advertises the address of its HTTP API, which only exists once
+		// the test server started.
+		var handler atomic.Value
+		api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler.Load().(http.Handler).ServeHTTP(w, r)
+		}))
+		t.Cleanup(api.Close)
+		nodes[i] = address(api)
+
+		id := fmt.Sprintf("node%d", i+1)
+		server, err := paxos.NewServer(bus.Join(), paxos.Config{
+			ID:                id,
+			NumberOfAccepters: len(nodes),
+			DataDir:           filepath.Join(dir, id),
+			Address:           nodes[i],
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler.Store(server.Handler())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			server.Run(ctx)
+		}()
+	}
+	return nodes
+}
+
+func TestClientAgainstCluster(t *testing.T) {
+	nodes := startCluster(t)
+	// Talking to a single server, the client has to find the leader through it,
+	// and retry until one got elected.
+	c, err := New(Config{Nodes: nodes[2:], MaxRetries: 50, Backoff: 50 * time.Millisecond, MaxBackoff: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	first, err := c.ProposeWithKey(ctx, "key", "a")
+	if err != nil {
+		t.Fatalf("propose: %s", err)
+	}
+	if _, err := c.Propose(ctx, "b"); err != nil {
+		t.Fatalf("propose: %s", err)
+	}
+	// A retry with the same key returns the instance of the first proposal instead
+	// of deciding the value again.
+	again, err := c.ProposeWithKey(ctx, "key", "a")
+	if err != nil || again != first {
+		t.Fatalf("retried proposal: got %d, %v; want instance %d", again, err, first)
+	}
+
+	if value, err := c.Decision(ctx, first); err != nil || value != "a" {
+		t.Fatalf("decision %d: got %v, %v", first, value, err)
+	}
+	entries, err := c.Log(ctx, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decided := 0
+	for _, entry := range entries {
+		if entry.Value == "a" {
+			decided++
+		}
+	}
+	if decided != 1 {
+		t.Fatalf("a decided %d times in %v, want once", decided, entries)
+	}
+	// Another value for a decided instance loses, and the error tells the value
+	// chosen there.
+	if value, err := c.ProposeAt(ctx, first, "z"); !errors.Is(err, ErrLost) || value != "a" {
+		t.Fatalf("losing proposal: got %v, %v; want a, %v", value, err, ErrLost)
+	}
+	if value, err := c.ProposeAt(ctx, first, "a"); err != nil || value != "a" {
+		t.Fatalf("repeated proposal: got %v, %v", value, err)
+	}
+	if _, err := c.ProposeAt(ctx, 1000, "z"); !errors.Is(err, ErrFutureInstance) {
+		t.Fatalf("future instance: got %v, want %v", err, ErrFutureInstance)
+	}
+	if _, err := c.Decision(ctx, 1000); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("undecided instance: got %v, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/paxos"
+)
+
+var (
+	// ErrNoLeader means no server could serve the request for want of a leader:
+	// none is elected, leadership moved or the leader is shutting down.
+	ErrNoLeader = errors.New("no leader available")
+	// ErrNoConsensus means the cluster did not decide the request. ErrTimeout,
+	// ErrNoQuorum and ErrPreempted say why and unwrap to it; retrying may succeed.
+	ErrNoConsensus = errors.New("consensus not reached")
+	ErrTimeout     = fmt.Errorf("%w: timed out", ErrNoConsensus)
+	ErrNoQuorum    = fmt.Errorf("%w: no quorum of acceptors answered", ErrNoConsensus)
+	ErrPreempted   = fmt.Errorf("%w: preempted by a higher ballot", ErrNoConsensus)
+	// ErrLost means another value was chosen for the instance proposed for.
+	ErrLost = errors.New("another value was chosen")
+	// ErrFutureInstance means the instance proposed for is past the next free one.
+	ErrFutureInstance = errors.New("instance past the next free one")
+	// ErrNotFound means the instance is not decided or the member does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrCompacted means the requested log entries were compacted into a snapshot.
+	ErrCompacted = errors.New("log compacted")
+	// ErrInvalid means the cluster rejected the request as malformed.
+	ErrInvalid = errors.New("invalid request")
+)
+
+// Error is a request that failed on Node. It unwraps to one of the Err* values
+// when the failure is one of those, or to the transport error. Code is the error
+// code of the proposals API, and Value the value chosen instead for ErrLost.
+type Error struct {
+	Op      string
+	Node    string
+	Status  int
+	Code    string
+	Message string
+	Value   interface{}
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Status == 0 {
+		return fmt.Sprintf("%s via %s: %s", e.Op, e.Node, e.Err)
+	}
+	return fmt.Sprintf("%s via %s: %d %s", e.Op, e.Node, e.Status, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// codeErrors are the errors of the proposals API error codes.
+var codeErrors = map[string]error{
+	paxos.CodeInvalid:          ErrInvalid,
+	paxos.CodeTooLarge:         ErrInvalid,
+	paxos.CodeMethodNotAllowed: ErrInvalid,
+	paxos.CodeNotLeader:        ErrNoLeader,
+	paxos.CodeShuttingDown:     ErrNoLeader,
+	paxos.CodeTimeout:          ErrTimeout,
+	paxos.CodeNoQuorum:         ErrNoQuorum,
+	paxos.CodePreempted:        ErrPreempted,
+	paxos.CodeLost:             ErrLost,
+	paxos.CodeCompacted:        ErrCompacted,
+	paxos.CodeFutureInstance:   ErrFutureInstance,
+}
+
+// statusError returns the error of a response with status and body, going by its
+// error code when it has one.
+func statusError(op, node string, status int, body []byte) *Error {
+	var response struct {
+		Code  string      `json:"code"`
+		Error string      `json:"error"`
+		Value interface{} `json:"value"`
+	}
+	e := &Error{Op: op, Node: node, Status: status, Message: strings.TrimSpace(string(body))}
+	if err := json.Unmarshal(body, &response); err == nil && response.Error != "" {
+		e.Code, e.Message, e.Value = response.Code, response.Error, response.Value
+	}
+	if err, ok := codeErrors[e.Code]; ok {
+		e.Err = err
+		return e
+	}
+	switch status {
+	case http.StatusServiceUnavailable:
+		e.Err = ErrNoLeader
+	case http.StatusConflict:
+		e.Err = ErrNoConsensus
+	case http.StatusGatewayTimeout:
+		e.Err = ErrTimeout
+	case http.StatusNotFound:
+		e.Err = ErrNotFound
+	case http.StatusGone:
+		e.Err = ErrCompacted
+	case http.StatusBadRequest:
+		e.Err = ErrInvalid
+	default:
+		e.Err = errors.New(http.StatusText(status))
+	}
+	return e
+}
@@ -0,0 +1,295 @@
+// Command paxosbench runs an in-process cluster and measures how much CPU it burns
+// while idle and how long proposals take, optionally over a lossy network where
+// phases time out and are retried. It then compares the throughput of concurrent
+// clients across batch sizes. With -fast, values go through fast ballots instead;
+// those save a message delay but need every acceptor of three to write its log.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/internal/cputime"
+	"github.com/beka-birhanu/paxos-lab-activity2/paxos"
+)
+
+func main() {
+	nodes := flag.Int("nodes", 3, "number of servers in the cluster")
+	idle := flag.Duration("idle", 2*time.Second, "how long to measure the idle cluster")
+	proposals := flag.Int("proposals", 200, "number of sequential proposals to time")
+	drop := flag.Float64("drop", 0, "probability of dropping a protocol message")
+	phaseTimeout := flag.Duration("phase-timeout", 300*time.Millisecond, "deadline of one prepare or accept round")
+	retries := flag.Int("retries", 3, "rounds per phase before a proposal fails")
+	backoff := flag.Duration("backoff", 10*time.Millisecond, "backoff before the first retry")
+	maxBackoff := flag.Duration("max-backoff", 200*time.Millisecond, "upper bound of the retry backoff")
+	clients := flag.Int("clients", 64, "concurrent clients of the throughput run")
+	duration := flag.Duration("duration", 2*time.Second, "length of the throughput run for each batch size")
+	batchSizes := flag.String("batch-sizes", "1,64", "comma-separated batch sizes to compare; empty skips the throughput run")
+	flushInterval := flag.Duration("flush-interval", 0, "how long a batch waits for more values")
+	pipelineDepth := flag.Int("pipeline-depth", 0, "entries in flight at once (0: reconfiguration window)")
+	fast := flag.Bool("fast", false, "send values straight to the acceptors in fast ballots")
+	verbose := flag.Bool("v", false, "print server logs")
+	flag.Parse()
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	dir, err := os.MkdirTemp("", "paxosbench")
+	if err != nil {
+		fail(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := paxos.Config{
+		NumberOfAccepters: *nodes,
+		PhaseTimeout:      *phaseTimeout,
+		MaxRetries:        *retries,
+		RetryBackoff:      *backoff,
+		MaxRetryBackoff:   *maxBackoff,
+		FlushInterval:     *flushInterval,
+		PipelineDepth:     *pipelineDepth,
+		FastRounds:        *fast,
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	servers, done, err := startCluster(ctx, dir, cfg, *nodes, *drop)
+	if err != nil {
+		fail(err)
+	}
+
+	leader, err := waitForLeader(servers, 10*time.Second)
+	if err != nil {
+		fail(err)
+	}
+
+	cpu, goroutines, err := measureIdle(*idle)
+	if err != nil {
+		fmt.Printf("idle: %s\n", err)
+	} else {
+		fmt.Printf("idle: %d servers used %s of CPU in %s (%.2f%% of one core), %d goroutines\n",
+			*nodes, cpu.Round(time.Microsecond), *idle, 100*cpu.Seconds()/idle.Seconds(), goroutines)
+	}
+
+	latencies, failures := measureProposals(leader, *proposals, cfg)
+	bound := phaseBound(cfg)
+	fmt.Printf("proposals: %d decided, %d failed, drop rate %.2f\n", len(latencies), failures, *drop)
+	if len(latencies) > 0 {
+		fmt.Printf("latency: p50 %s  p90 %s  p99 %s  max %s\n",
+			percentile(latencies, .5), percentile(latencies, .9), percentile(latencies, .99), percentile(latencies, 1))
+	}
+	fmt.Printf("phase bound: %s (%d rounds of %s plus backoff)\n", bound, cfg.MaxRetries, cfg.PhaseTimeout)
+	stop()
+	<-done
+
+	for _, field := range strings.Split(*batchSizes, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		size, err := strconv.Atoi(field)
+		if err != nil || size < 1 {
+			fail(fmt.Errorf("invalid batch size %q", field))
+		}
+		cfg := cfg
+		cfg.BatchSize = size
+		decided, failed, err := benchThroughput(filepath.Join(dir, "batch"+field), cfg, *nodes, *drop, *clients, *duration)
+		if err != nil {
+			fail(err)
+		}
+		fmt.Printf("throughput: batch size %d, %d clients: %.0f commands/s (%d decided, %d failed in %s)\n",
+			size, *clients, float64(decided)/duration.Seconds(), decided, failed, *duration)
+	}
+}
+
+// benchThroughput runs a fresh cluster under cfg and counts the commands its
+// leader decides while clients propose concurrently for d.
+func benchThroughput(dir string, cfg paxos.Config, nodes int, drop float64, clients int, d time.Duration) (int64, int64, error) {
+	ctx, stop := context.WithCancel(context.Background())
+	servers, done, err := startCluster(ctx, dir, cfg, nodes, drop)
+	defer func() {
+		stop()
+		if done != nil {
+			<-done
+		}
+	}()
+	if err != nil {
+		return 0, 0, err
+	}
+	leader, err := waitForLeader(servers, 10*time.Second)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var decided, failed atomic.Int64
+	deadline := time.Now().Add(d)
+	var wg sync.WaitGroup
+	for c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; time.Now().Before(deadline); i++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 4*phaseBound(cfg))
+				_, err := leader.Propose(ctx, fmt.Sprintf("client%d-%d", c, i))
+				cancel()
+				if err != nil {
+					failed.Add(1)
+				} else {
+					decided.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return decided.Load(), failed.Load(), nil
+}
+
+// startCluster runs n servers on one memory bus. done is closed once all of them
+// stopped after ctx is done.
+func startCluster(ctx context.Context, dir string, cfg paxos.Config, n int, drop float64) ([]*paxos.Server, <-chan struct{}, error) {
+	bus := paxos.NewMemoryBus()
+	servers := make([]*paxos.Server, n)
+	for i := range servers {
+		cfg := cfg
+		cfg.ID = fmt.Sprintf("node%d", i+1)
+		cfg.DataDir = filepath.Join(dir, cfg.ID)
+		var transport paxos.Transport = bus.Join()
+		if drop > 0 {
+			transport = &lossyTransport{Transport: transport, drop: drop}
+		}
+		server, err := paxos.NewServer(transport, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		servers[i] = server
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{}, n)
+	for _, server := range servers {
+		go func() {
+			server.Run(ctx)
+			stopped <- struct{}{}
+		}()
+	}
+	go func() {
+		for range servers {
+			<-stopped
+		}
+		close(done)
+	}()
+	return servers, done, nil
+}
+
+// waitForLeader returns the server that got elected, found by proposing to each
+// server until one accepts.
+func waitForLeader(servers []*paxos.Server, timeout time.Duration) (*paxos.Server, error) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		for _, server := range servers {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_, err := server.Propose(ctx, "warmup")
+			cancel()
+			if err == nil {
+				return server, nil
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil, errors.New("no leader got elected")
+}
+
+// measureIdle returns the CPU time the process used during d without proposals, so
+// only heartbeats and blocked loops remain.
+func measureIdle(d time.Duration) (time.Duration, int, error) {
+	time.Sleep(100 * time.Millisecond)
+	before, err := cputime.Process()
+	if err != nil {
+		return 0, 0, err
+	}
+	time.Sleep(d)
+	after, err := cputime.Process()
+	if err != nil {
+		return 0, 0, err
+	}
+	return after - before, runtime.NumGoroutine(), nil
+}
+
+// measureProposals proposes n values one after another and returns the latency of
+// each decided one and the number that failed.
+func measureProposals(leader *paxos.Server, n int, cfg paxos.Config) ([]time.Duration, int) {
+	var latencies []time.Duration
+	failures := 0
+	for i := range n {
+		ctx, cancel := context.WithTimeout(context.Background(), 4*phaseBound(cfg))
+		start := time.Now()
+		_, err := leader.Propose(ctx, fmt.Sprintf("value-%d", i))
+		cancel()
+		if err != nil {
+			failures++
+			continue
+		}
+		latencies = append(latencies, time.Since(start))
+	}
+	return latencies, failures
+}
+
+// phaseBound is the longest a phase can take under cfg: every round times out and
+// every backoff is the longest possible.
+func phaseBound(cfg paxos.Config) time.Duration {
+	bound := time.Duration(cfg.MaxRetries) * cfg.PhaseTimeout
+	backoff := cfg.RetryBackoff
+	for range cfg.MaxRetries - 1 {
+		bound += min(backoff, cfg.MaxRetryBackoff)
+		backoff *= 2
+	}
+	return bound
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	slices.Sort(sorted)
+	i := int(p*float64(len(sorted))+.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))].Round(10 * time.Microsecond)
+}
+
+// lossyTransport drops protocol messages at random. Heartbeats always get through
+// so that leadership stays stable and only phases are retried.
+type lossyTransport struct {
+	paxos.Transport
+	drop float64
+}
+
+func (t *lossyTransport) BroadcastToAcceptors(message paxos.QueueMessage) error {
+	if t.lose(message) {
+		return nil
+	}
+	return t.Transport.BroadcastToAcceptors(message)
+}
+
+func (t *lossyTransport) BroadcastToProposers(message paxos.QueueMessage) error {
+	if t.lose(message) {
+		return nil
+	}
+	return t.Transport.BroadcastToProposers(message)
+}
+
+func (t *lossyTransport) lose(message paxos.QueueMessage) bool {
+	return !strings.HasPrefix(message.Type, "HEARTBEAT") && rand.Float64() < t.drop
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,245 @@
+// Command paxosctl proposes values to a Paxos cluster and inspects it: the decided
+// log, the state of every server and its members.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/client"
+)
+
+const usage = `usage: paxosctl [flags] command [args]
+
+commands:
+  propose VALUE        propose VALUE and print the instance it was decided in;
+                       with -instance, print the value decided in that instance
+  get INSTANCE         print the value decided in INSTANCE
+  log [FROM [TO]]      print the decided entries from FROM (default 1) to TO
+  status               print the leader, progress and members each server knows
+  member add ID        add the acceptor ID to the configuration
+  member remove ID     remove the acceptor ID from the configuration
+
+flags:
+`
+
+func main() {
+	nodes := flag.String("nodes", envOr("PAXOS_NODES", "localhost:8080"), "comma-separated host:port addresses of servers (env PAXOS_NODES)")
+	timeout := flag.Duration("timeout", 5*time.Second, "deadline of each request")
+	total := flag.Duration("deadline", 30*time.Second, "deadline of the whole command, retries included")
+	retries := flag.Int("retries", 5, "how often to retry a request that failed for want of a leader")
+	key := flag.String("key", "", "idempotency key of propose; reusing it retries the same proposal (default random)")
+	at := flag.Int("instance", 0, "propose for this instance only instead of appending to the log")
+	asJSON := flag.Bool("json", false, "print JSON instead of text")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var addresses []string
+	for _, node := range strings.Split(*nodes, ",") {
+		if node = strings.TrimSpace(node); node != "" {
+			addresses = append(addresses, node)
+		}
+	}
+	c, err := client.New(client.Config{Nodes: addresses, Timeout: *timeout, MaxRetries: *retries})
+	if err != nil {
+		fail(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *total)
+	defer cancel()
+
+	out := printer{json: *asJSON}
+	args := flag.Args()
+	switch command := args[0]; {
+	case command == "propose" && len(args) == 2 && *at > 0:
+		value, err := c.ProposeAt(ctx, *at, args[1])
+		if errors.Is(err, client.ErrLost) {
+			out.print(map[string]interface{}{"instance": *at, "value": value, "lost": true}, func() {
+				fmt.Printf("lost instance %d to %s\n", *at, format(value))
+			})
+			os.Exit(1)
+		}
+		if err != nil {
+			fail(err)
+		}
+		out.print(map[string]interface{}{"instance": *at, "value": value, "lost": false}, func() {
+			fmt.Printf("decided in instance %d\n", *at)
+		})
+
+	case command == "propose" && len(args) == 2:
+		proposalKey := *key
+		if proposalKey == "" {
+			proposalKey = client.NewKey()
+		}
+		instance, err := c.ProposeWithKey(ctx, proposalKey, args[1])
+		if err != nil {
+			fail(err)
+		}
+		out.print(map[string]interface{}{"instance": instance, "key": proposalKey}, func() {
+			fmt.Printf("decided in instance %d\n", instance)
+		})
+
+	case command == "get" && len(args) == 2:
+		instance := atoi(args[1])
+		value, err := c.Decision(ctx, instance)
+		if err != nil {
+			fail(err)
+		}
+		out.print(map[string]interface{}{"instance": instance, "value": value}, func() {
+			fmt.Println(format(value))
+		})
+
+	case command == "log" && len(args) <= 3:
+		from, to := 1, 0
+		if len(args) > 1 {
+			from = atoi(args[1])
+		}
+		if len(args) > 2 {
+			to = atoi(args[2])
+		}
+		entries, err := c.Log(ctx, from, to)
+		if err != nil {
+			fail(err)
+		}
+		out.print(entries, func() {
+			for _, entry := range entries {
+				fmt.Printf("%d\t%s\n", entry.Instance, format(entry.Value))
+			}
+		})
+
+	case command == "status" && len(args) == 1:
+		if _, err := c.Discover(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, "paxosctl: no leader:", err)
+		}
+		statuses := c.Status(ctx)
+		out.print(statusJSON(statuses), func() {
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "NODE\tID\tLEADER\tLEASE\tLAST\tAPPLIED\tMEMBERS")
+			for _, s := range statuses {
+				if s.Err != nil {
+					fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\t%s\n", s.Node, s.Err)
+					continue
+				}
+				leader := s.Leader.LeaderID
+				if s.Leader.IsLeader {
+					leader += " (self)"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%d\t%d\t%s\n", s.Node, s.Leader.ID, leader, s.Leader.HasLease,
+					s.Leader.LastInstance, s.Leader.Applied, members(s.Members))
+			}
+			w.Flush()
+		})
+		for _, s := range statuses {
+			if s.Err != nil {
+				os.Exit(1)
+			}
+		}
+
+	case command == "member" && len(args) == 3 && (args[1] == "add" || args[1] == "remove"):
+		change := c.AddMember
+		if args[1] == "remove" {
+			change = c.RemoveMember
+		}
+		config, err := change(ctx, args[2])
+		if err != nil {
+			fail(err)
+		}
+		out.print(config, func() {
+			fmt.Println("members:", strings.Join(config.Members, ","))
+		})
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// printer prints either v as JSON or the text text prints.
+type printer struct {
+	json bool
+}
+
+func (p printer) print(v interface{}, text func()) {
+	if !p.json {
+		text()
+		return
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		fail(err)
+	}
+}
+
+// format prints strings as they are and other values as JSON.
+func format(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(b)
+}
+
+// statusJSON returns statuses with their errors as text, which JSON cannot encode
+// otherwise.
+func statusJSON(statuses []client.NodeStatus) []map[string]interface{} {
+	result := make([]map[string]interface{}, len(statuses))
+	for i, s := range statuses {
+		result[i] = map[string]interface{}{"node": s.Node}
+		if s.Err != nil {
+			result[i]["error"] = s.Err.Error()
+			continue
+		}
+		result[i]["leader"] = s.Leader
+		result[i]["members"] = s.Members
+	}
+	return result
+}
+
+func members(m client.Members) string {
+	s := strings.Join(m.Current.Members, ",")
+	if s == "" {
+		s = fmt.Sprintf("any %d", m.Current.Size)
+	}
+	if !m.Latest.Equal(m.Current) {
+		s += " -> " + strings.Join(m.Latest.Members, ",")
+	}
+	return s
+}
+
+func atoi(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		fail(fmt.Errorf("invalid instance %q", s))
+	}
+	return n
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "paxosctl:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,54 @@
+// Command paxoskeys generates the keys servers sign their messages with. It prints
+// the line to add to the keys file every server reads, and for Ed25519 writes the
+// private key to the file only the server itself reads.
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	kind := flag.String("type", "ed25519", "key type: hmac or ed25519")
+	id := flag.String("id", "", "ID of the server the key is for")
+	private := flag.String("private", "", "file to write the Ed25519 private key to (default ID.key)")
+	flag.Parse()
+	if *id == "" {
+		fmt.Fprintln(os.Stderr, "paxoskeys: -id is required")
+		os.Exit(2)
+	}
+
+	switch *kind {
+	case "hmac":
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			fail(err)
+		}
+		fmt.Printf("%s %s\n", *id, base64.StdEncoding.EncodeToString(key))
+	case "ed25519":
+		public, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			fail(err)
+		}
+		path := *private
+		if path == "" {
+			path = *id + ".key"
+		}
+		encoded := base64.StdEncoding.EncodeToString(privateKey.Seed()) + "\n"
+		if err := os.WriteFile(path, []byte(encoded), 0o600); err != nil {
+			fail(err)
+		}
+		fmt.Printf("%s %s\n", *id, base64.StdEncoding.EncodeToString(public))
+	default:
+		fail(fmt.Errorf("unknown key type %q", *kind))
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "paxoskeys:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/paxos"
+	"github.com/beka-birhanu/paxos-lab-activity2/sim"
+)
+
+func main() {
+	defaults := sim.DefaultConfig(1)
+	seed := flag.Int64("seed", 1, "first seed to simulate")
+	runs := flag.Int("runs", 100, "number of consecutive seeds to simulate")
+	verbose := flag.Bool("v", false, "print every simulated event")
+	acceptors := flag.Int("acceptors", defaults.Acceptors, "number of acceptors")
+	proposers := flag.Int("proposers", defaults.Proposers, "number of proposers")
+	instances := flag.Int("instances", defaults.Instances, "log instances each proposer fills")
+	drop := flag.Float64("drop", defaults.DropRate, "message drop probability")
+	duplicate := flag.Float64("dup", defaults.DuplicateRate, "message duplication probability")
+	minDelay := flag.Int64("min-delay", defaults.MinDelay, "minimum virtual message delay in ms")
+	maxDelay := flag.Int64("max-delay", defaults.MaxDelay, "maximum virtual message delay in ms")
+	partition := flag.Float64("partition", defaults.PartitionRate, "probability of splitting or healing the network per fault interval")
+	crash := flag.Float64("crash", defaults.CrashRate, "probability of crashing a node per fault interval")
+	volatile := flag.Bool("volatile", false, "run acceptors without a write-ahead log")
+	fast := flag.Bool("fast", false, "send values in fast ballots and recover collisions in classic rounds")
+	phase1 := flag.Int("phase1", 0, "acceptors phase 1 needs (default majority)")
+	phase2 := flag.Int("phase2", 0, "acceptors phase 2 needs (default majority)")
+	flag.Parse()
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	failures := 0
+	for i := range *runs {
+		cfg := sim.DefaultConfig(*seed + int64(i))
+		cfg.Acceptors = *acceptors
+		cfg.Proposers = *proposers
+		cfg.Instances = *instances
+		cfg.DropRate = *drop
+		cfg.DuplicateRate = *duplicate
+		cfg.MinDelay = *minDelay
+		cfg.MaxDelay = *maxDelay
+		cfg.PartitionRate = *partition
+		cfg.CrashRate = *crash
+		cfg.Volatile = *volatile
+		cfg.FastRounds = *fast
+		if *phase1 > 0 || *phase2 > 0 {
+			cfg.Quorum = &paxos.QuorumSpec{Kind: paxos.FlexibleQuorum, Phase1: *phase1, Phase2: *phase2}
+		}
+		if *verbose {
+			cfg.Trace = os.Stdout
+		}
+
+		result, err := sim.Run(cfg)
+		if err != nil {
+			fmt.Printf("seed %d: simulation failed: %s\n", cfg.Seed, err)
+			os.Exit(2)
+		}
+		if result.Violation != nil {
+			failures++
+			fmt.Printf("seed %d: SAFETY VIOLATION at t=%d: %s\n", cfg.Seed, result.Time, result.Violation)
+			fmt.Printf("  replay with: paxossim -seed %d -runs 1 -v\n", cfg.Seed)
+			continue
+		}
+		fmt.Printf("seed %d: ok, %d instances chosen by t=%d, %+v\n", cfg.Seed, len(result.Chosen), result.Time, result.Stats)
+	}
+
+	fmt.Printf("%d/%d runs violated safety\n", failures, *runs)
+	if failures > 0 {
+		os.Exit(1)
+	}
+}
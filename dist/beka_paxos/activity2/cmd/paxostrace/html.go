@@ -0,0 +1,64 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"strings"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/paxos"
+)
+
+// writeHTML renders the timeline as a sequence diagram: one column per node and
+// one row per event, in Lamport clock order.
+func (t *timeline) writeHTML(w io.Writer) error {
+	return page.Execute(w, t)
+}
+
+var page = template.Must(template.New("trace").Funcs(template.FuncMap{
+	"offset":   func(t *timeline, e paxos.TraceEvent) string { return t.offset(e) },
+	"describe": describe,
+	"keys":     sortedKeys[[]string],
+	"join":     strings.Join,
+	"lower":    strings.ToLower,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Paxos trace</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ddd; padding: 2px 8px; font-size: 13px; white-space: nowrap; }
+th { background: #f4f4f4; }
+td.meta { color: #888; }
+.event { padding: 1px 4px; border-radius: 3px; }
+.prepare { background: #dbeafe; } .promise { background: #dcfce7; }
+.accept { background: #fef3c7; } .accepted { background: #fde68a; }
+.nack { background: #fecaca; } .decide { background: #c7d2fe; }
+</style>
+</head>
+<body>
+<h1>Paxos trace</h1>
+{{$t := .}}
+{{range .Ballots}}
+<h2>Ballot {{.Number.BallotNumber}} by {{.Number.ProposerID}}</h2>
+<table>
+<tr><th>clock</th><th>time</th>{{range $t.Nodes}}<th>{{.}}</th>{{end}}</tr>
+{{range $e := .Events}}
+<tr><td class="meta">{{$e.Clock}}</td><td class="meta">{{offset $t $e}}</td>
+{{range $t.Nodes}}<td>{{if eq . $e.Node}}<span class="event {{lower $e.Type}}">{{describe $e}}</span>{{end}}</td>{{end}}
+</tr>
+{{end}}
+</table>
+{{$accepted := .Accepted}}
+<ul>{{range keys .Accepted}}<li>instance {{.}} accepted by {{join (index $accepted .) ", "}}</li>{{end}}</ul>
+{{end}}
+<h2>Decisions</h2>
+<table>
+<tr><th>clock</th><th>time</th><th>node</th><th>instance</th><th>value</th></tr>
+{{range .Decisions}}<tr><td class="meta">{{.Clock}}</td><td class="meta">{{offset $t .}}</td><td>{{.Node}}</td><td>{{.Instance}}</td><td>{{.Value}}</td></tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
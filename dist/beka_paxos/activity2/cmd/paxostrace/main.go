@@ -0,0 +1,208 @@
+// Command paxostrace merges the JSON-lines traces written by several Paxos servers
+// and renders one timeline per ballot, as text or as an HTML sequence diagram.
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/paxos"
+)
+
+func main() {
+	format := flag.String("format", "text", "output format: text or html")
+	output := flag.String("o", "", "file to write to instead of stdout")
+	instance := flag.Int("instance", 0, "only show events of this log instance")
+	heartbeats := flag.Bool("heartbeats", false, "include heartbeat traffic")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: paxostrace [flags] trace.jsonl...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var events []paxos.TraceEvent
+	for _, path := range flag.Args() {
+		trace, err := readTrace(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			os.Exit(1)
+		}
+		events = append(events, trace...)
+	}
+	events = slices.DeleteFunc(events, func(e paxos.TraceEvent) bool {
+		if !*heartbeats && strings.HasPrefix(e.Type, "HEARTBEAT") {
+			return true
+		}
+		return *instance != 0 && e.Instance != *instance
+	})
+	t := newTimeline(events)
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	var err error
+	switch *format {
+	case "text":
+		err = t.writeText(w)
+	case "html":
+		err = t.writeHTML(w)
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func readTrace(path string) ([]paxos.TraceEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return paxos.ReadTrace(file)
+}
+
+// timeline holds merged events ordered by Lamport clock, grouped by ballot.
+type timeline struct {
+	Start     time.Time
+	Nodes     []string
+	Ballots   []*ballotGroup
+	Decisions []paxos.TraceEvent
+}
+
+type ballotGroup struct {
+	Number paxos.ProposalNumber
+	Events []paxos.TraceEvent
+	// Accepted lists, per instance, the acceptors that accepted this ballot.
+	Accepted map[int][]string
+}
+
+func newTimeline(events []paxos.TraceEvent) *timeline {
+	// Lamport clocks order causally related events; wall clock and node break ties
+	// between concurrent ones.
+	slices.SortStableFunc(events, func(a, b paxos.TraceEvent) int {
+		if a.Clock != b.Clock {
+			return cmp.Compare(a.Clock, b.Clock)
+		}
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Compare(b.Time)
+		}
+		return strings.Compare(a.Node, b.Node)
+	})
+
+	t := &timeline{}
+	groups := make(map[paxos.ProposalNumber]*ballotGroup)
+	for _, e := range events {
+		if t.Start.IsZero() || e.Time.Before(t.Start) {
+			t.Start = e.Time
+		}
+		if !slices.Contains(t.Nodes, e.Node) {
+			t.Nodes = append(t.Nodes, e.Node)
+		}
+		if e.Event == paxos.TraceDecide {
+			t.Decisions = append(t.Decisions, e)
+			continue
+		}
+		if e.Ballot == nil {
+			continue
+		}
+
+		group, ok := groups[*e.Ballot]
+		if !ok {
+			group = &ballotGroup{Number: *e.Ballot, Accepted: make(map[int][]string)}
+			groups[*e.Ballot] = group
+			t.Ballots = append(t.Ballots, group)
+		}
+		group.Events = append(group.Events, e)
+		if e.Event == paxos.TraceSend && e.Type == "ACCEPTED" && !slices.Contains(group.Accepted[e.Instance], e.Node) {
+			group.Accepted[e.Instance] = append(group.Accepted[e.Instance], e.Node)
+		}
+	}
+
+	slices.Sort(t.Nodes)
+	slices.SortFunc(t.Ballots, func(a, b *ballotGroup) int {
+		if a.Number.GreaterThan(b.Number) {
+			return 1
+		}
+		if b.Number.GreaterThan(a.Number) {
+			return -1
+		}
+		return 0
+	})
+	return t
+}
+
+func (t *timeline) offset(e paxos.TraceEvent) string {
+	return fmt.Sprintf("+%.1fms", float64(e.Time.Sub(t.Start).Microseconds())/1000)
+}
+
+func (t *timeline) writeText(w io.Writer) error {
+	for _, group := range t.Ballots {
+		fmt.Fprintf(w, "== Ballot %d by %s ==\n", group.Number.BallotNumber, group.Number.ProposerID)
+		for _, e := range group.Events {
+			fmt.Fprintf(w, "%6d %10s  %-8s %s\n", e.Clock, t.offset(e), e.Node, describe(e))
+		}
+		for _, instance := range sortedKeys(group.Accepted) {
+			fmt.Fprintf(w, "  instance %d accepted by %s\n", instance, strings.Join(group.Accepted[instance], ", "))
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w, "== Decisions ==")
+	for _, e := range t.Decisions {
+		fmt.Fprintf(w, "%6d %10s  %-8s instance %d = %v\n", e.Clock, t.offset(e), e.Node, e.Instance, e.Value)
+	}
+	return nil
+}
+
+// describe renders what happened in e from the point of view of its node.
+func describe(e paxos.TraceEvent) string {
+	var b strings.Builder
+	switch e.Event {
+	case paxos.TraceSend:
+		fmt.Fprintf(&b, "--> %-9s", e.Type)
+	case paxos.TraceReceive:
+		fmt.Fprintf(&b, "<-- %-9s from %s", e.Type, e.Peer)
+	default:
+		b.WriteString(e.Event)
+	}
+	if e.Instance != 0 {
+		fmt.Fprintf(&b, " instance %d", e.Instance)
+	}
+	if e.Promised != nil {
+		fmt.Fprintf(&b, " promised %d/%s", e.Promised.BallotNumber, e.Promised.ProposerID)
+	}
+	if e.Value != nil {
+		fmt.Fprintf(&b, " value %v", e.Value)
+	}
+	return b.String()
+}
+
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/paxos"
+)
+
+// options are the settings of one node: the server configuration and how the node
+// reaches the rest of the cluster.
+type options struct {
+	paxos.Config
+	Transport     string
+	BrokerURL     string
+	TCPListenAddr string
+	TCPPeers      []string
+	// Auth is how messages are signed: none, hmac or ed25519. AuthKeys names a file
+	// of the HMAC or Ed25519 public keys of all servers, one "ID base64-key" per line,
+	// and AuthPrivateKey one holding this server's base64 Ed25519 private key.
+	Auth           string
+	AuthKeys       string
+	AuthPrivateKey string
+}
+
+// setting is one option as it is named in the config file, the environment and
+// on the command line.
+type setting struct {
+	key   string
+	env   string
+	usage string
+	value flag.Value
+}
+
+func (o *options) settings() []setting {
+	return []setting{
+		{"id", "SERVER_ID", "unique ID of this server", (*stringValue)(&o.ID)},
+		{"members", "MEMBERS", "comma-separated IDs of the initial acceptors", (*listValue)(&o.Members)},
+		{"acceptors", "NUMBER_OF_ACCEPTOR", "number of acceptors when no members are listed", (*intValue)(&o.NumberOfAccepters)},
+		{"quorum", "QUORUM", "quorum system: majority, flexible, grid or weighted", (*stringValue)(&o.Quorum.Kind)},
+		{"phase1_quorum", "PHASE1_QUORUM", "votes phase 1 needs under flexible and weighted quorums (default majority)", (*intValue)(&o.Quorum.Phase1)},
+		{"phase2_quorum", "PHASE2_QUORUM", "votes phase 2 needs under flexible and weighted quorums (default majority)", (*intValue)(&o.Quorum.Phase2)},
+		{"quorum_grid", "QUORUM_GRID", "rows of the quorum grid separated by ';', the IDs of a row by ','", (*gridValue)(&o.Quorum.Grid)},
+		{"quorum_weights", "QUORUM_WEIGHTS", "comma-separated ID=votes of weighted quorums", (*weightsValue)(&o.Quorum.Weights)},
+		{"listen_addr", "LISTEN_ADDR", "address the HTTP API listens on", (*stringValue)(&o.ListenAddr)},
+		{"advertise_addr", "ADVERTISE_ADDR", "host:port other servers reach the HTTP API on (default hostname and listen port)", (*stringValue)(&o.Address)},
+		{"data_dir", "DATA_DIR", "directory of the write-ahead log and snapshots", (*stringValue)(&o.DataDir)},
+		{"trace_file", "TRACE_FILE", "file to append protocol trace events to", (*stringValue)(&o.TraceFile)},
+		{"transport", "TRANSPORT", "how servers talk to each other: amqp or tcp", (*stringValue)(&o.Transport)},
+		{"broker_url", "BROKER_URL", "AMQP URL of the broker (default built from RABBITMQ_HOST, _PORT, _USER and _PASS)", (*stringValue)(&o.BrokerURL)},
+		{"tcp_listen_addr", "TCP_LISTEN_ADDR", "address the TCP transport listens on", (*stringValue)(&o.TCPListenAddr)},
+		{"tcp_peers", "TCP_PEERS", "comma-separated TCP transport addresses of the other servers", (*listValue)(&o.TCPPeers)},
+		{"auth", "AUTH", "how messages are signed: none, hmac or ed25519", (*stringValue)(&o.Auth)},
+		{"auth_keys", "AUTH_KEYS", "file of the HMAC or Ed25519 public keys of all servers", (*stringValue)(&o.AuthKeys)},
+		{"auth_private_key", "AUTH_PRIVATE_KEY", "file of this server's Ed25519 private key", (*stringValue)(&o.AuthPrivateKey)},
+		{"wire_version", "WIRE_VERSION", "highest wire version to send: 1 for JSON, 2 for binary", (*intValue)(&o.WireVersion)},
+		{"reconfig_window", "RECONFIG_WINDOW", "instances after which a reconfiguration takes effect", (*intValue)(&o.ReconfigWindow)},
+		{"propose_timeout", "PROPOSE_TIMEOUT", "how long a request waits for its proposal", (*durationValue)(&o.ProposeTimeout)},
+		{"shutdown_timeout", "SHUTDOWN_TIMEOUT", "how long a shutdown waits for work in flight", (*durationValue)(&o.ShutdownTimeout)},
+		{"heartbeat_interval", "HEARTBEAT_INTERVAL", "how often the leader renews its lease", (*durationValue)(&o.HeartbeatInterval)},
+		{"election_timeout", "ELECTION_TIMEOUT", "how long to wait for a leader before campaigning", (*durationValue)(&o.ElectionTimeout)},
+		{"lease_duration", "LEASE_DURATION", "how long a leader lease lasts", (*durationValue)(&o.LeaseDuration)},
+		{"phase_timeout", "PHASE_TIMEOUT", "deadline of one prepare or accept round", (*durationValue)(&o.PhaseTimeout)},
+		{"max_retries", "MAX_RETRIES", "rounds per phase before a proposal fails", (*intValue)(&o.MaxRetries)},
+		{"retry_backoff", "RETRY_BACKOFF", "backoff before the first retry of a round", (*durationValue)(&o.RetryBackoff)},
+		{"max_retry_backoff", "MAX_RETRY_BACKOFF", "upper bound of the retry backoff", (*durationValue)(&o.MaxRetryBackoff)},
+		{"batch_size", "BATCH_SIZE", "values proposed together in one log entry at most; 1 turns batching off", (*intValue)(&o.BatchSize)},
+		{"flush_interval", "FLUSH_INTERVAL", "how long a batch waits for more values", (*durationValue)(&o.FlushInterval)},
+		{"pipeline_depth", "PIPELINE_DEPTH", "log entries proposed at a time at most", (*intValue)(&o.PipelineDepth)},
+		{"fast_rounds", "FAST_ROUNDS", "send values straight to the acceptors in fast ballots; each server batches the values it sends, and reads skip the leader lease", (*boolValue)(&o.FastRounds)},
+		{"snapshot_threshold", "SNAPSHOT_THRESHOLD", "applied entries that trigger a snapshot", (*intValue)(&o.SnapshotThreshold)},
+		{"snapshot_interval", "SNAPSHOT_INTERVAL", "how often to check whether to snapshot", (*durationValue)(&o.SnapshotInterval)},
+		{"snapshot_retain", "SNAPSHOT_RETAIN", "log entries kept before a snapshot", (*intValue)(&o.SnapshotRetain)},
+	}
+}
+
+// loadOptions builds the options from, in increasing precedence, the defaults, the
+// config file, the environment and the command-line flags.
+func loadOptions(args []string) (*options, error) {
+	o := &options{
+		Config:        paxos.Config{ListenAddr: ":8080"},
+		Transport:     "amqp",
+		TCPListenAddr: ":9090",
+		Auth:          "none",
+	}
+	settings := o.settings()
+
+	flags := flag.NewFlagSet("paxos", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "TOML file to read settings from (env CONFIG_FILE)")
+	for _, s := range settings {
+		flags.Var(s.value, strings.ReplaceAll(s.key, "_", "-"), fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	// The flags were parsed into the options already, so they are applied once more
+	// after the file and the environment overwrote them.
+	set := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = f.Value.String() })
+
+	if *configFile != "" {
+		if err := readConfigFile(*configFile, settings); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := s.value.Set(v); err != nil {
+				return nil, fmt.Errorf("invalid %s value %q: %w", s.env, v, err)
+			}
+		}
+	}
+	for name, v := range set {
+		flags.Set(name, v)
+	}
+
+	if err := o.complete(); err != nil {
+		return nil, err
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	authenticator, err := o.authenticator()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load keys: %w", err)
+	}
+	o.Authenticator = authenticator
+	return o, nil
+}
+
+// complete fills in the settings that default to values derived from others.
+func (o *options) complete() error {
+	if o.Transport == "" {
+		o.Transport = "amqp"
+	}
+	if o.BrokerURL == "" && o.Transport == "amqp" {
+		host, port := os.Getenv("RABBITMQ_HOST"), os.Getenv("RABBITMQ_PORT")
+		if host != "" && port != "" {
+			o.BrokerURL = fmt.Sprintf("amqp://%s:%s@%s/", os.Getenv("RABBITMQ_USER"), os.Getenv("RABBITMQ_PASS"), net.JoinHostPort(host, port))
+		}
+	}
+	if o.Address == "" {
+		_, port, err := net.SplitHostPort(o.ListenAddr)
+		if err != nil {
+			return fmt.Errorf("invalid listen address %q: %w", o.ListenAddr, err)
+		}
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("advertise address is not set and hostname is unknown: %w", err)
+		}
+		o.Address = net.JoinHostPort(hostname, port)
+	}
+	return nil
+}
+
+func (o *options) validate() error {
+	errs := []error{o.Config.Validate()}
+	switch o.Transport {
+	case "amqp":
+		if o.BrokerURL == "" {
+			errs = append(errs, errors.New("broker URL is not set"))
+		}
+	case "tcp":
+		if _, _, err := net.SplitHostPort(o.TCPListenAddr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid TCP listen address %q: %w", o.TCPListenAddr, err))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown transport %q", o.Transport))
+	}
+	switch o.Auth {
+	case "", "none":
+	case "hmac", "ed25519":
+		if o.AuthKeys == "" {
+			errs = append(errs, fmt.Errorf("%s authentication needs a keys file", o.Auth))
+		}
+		if o.Auth == "ed25519" && o.AuthPrivateKey == "" {
+			errs = append(errs, errors.New("ed25519 authentication needs a private key file"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown authentication %q", o.Auth))
+	}
+	return errors.Join(errs...)
+}
+
+// authenticator returns the authenticator Auth selects, or nil for none.
+func (o *options) authenticator() (paxos.Authenticator, error) {
+	if o.Auth == "" || o.Auth == "none" {
+		return nil, nil
+	}
+	file, err := os.Open(o.AuthKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	keys, err := paxos.ReadKeys(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", o.AuthKeys, err)
+	}
+	if o.Auth == "hmac" {
+		return paxos.NewHMACAuthenticator(o.ID, keys)
+	}
+
+	public := make(map[string]ed25519.PublicKey, len(keys))
+	for id, key := range keys {
+		if len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%s: public key of %s has %d bytes", o.AuthKeys, id, len(key))
+		}
+		public[id] = key
+	}
+	encoded, err := os.ReadFile(o.AuthPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", o.AuthPrivateKey, err)
+	}
+	var private ed25519.PrivateKey
+	switch len(seed) {
+	case ed25519.SeedSize:
+		private = ed25519.NewKeyFromSeed(seed)
+	case ed25519.PrivateKeySize:
+		private = seed
+	default:
+		return nil, fmt.Errorf("%s: private key has %d bytes", o.AuthPrivateKey, len(seed))
+	}
+	if !private.Public().(ed25519.PublicKey).Equal(public[o.ID]) {
+		return nil, fmt.Errorf("%s does not list the public key of %s's private key", o.AuthKeys, o.ID)
+	}
+	return paxos.NewEd25519Authenticator(o.ID, private, public), nil
+}
+
+// readConfigFile applies the settings of a flat TOML file: key = value lines, where
+// values are strings, integers or arrays of strings, and durations are strings
+// such as "300ms".
+func readConfigFile(path string, settings []setting) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	byKey := make(map[string]flag.Value, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s.value
+	}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+		key, raw, ok := strings.Cut(text, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected key = value", path, line)
+		}
+		key = strings.TrimSpace(key)
+		value, ok := byKey[key]
+		if !ok {
+			return fmt.Errorf("%s:%d: unknown setting %q", path, line, key)
+		}
+		v, err := parseTOMLValue(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", path, line, key, err)
+		}
+		if err := value.Set(v); err != nil {
+			return fmt.Errorf("%s:%d: invalid %s value %q: %w", path, line, key, v, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// stripComment cuts line at the first # outside a string.
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseTOMLValue returns the text a flag would take for raw: the unquoted string,
+// the integer or boolean, the comma-joined elements of an array, or the rows of
+// an array of arrays joined by semicolons.
+func parseTOMLValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, "["):
+		return parseTOMLArray(raw, true)
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", errors.New("unterminated string")
+		}
+		return raw[1 : len(raw)-1], nil
+	case raw == "true" || raw == "false":
+		return raw, nil
+	default:
+		if _, err := strconv.Atoi(raw); err != nil {
+			return "", fmt.Errorf("unsupported value %s", raw)
+		}
+		return raw, nil
+	}
+}
+
+// parseTOMLArray returns the elements of the array raw joined by commas. If nested
+// is set, an array of arrays is accepted as well and its rows are joined by
+// semicolons.
+func parseTOMLArray(raw string, nested bool) (string, error) {
+	if !strings.HasSuffix(raw, "]") {
+		return "", errors.New("arrays must be on one line")
+	}
+	elems, err := splitTOMLArray(raw[1 : len(raw)-1])
+	if err != nil {
+		return "", err
+	}
+	rows := len(elems) > 0 && strings.HasPrefix(elems[0], "[")
+	values := make([]string, 0, len(elems))
+	for _, elem := range elems {
+		var v string
+		switch {
+		case strings.HasPrefix(elem, "[") != rows:
+			return "", errors.New("arrays cannot mix arrays and values")
+		case rows && !nested:
+			return "", errors.New("arrays nested more than twice are not supported")
+		case rows:
+			v, err = parseTOMLArray(elem, false)
+		default:
+			v, err = parseTOMLValue(elem)
+		}
+		if err != nil {
+			return "", err
+		}
+		if !rows && strings.ContainsAny(v, ",;") {
+			return "", fmt.Errorf("array element %s contains a comma or semicolon", elem)
+		}
+		values = append(values, v)
+	}
+	if rows {
+		return strings.Join(values, ";"), nil
+	}
+	return strings.Join(values, ","), nil
+}
+
+// splitTOMLArray splits the inside of an array at the commas outside strings and
+// nested arrays. Blank elements, as left by a trailing comma, are dropped.
+func splitTOMLArray(raw string) ([]string, error) {
+	var elems []string
+	var quote rune
+	depth, start := 0, 0
+	add := func(elem string) {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			elems = append(elems, elem)
+		}
+	}
+	for i, r := range raw {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '[':
+			depth++
+		case r == ']':
+			if depth--; depth < 0 {
+				return nil, errors.New("unbalanced brackets")
+			}
+		case r == ',' && depth == 0:
+			add(raw[start:i])
+			start = i + 1
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated string")
+	}
+	if depth != 0 {
+		return nil, errors.New("unbalanced brackets")
+	}
+	add(raw[start:])
+	return elems, nil
+}
+
+type stringValue string
+
+func (v *stringValue) String() string     { return string(*v) }
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+
+type intValue int
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v = intValue(n)
+	return nil
+}
+
+// boolValue may be given as a bare flag, like -fast-rounds.
+type boolValue bool
+
+func (v *boolValue) String() string   { return strconv.FormatBool(bool(*v)) }
+func (v *boolValue) IsBoolFlag() bool { return true }
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*v = boolValue(b)
+	return nil
+}
+
+type durationValue time.Duration
+
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*v = durationValue(d)
+	return nil
+}
+
+// listValue is a comma-separated list; blank elements are dropped.
+type listValue []string
+
+func (v *listValue) String() string { return strings.Join(*v, ",") }
+func (v *listValue) Set(s string) error {
+	*v = nil
+	for _, elem := range strings.Split(s, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			*v = append(*v, elem)
+		}
+	}
+	return nil
+}
+
+// gridValue is a list of rows separated by semicolons, each a listValue.
+type gridValue [][]string
+
+func (v *gridValue) String() string {
+	rows := make([]string, len(*v))
+	for i, row := range *v {
+		rows[i] = strings.Join(row, ",")
+	}
+	return strings.Join(rows, ";")
+}
+
+func (v *gridValue) Set(s string) error {
+	*v = nil
+	for _, elem := range strings.Split(s, ";") {
+		var row listValue
+		row.Set(elem)
+		if len(row) > 0 {
+			*v = append(*v, row)
+		}
+	}
+	return nil
+}
+
+// weightsValue is a comma-separated list of ID=n pairs.
+type weightsValue map[string]int
+
+func (v *weightsValue) String() string {
+	pairs := make([]string, 0, len(*v))
+	for id, n := range *v {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", id, n))
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v *weightsValue) Set(s string) error {
+	weights := make(map[string]int)
+	var pairs listValue
+	pairs.Set(s)
+	for _, pair := range pairs {
+		id, n, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("expected ID=votes, got %q", pair)
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(n))
+		if err != nil {
+			return err
+		}
+		weights[strings.TrimSpace(id)] = weight
+	}
+	*v = weights
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a config file with the given lines and returns its path.
+func writeConfig(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "paxos.toml")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadOptionsPrecedence(t *testing.T) {
+	file := writeConfig(t,
+		`id = "file"`,
+		`acceptors = 3`,
+		`advertise_addr = "localhost:8080"`,
+		`transport = "tcp"`,
+		`phase_timeout = "1s"`,
+	)
+	tests := []struct {
+		name        string
+		env         map[string]string
+		args        []string
+		wantID      string
+		wantTimeout time.Duration
+	}{
+		{"file", nil, nil, "file", time.Second},
+		{"env over file", map[string]string{"SERVER_ID": "env", "PHASE_TIMEOUT": "2s"}, nil, "env", 2 * time.Second},
+		{"flag over env", map[string]string{"SERVER_ID": "env", "PHASE_TIMEOUT": "2s"},
+			[]string{"-id", "flag"}, "flag", 2 * time.Second},
+		{"flag over file", nil, []string{"-phase-timeout", "3s"}, "file", 3 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			o, err := loadOptions(append([]string{"-config", file}, tt.args...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if o.ID != tt.wantID || o.PhaseTimeout != tt.wantTimeout {
+				t.Fatalf("id %q, phase timeout %s; want %q, %s", o.ID, o.PhaseTimeout, tt.wantID, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestLoadOptionsValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   []string
+		wantErr string
+	}{
+		{"missing ID", []string{`acceptors = 3`}, "server ID is not set"},
+		{"unknown transport", []string{`id = "n"`, `acceptors = 3`, `transport = "udp"`}, `unknown transport "udp"`},
+		{"amqp without broker", []string{`id = "n"`, `acceptors = 3`, `transport = "amqp"`}, "broker URL is not set"},
+		{"bad duration", []string{`id = "n"`, `phase_timeout = "soon"`}, "invalid phase_timeout value"},
+		{"unknown setting", []string{`color = "blue"`}, `unknown setting "color"`},
+		{"no value", []string{`id`}, "expected key = value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := writeConfig(t, append([]string{`advertise_addr = "localhost:8080"`}, tt.lines...)...)
+			_, err := loadOptions([]string{"-config", file})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	var o options
+	file := writeConfig(t,
+		`# a comment`,
+		`id = "node#1" # the # in the string stays`,
+		`members = ["a", 'b', "c",]`,
+		`tcp_peers = []`,
+		`max_retries = 7`,
+		`quorum_grid = [["a", "b"], ["c"]]`,
+	)
+	if err := readConfigFile(file, o.settings()); err != nil {
+		t.Fatal(err)
+	}
+	if o.ID != "node#1" || !reflect.DeepEqual(o.Members, []string{"a", "b", "c"}) ||
+		o.TCPPeers != nil || o.MaxRetries != 7 ||
+		!reflect.DeepEqual(o.Quorum.Grid, [][]string{{"a", "b"}, {"c"}}) {
+		t.Fatalf("read %+v", o)
+	}
+}
+
+func TestParseTOMLValue(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr string
+	}{
+		{raw: `"a b"`, want: "a b"},
+		{raw: `"tab\t"`, want: "tab\t"},
+		{raw: `'C:\path'`, want: `C:\path`},
+		{raw: `42`, want: "42"},
+		{raw: `["x", "y"]`, want: "x,y"},
+		{raw: `[ "x" , 'y' , ]`, want: "x,y"},
+		{raw: `[]`, want: ""},
+		{raw: `[1, 2]`, want: "1,2"},
+		{raw: `1.5`, wantErr: "unsupported value"},
+		{raw: `true`, want: "true"},
+		{raw: `yes`, wantErr: "unsupported value"},
+		{raw: `'open`, wantErr: "unterminated string"},
+		{raw: `["a",`, wantErr: "arrays must be on one line"},
+		{raw: `[["a", "b"], ["c"]]`, want: "a,b;c"},
+		{raw: `[ ["a"] , [] , ]`, want: "a;"},
+		{raw: `[["a"], "b"]`, wantErr: "cannot mix arrays and values"},
+		{raw: `["a", ["b"]]`, wantErr: "cannot mix arrays and values"},
+		{raw: `[[["a"]]]`, wantErr: "nested more than twice"},
+		{raw: `["a,b"]`, wantErr: "contains a comma"},
+		{raw: `["a;b"]`, wantErr: "contains a comma or semicolon"},
+		{raw: `["a]`, wantErr: "unterminated string"},
+	}
+	for _, tt := range tests {
+		got, err := parseTOMLValue(tt.raw)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseTOMLValue(%s) = %q, %v; want error containing %q", tt.raw, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseTOMLValue(%s) = %q, %v; want %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	o, err := loadOptions([]string{"-config", "paxos.example.toml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.ID != "node1" || len(o.TCPPeers) != 2 {
+		t.Fatalf("read %+v", o)
+	}
+}
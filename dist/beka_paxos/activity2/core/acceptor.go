@@ -0,0 +1,229 @@
+package core
+
+import (
+	"log"
+	"slices"
+)
+
+// Kinds of Record.
+const (
+	PromiseRecord = "PROMISE"
+	AcceptRecord  = "ACCEPT"
+	CompactRecord = "COMPACT"
+)
+
+// Record is a change of acceptor state. Replaying the records an acceptor made
+// restores it.
+type Record struct {
+	Type           string         `json:"type"`
+	Instance       int            `json:"instance,omitempty"`
+	ProposalNumber ProposalNumber `json:"proposal_number"`
+	Value          interface{}    `json:"value,omitempty"`
+}
+
+// Storage makes records durable.
+type Storage interface {
+	Append(record Record) error
+}
+
+type acceptedProposal struct {
+	number ProposalNumber
+	value  interface{}
+}
+
+// Acceptor promises ballots for the whole log and accepts values per instance. It
+// is not safe for concurrent use.
+type Acceptor struct {
+	id             string
+	storage        Storage
+	promisedNumber ProposalNumber
+	accepted       map[int]acceptedProposal
+	lastInstance   int
+	compacted      int
+	// fast is the fast ballot the coordinator opened last. It stays open while it
+	// is the promised ballot and is not persisted: an acceptor that restarts only
+	// stops accepting client values until the coordinator resends it.
+	fast Any
+}
+
+// NewAcceptor creates an acceptor that appends every change to storage before
+// answering the message that caused it. A nil storage keeps the state in memory only.
+func NewAcceptor(id string, storage Storage) *Acceptor {
+	return &Acceptor{
+		id:       id,
+		storage:  storage,
+		accepted: make(map[int]acceptedProposal),
+	}
+}
+
+// Restore applies records, read back from storage, to the acceptor.
+func (a *Acceptor) Restore(records []Record) {
+	for _, record := range records {
+		a.apply(record)
+	}
+}
+
+// HandlePrepare processes p and returns the promise to send back, if any.
+func (a *Acceptor) HandlePrepare(p Prepare) (Promise, bool) {
+	if p.ProposalNumber.BallotNumber <= a.promisedNumber.BallotNumber {
+		return Promise{}, false
+	}
+	if p.Instance <= a.compacted {
+		// What this acceptor accepted there is gone, so promising could let the
+		// proposer pick another value. It has to catch up from a snapshot instead.
+		log.Printf("Info: Ignoring PREPARE for compacted instance %d", p.Instance)
+		return Promise{}, false
+	}
+	if !a.persist(Record{Type: PromiseRecord, ProposalNumber: p.ProposalNumber}) {
+		return Promise{}, false
+	}
+
+	accepted := a.accepted[p.Instance]
+	return Promise{
+		RequestID:      p.RequestID,
+		AcceptorID:     a.id,
+		Instance:       p.Instance,
+		ProposalNumber: a.promisedNumber,
+		AcceptedNumber: accepted.number,
+		AcceptedValue:  accepted.value,
+		LastInstance:   a.lastInstance,
+	}, true
+}
+
+// HandleAccept processes ac and returns the acknowledgement to send back, if any.
+func (a *Acceptor) HandleAccept(ac Accept) (Accepted, bool) {
+	if ac.Instance <= a.compacted || a.promisedHigher(ac.ProposalNumber) {
+		return Accepted{}, false
+	}
+
+	record := Record{
+		Type:           AcceptRecord,
+		Instance:       ac.Instance,
+		ProposalNumber: ac.ProposalNumber,
+		Value:          ac.Value,
+	}
+	if !a.persist(record) {
+		return Accepted{}, false
+	}
+	return Accepted{RequestID: ac.RequestID, AcceptorID: a.id, Instance: ac.Instance, ProposalNumber: a.promisedNumber, Value: ac.Value}, true
+}
+
+// HandleAny opens the fast ballot of open unless a higher ballot was promised.
+// Like an accept, it counts as a promise of its ballot.
+func (a *Acceptor) HandleAny(open Any) bool {
+	if a.promisedHigher(open.ProposalNumber) {
+		return false
+	}
+	if open.ProposalNumber != a.promisedNumber &&
+		!a.persist(Record{Type: PromiseRecord, ProposalNumber: open.ProposalNumber}) {
+		return false
+	}
+	a.fast = open
+	return true
+}
+
+// HandleFastAccept accepts the value of fa in the open fast ballot if this acceptor
+// accepted nothing for the instance in that ballot yet, and returns the
+// acknowledgement to send back, if any.
+func (a *Acceptor) HandleFastAccept(fa FastAccept) (Accepted, bool) {
+	ballot := a.fast.ProposalNumber
+	if ballot == (ProposalNumber{}) || ballot != a.promisedNumber ||
+		fa.Instance < a.fast.Instance || fa.Instance <= a.compacted {
+		return Accepted{}, false
+	}
+	if accepted, ok := a.accepted[fa.Instance]; ok && accepted.number.BallotNumber >= ballot.BallotNumber {
+		return Accepted{}, false
+	}
+
+	record := Record{
+		Type:           AcceptRecord,
+		Instance:       fa.Instance,
+		ProposalNumber: ballot,
+		Value:          fa.Value,
+	}
+	if !a.persist(record) {
+		return Accepted{}, false
+	}
+	return Accepted{RequestID: fa.RequestID, AcceptorID: a.id, Instance: fa.Instance, ProposalNumber: ballot, Value: fa.Value}, true
+}
+
+// Nack returns the rejection of the phase message requestID sent for instance with
+// proposal number rejected, carrying the ballot this acceptor promised.
+func (a *Acceptor) Nack(phase string, requestID string, instance int, rejected ProposalNumber) Nack {
+	return Nack{
+		RequestID:      requestID,
+		AcceptorID:     a.id,
+		Phase:          phase,
+		Instance:       instance,
+		ProposalNumber: rejected,
+		PromisedNumber: a.promisedNumber,
+	}
+}
+
+// Promised returns the highest proposal number this acceptor promised.
+func (a *Acceptor) Promised() ProposalNumber {
+	return a.promisedNumber
+}
+
+// promisedHigher reports whether this acceptor promised a ballot number rules out:
+// a higher ballot, or the same ballot of another proposer.
+func (a *Acceptor) promisedHigher(number ProposalNumber) bool {
+	return number.BallotNumber < a.promisedNumber.BallotNumber ||
+		(number.BallotNumber == a.promisedNumber.BallotNumber && number.ProposerID != a.promisedNumber.ProposerID)
+}
+
+// persist makes record durable and applies it. State must never be acknowledged
+// before it is on disk, so callers drop the message when persist fails.
+func (a *Acceptor) persist(record Record) bool {
+	if a.storage != nil {
+		if err := a.storage.Append(record); err != nil {
+			log.Printf("Error: Failed to write %s to WAL: %s", record.Type, err)
+			return false
+		}
+	}
+	a.apply(record)
+	return true
+}
+
+func (a *Acceptor) apply(record Record) {
+	switch record.Type {
+	case PromiseRecord:
+		a.promisedNumber = record.ProposalNumber
+	case AcceptRecord:
+		a.promisedNumber = record.ProposalNumber
+		a.accepted[record.Instance] = acceptedProposal{number: record.ProposalNumber, value: record.Value}
+		a.lastInstance = max(a.lastInstance, record.Instance)
+	case CompactRecord:
+		a.compacted = max(a.compacted, record.Instance)
+		a.lastInstance = max(a.lastInstance, record.Instance)
+		for instance := range a.accepted {
+			if instance <= a.compacted {
+				delete(a.accepted, instance)
+			}
+		}
+	}
+}
+
+// Compact forgets what was accepted for the instances up to and including
+// instance, which must be decided and covered by a snapshot. It returns the
+// records that restore the remaining state, or false if nothing was forgotten.
+func (a *Acceptor) Compact(instance int) ([]Record, bool) {
+	if instance <= a.compacted {
+		return nil, false
+	}
+	a.apply(Record{Type: CompactRecord, Instance: instance})
+
+	records := []Record{{Type: CompactRecord, Instance: a.compacted}}
+	instances := make([]int, 0, len(a.accepted))
+	for i := range a.accepted {
+		instances = append(instances, i)
+	}
+	slices.Sort(instances)
+	for _, i := range instances {
+		accepted := a.accepted[i]
+		records = append(records, Record{Type: AcceptRecord, Instance: i, ProposalNumber: accepted.number, Value: accepted.value})
+	}
+	// Accept records also restore the promise, so the promise is written last.
+	records = append(records, Record{Type: PromiseRecord, ProposalNumber: a.promisedNumber})
+	return records, true
+}
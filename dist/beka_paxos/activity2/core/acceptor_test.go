@@ -0,0 +1,135 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+// memoryStorage keeps the records appended to it, or fails every append once err
+// is set.
+type memoryStorage struct {
+	records []Record
+	err     error
+}
+
+func (m *memoryStorage) Append(record Record) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.records = append(m.records, record)
+	return nil
+}
+
+func TestHandlePrepareOrdersBallots(t *testing.T) {
+	acceptor := NewAcceptor("a0", nil)
+	if _, ok := acceptor.HandlePrepare(Prepare{Instance: 1, ProposalNumber: ballot2}); !ok {
+		t.Fatal("first prepare not promised")
+	}
+
+	for _, number := range []ProposalNumber{ballot1, ballot2, {BallotNumber: 2, ProposerID: "q"}} {
+		if _, ok := acceptor.HandlePrepare(Prepare{Instance: 1, ProposalNumber: number}); ok {
+			t.Fatalf("prepare %+v promised after %+v", number, ballot2)
+		}
+	}
+	promise, ok := acceptor.HandlePrepare(Prepare{Instance: 1, ProposalNumber: ballot3})
+	if !ok || promise.ProposalNumber != ballot3 {
+		t.Fatalf("higher prepare: got %+v, %v", promise, ok)
+	}
+	if acceptor.Promised() != ballot3 {
+		t.Fatalf("promised %+v, want %+v", acceptor.Promised(), ballot3)
+	}
+}
+
+func TestHandlePrepareReportsAccepted(t *testing.T) {
+	acceptor := NewAcceptor("a0", nil)
+	acceptor.HandlePrepare(Prepare{Instance: 1, ProposalNumber: ballot1})
+	acceptor.HandleAccept(Accept{Instance: 1, ProposalNumber: ballot1, Value: "x"})
+	acceptor.HandleAccept(Accept{Instance: 3, ProposalNumber: ballot1, Value: "y"})
+
+	promise, ok := acceptor.HandlePrepare(Prepare{Instance: 1, ProposalNumber: ballot2})
+	if !ok || promise.AcceptedNumber != ballot1 || promise.AcceptedValue != "x" || promise.LastInstance != 3 {
+		t.Fatalf("got %+v, %v", promise, ok)
+	}
+	promise, ok = acceptor.HandlePrepare(Prepare{Instance: 2, ProposalNumber: ballot3})
+	if !ok || promise.AcceptedValue != nil || promise.LastInstance != 3 {
+		t.Fatalf("instance without a value: got %+v, %v", promise, ok)
+	}
+}
+
+func TestHandleAcceptOrdersBallots(t *testing.T) {
+	acceptor := NewAcceptor("a0", nil)
+	acceptor.HandlePrepare(Prepare{Instance: 1, ProposalNumber: ballot2})
+
+	tests := []struct {
+		name   string
+		number ProposalNumber
+		want   bool
+	}{
+		{"lower ballot", ballot1, false},
+		{"same ballot of another proposer", ProposalNumber{BallotNumber: 2, ProposerID: "q"}, false},
+		{"promised ballot", ballot2, true},
+		{"higher ballot", ballot3, true},
+		{"ballot below the one accepted last", ballot2, false},
+	}
+	for _, tt := range tests {
+		accepted, ok := acceptor.HandleAccept(Accept{Instance: 1, ProposalNumber: tt.number, Value: tt.name})
+		if ok != tt.want {
+			t.Fatalf("%s: accepted %v, want %v", tt.name, ok, tt.want)
+		}
+		if ok && (accepted.ProposalNumber != tt.number || accepted.Value != tt.name) {
+			t.Fatalf("%s: got %+v", tt.name, accepted)
+		}
+	}
+	if acceptor.Promised() != ballot3 {
+		t.Fatalf("promised %+v, want the accepted %+v", acceptor.Promised(), ballot3)
+	}
+}
+
+func TestAcceptorRejectsCompactedInstances(t *testing.T) {
+	acceptor := NewAcceptor("a0", nil)
+	acceptor.HandlePrepare(Prepare{Instance: 1, ProposalNumber: ballot1})
+	for instance := 1; instance <= 3; instance++ {
+		acceptor.HandleAccept(Accept{Instance: instance, ProposalNumber: ballot1, Value: "x"})
+	}
+	if _, ok := acceptor.Compact(2); !ok {
+		t.Fatal("nothing compacted")
+	}
+
+	for _, instance := range []int{1, 2} {
+		if _, ok := acceptor.HandlePrepare(Prepare{Instance: instance, ProposalNumber: ballot2}); ok {
+			t.Fatalf("prepare for compacted instance %d promised", instance)
+		}
+		if _, ok := acceptor.HandleAccept(Accept{Instance: instance, ProposalNumber: ballot1, Value: "y"}); ok {
+			t.Fatalf("accept for compacted instance %d accepted", instance)
+		}
+	}
+	promise, ok := acceptor.HandlePrepare(Prepare{Instance: 3, ProposalNumber: ballot2})
+	if !ok || promise.AcceptedValue != "x" {
+		t.Fatalf("instance past the compaction: got %+v, %v", promise, ok)
+	}
+}
+
+func TestAcceptorAnswersOnlyPersistedState(t *testing.T) {
+	storage := &memoryStorage{}
+	acceptor := NewAcceptor("a0", storage)
+	acceptor.HandlePrepare(Prepare{Instance: 1, ProposalNumber: ballot1})
+	acceptor.HandleAccept(Accept{Instance: 1, ProposalNumber: ballot1, Value: "x"})
+
+	storage.err = errors.New("disk full")
+	if _, ok := acceptor.HandlePrepare(Prepare{Instance: 1, ProposalNumber: ballot2}); ok {
+		t.Fatal("promise sent without its record")
+	}
+	if _, ok := acceptor.HandleAccept(Accept{Instance: 1, ProposalNumber: ballot1, Value: "y"}); ok {
+		t.Fatal("accept acknowledged without its record")
+	}
+	if acceptor.Promised() != ballot1 {
+		t.Fatalf("promised %+v, want %+v", acceptor.Promised(), ballot1)
+	}
+
+	restored := NewAcceptor("a0", nil)
+	restored.Restore(storage.records)
+	promise, ok := restored.HandlePrepare(Prepare{Instance: 1, ProposalNumber: ballot2})
+	if !ok || promise.AcceptedValue != "x" {
+		t.Fatalf("restored acceptor: got %+v, %v", promise, ok)
+	}
+}
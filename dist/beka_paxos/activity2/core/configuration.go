@@ -0,0 +1,83 @@
+package core
+
+import (
+	"reflect"
+	"slices"
+)
+
+// Configuration is the set of acceptors whose votes count for an instance. A
+// configuration without explicit Members counts the votes of any Size acceptors,
+// which is how a cluster bootstraps from NUMBER_OF_ACCEPTOR alone. Quorum, when
+// set, replaces majority quorums.
+type Configuration struct {
+	Members []string    `json:"members,omitempty"`
+	Size    int         `json:"size,omitempty"`
+	Quorum  *QuorumSpec `json:"quorum,omitempty"`
+}
+
+// IsQuorum reports whether the acceptors in votes form a quorum of phase under
+// this configuration.
+func (c Configuration) IsQuorum(phase Phase, votes map[string]bool) bool {
+	return c.quorums().Quorum(phase, votes)
+}
+
+// Blocked reports whether the acceptors in rejected leave no quorum of phase.
+func (c Configuration) Blocked(phase Phase, rejected map[string]bool) bool {
+	return c.quorums().Blocked(phase, rejected)
+}
+
+// quorums returns the quorum system of this configuration.
+func (c Configuration) quorums() QuorumSystem {
+	n := c.Acceptors()
+	if c.Quorum == nil {
+		return thresholdQuorums{total: n, phase1: majority(n), phase2: majority(n)}
+	}
+	switch c.Quorum.Kind {
+	case GridQuorum:
+		return gridQuorums{rows: c.Quorum.Grid}
+	case WeightedQuorum:
+		total := n
+		for _, weight := range c.Quorum.Weights {
+			total += weight - 1
+		}
+		return thresholdQuorums{weights: c.Quorum.Weights, total: total,
+			phase1: orMajority(c.Quorum.Phase1, total), phase2: orMajority(c.Quorum.Phase2, total)}
+	}
+	return thresholdQuorums{total: n, phase1: orMajority(c.Quorum.Phase1, n), phase2: orMajority(c.Quorum.Phase2, n)}
+}
+
+// FastQuorum returns the votes a value needs to be chosen in a fast ballot: enough
+// that any two fast quorums and any phase-1 quorum share an acceptor, so a
+// recovery round can tell which value may have been chosen.
+func (c Configuration) FastQuorum() int {
+	n := c.Acceptors()
+	quorums, ok := c.quorums().(thresholdQuorums)
+	if !ok || quorums.weights != nil {
+		return n
+	}
+	return min(n, max(quorums.phase2, (2*n-quorums.phase1)/2+1))
+}
+
+// FastCapable reports whether fast ballots can run under this configuration,
+// which needs every acceptor to have one vote.
+func (c Configuration) FastCapable() bool {
+	return c.Quorum == nil || c.Quorum.Kind == FlexibleQuorum
+}
+
+// Acceptors returns the number of acceptors whose votes count.
+func (c Configuration) Acceptors() int {
+	if len(c.Members) > 0 {
+		return len(c.Members)
+	}
+	return c.Size
+}
+
+// Counts reports whether the vote of acceptorID counts under this configuration.
+func (c Configuration) Counts(acceptorID string) bool {
+	return len(c.Members) == 0 || slices.Contains(c.Members, acceptorID)
+}
+
+// Equal reports whether c and other count the same votes the same way.
+func (c Configuration) Equal(other Configuration) bool {
+	return c.Size == other.Size && slices.Equal(c.Members, other.Members) && reflect.DeepEqual(c.Quorum, other.Quorum)
+}
@@ -0,0 +1,65 @@
+package core
+
+import "testing"
+
+func TestFastQuorum(t *testing.T) {
+	members := []string{"a", "b", "c", "d", "e"}
+	tests := []struct {
+		name   string
+		config Configuration
+		want   int
+	}{
+		{"majority of 3", Configuration{Size: 3}, 3},
+		{"majority of 4", Configuration{Size: 4}, 3},
+		{"majority of 5", Configuration{Size: 5}, 4},
+		{"majority of 7", Configuration{Size: 7}, 6},
+		{"members count, not size", Configuration{Members: members, Size: 3}, 4},
+		// Any two fast quorums of 4 and a phase-1 quorum of 4 out of 5 share an acceptor.
+		{"flexible large phase 1", Configuration{Size: 5, Quorum: &QuorumSpec{Kind: FlexibleQuorum, Phase1: 4, Phase2: 2}}, 4},
+		// With a phase-1 quorum of 2 of 5, only all 5 intersect.
+		{"flexible small phase 1", Configuration{Size: 5, Quorum: &QuorumSpec{Kind: FlexibleQuorum, Phase1: 2, Phase2: 4}}, 5},
+		{"flexible phase 2 larger", Configuration{Size: 5, Quorum: &QuorumSpec{Kind: FlexibleQuorum, Phase1: 5, Phase2: 4}}, 4},
+		{"grid", Configuration{Members: members[:4], Quorum: &QuorumSpec{Kind: GridQuorum, Grid: [][]string{{"a", "b"}, {"c", "d"}}}}, 4},
+		{"weighted", Configuration{Members: members, Quorum: &QuorumSpec{Kind: WeightedQuorum, Weights: map[string]int{"a": 3}}}, 5},
+	}
+	for _, tt := range tests {
+		if got := tt.config.FastQuorum(); got != tt.want {
+			t.Errorf("%s: fast quorum %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQuorums(t *testing.T) {
+	grid := Configuration{Members: []string{"a", "b", "c", "d"},
+		Quorum: &QuorumSpec{Kind: GridQuorum, Grid: [][]string{{"a", "b"}, {"c", "d"}}}}
+	weighted := Configuration{Members: []string{"a", "b", "c"},
+		Quorum: &QuorumSpec{Kind: WeightedQuorum, Weights: map[string]int{"a": 3}}}
+	flexible := Configuration{Size: 5, Quorum: &QuorumSpec{Kind: FlexibleQuorum, Phase1: 4, Phase2: 2}}
+
+	tests := []struct {
+		name   string
+		config Configuration
+		phase  Phase
+		votes  []string
+		want   bool
+	}{
+		{"grid phase 1 needs every row", grid, Phase1, []string{"a", "b"}, false},
+		{"grid phase 1", grid, Phase1, []string{"a", "d"}, true},
+		{"grid phase 2 needs a whole row", grid, Phase2, []string{"a", "d"}, false},
+		{"grid phase 2", grid, Phase2, []string{"c", "d"}, true},
+		// The weighted configuration has 5 votes, so each phase needs 3.
+		{"weighted heavy member", weighted, Phase2, []string{"a"}, true},
+		{"weighted light members", weighted, Phase1, []string{"b", "c"}, false},
+		{"flexible phase 1", flexible, Phase1, []string{"a", "b", "c"}, false},
+		{"flexible phase 2", flexible, Phase2, []string{"a", "b"}, true},
+	}
+	for _, tt := range tests {
+		votes := make(map[string]bool)
+		for _, id := range tt.votes {
+			votes[id] = true
+		}
+		if got := tt.config.IsQuorum(tt.phase, votes); got != tt.want {
+			t.Errorf("%s: quorum %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
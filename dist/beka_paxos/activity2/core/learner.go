@@ -0,0 +1,106 @@
+package core
+
+// Votes counts the Accepted messages of each instance until a value is chosen
+// there: once a quorum of the instance's configuration accepted the same
+// proposal. In a fast ballot acceptors may accept different values under one
+// proposal number, so their votes are counted per value and a value needs a fast
+// quorum. Votes is not safe for concurrent use.
+type Votes struct {
+	classic map[int]map[ProposalNumber]*ballotVotes
+	fast    map[int]map[ProposalNumber]map[string]*ballotVotes
+}
+
+type ballotVotes struct {
+	value     interface{}
+	acceptors map[string]bool
+}
+
+// NewVotes returns an empty tally.
+func NewVotes() *Votes {
+	return &Votes{
+		classic: make(map[int]map[ProposalNumber]*ballotVotes),
+		fast:    make(map[int]map[ProposalNumber]map[string]*ballotVotes),
+	}
+}
+
+// Add counts accepted under config, the configuration of its instance, and
+// returns the chosen value once its proposal reached a quorum.
+func (v *Votes) Add(accepted Accepted, config Configuration) (interface{}, bool) {
+	if !config.Counts(accepted.AcceptorID) {
+		return nil, false
+	}
+	ballots, ok := v.classic[accepted.Instance]
+	if !ok {
+		ballots = make(map[ProposalNumber]*ballotVotes)
+		v.classic[accepted.Instance] = ballots
+	}
+	votes, ok := ballots[accepted.ProposalNumber]
+	if !ok {
+		votes = &ballotVotes{value: accepted.Value, acceptors: make(map[string]bool)}
+		ballots[accepted.ProposalNumber] = votes
+	}
+	votes.acceptors[accepted.AcceptorID] = true
+	if !config.IsQuorum(Phase2, votes.acceptors) {
+		return nil, false
+	}
+	delete(v.classic, accepted.Instance)
+	return votes.value, true
+}
+
+// AddFast counts accepted, a vote in a fast ballot, and returns the chosen value
+// once a fast quorum accepted it. collided reports that no value of the ballot can
+// get there anymore, so the instance needs a classic round.
+func (v *Votes) AddFast(accepted Accepted, config Configuration) (value interface{}, chosen bool, collided bool) {
+	if !config.Counts(accepted.AcceptorID) {
+		return nil, false, false
+	}
+	ballots, ok := v.fast[accepted.Instance]
+	if !ok {
+		ballots = make(map[ProposalNumber]map[string]*ballotVotes)
+		v.fast[accepted.Instance] = ballots
+	}
+	values, ok := ballots[accepted.ProposalNumber]
+	if !ok {
+		values = make(map[string]*ballotVotes)
+		ballots[accepted.ProposalNumber] = values
+	}
+	key := ValueKey(accepted.Value)
+	votes, ok := values[key]
+	if !ok {
+		votes = &ballotVotes{value: accepted.Value, acceptors: make(map[string]bool)}
+		values[key] = votes
+	}
+	votes.acceptors[accepted.AcceptorID] = true
+
+	quorum := config.FastQuorum()
+	if len(votes.acceptors) >= quorum {
+		delete(v.fast, accepted.Instance)
+		return votes.value, true, false
+	}
+	voted, best := 0, 0
+	for _, other := range values {
+		voted += len(other.acceptors)
+		best = max(best, len(other.acceptors))
+	}
+	return nil, false, best+config.Acceptors()-voted < quorum
+}
+
+// Forget drops the votes counted for instance.
+func (v *Votes) Forget(instance int) {
+	delete(v.classic, instance)
+	delete(v.fast, instance)
+}
+
+// ForgetBefore drops the votes counted for the instances before next.
+func (v *Votes) ForgetBefore(next int) {
+	for instance := range v.classic {
+		if instance < next {
+			delete(v.classic, instance)
+		}
+	}
+	for instance := range v.fast {
+		if instance < next {
+			delete(v.fast, instance)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package core
+
+import "testing"
+
+func TestVotesAdd(t *testing.T) {
+	config := Configuration{Members: []string{"a0", "a1", "a2"}}
+	votes := NewVotes()
+	accepted := func(acceptor string, number ProposalNumber, value interface{}) Accepted {
+		return Accepted{AcceptorID: acceptor, Instance: 1, ProposalNumber: number, Value: value}
+	}
+
+	for _, a := range []Accepted{
+		accepted("a0", ballot1, "x"),
+		// Votes for another ballot, a repeated vote or one of a non-member do not
+		// complete the quorum of ballot1.
+		accepted("a1", ballot2, "y"),
+		accepted("a0", ballot1, "x"),
+		accepted("a3", ballot1, "x"),
+	} {
+		if value, chosen := votes.Add(a, config); chosen {
+			t.Fatalf("%+v chose %v", a, value)
+		}
+	}
+	if value, chosen := votes.Add(accepted("a2", ballot1, "x"), config); !chosen || value != "x" {
+		t.Fatalf("quorum of ballot1: got %v, %v", value, chosen)
+	}
+	// The instance is decided, so a late vote starts counting from scratch.
+	if _, chosen := votes.Add(accepted("a0", ballot2, "y"), config); chosen {
+		t.Fatal("late vote chose a value")
+	}
+}
+
+func TestVotesAddFast(t *testing.T) {
+	five := Configuration{Size: 5}
+	type step struct {
+		acceptor string
+		value    interface{}
+		chosen   bool
+		collided bool
+	}
+	// Five acceptors need a fast quorum of four.
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"uncontended", []step{
+			{"a0", "x", false, false},
+			{"a1", "x", false, false},
+			{"a2", "x", false, false},
+			{"a3", "x", true, false},
+		}},
+		{"collision", []step{
+			{"a0", "x", false, false},
+			{"a1", "y", false, false},
+			// x can still get four votes with a3 and a4, but not once a3 voted y.
+			{"a2", "x", false, false},
+			{"a3", "y", false, true},
+		}},
+		{"repeated vote", []step{
+			{"a0", "x", false, false},
+			{"a0", "x", false, false},
+			{"a1", "x", false, false},
+			{"a2", "x", false, false},
+			{"a4", "x", true, false},
+		}},
+		{"values equal once decoded", []step{
+			{"a0", map[string]interface{}{"n": 1.0}, false, false},
+			{"a1", map[string]interface{}{"n": 1}, false, false},
+			{"a2", struct {
+				N int `json:"n"`
+			}{1}, false, false},
+			{"a3", map[string]interface{}{"n": 1.0}, true, false},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			votes := NewVotes()
+			for i, s := range tt.steps {
+				accepted := Accepted{AcceptorID: s.acceptor, Instance: 1, ProposalNumber: ballot1, Value: s.value}
+				_, chosen, collided := votes.AddFast(accepted, five)
+				if chosen != s.chosen || collided != s.collided {
+					t.Fatalf("vote %d (%s for %v): chosen %v, collided %v; want %v, %v",
+						i, s.acceptor, s.value, chosen, collided, s.chosen, s.collided)
+				}
+			}
+		})
+	}
+}
+
+func TestVotesForget(t *testing.T) {
+	config := Configuration{Size: 3}
+	votes := NewVotes()
+	for instance := 1; instance <= 3; instance++ {
+		votes.Add(Accepted{AcceptorID: "a0", Instance: instance, ProposalNumber: ballot1, Value: "x"}, config)
+		votes.AddFast(Accepted{AcceptorID: "a0", Instance: instance, ProposalNumber: ballot2, Value: "x"}, config)
+	}
+	votes.ForgetBefore(3)
+	votes.Forget(3)
+	if len(votes.classic) != 0 || len(votes.fast) != 0 {
+		t.Fatalf("votes left: %v, %v", votes.classic, votes.fast)
+	}
+}
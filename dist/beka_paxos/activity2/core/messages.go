@@ -0,0 +1,126 @@
+// Package core holds the transport-free Paxos state machines: the acceptor, the
+// proposer's rounds and the learner's vote counting. They take messages and return
+// the messages to send, so an in-process driver and a networked server can share
+// them.
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Types of the phase messages a Nack can reject.
+const (
+	PrepareMessage = "PREPARE"
+	AcceptMessage  = "ACCEPT"
+)
+
+// Prepare starts phase 1 of a round. Every phase message carries the RequestID of the round that sent it, and the
+// acceptor echoes it in its response, so the response reaches that round even when
+// a server has several proposals in flight.
+type Prepare struct {
+	RequestID      string         `json:"request_id"`
+	Instance       int            `json:"instance"`
+	ProposalNumber ProposalNumber `json:"proposal_number"`
+}
+
+// Promise carries the proposal the acceptor already accepted for Instance, if any,
+// and the highest instance it accepted anything for, so the proposer knows from
+// which instance on its ballot is free to skip phase 1.
+type Promise struct {
+	RequestID      string         `json:"request_id"`
+	AcceptorID     string         `json:"acceptor_ID"`
+	Instance       int            `json:"instance"`
+	ProposalNumber ProposalNumber `json:"proposal_number"`
+	AcceptedNumber ProposalNumber `json:"accepted_number"`
+	AcceptedValue  interface{}    `json:"accepted_value"`
+	LastInstance   int            `json:"last_instance"`
+}
+
+// Accept asks the acceptors to accept Value for Instance in phase 2.
+type Accept struct {
+	RequestID      string         `json:"request_id"`
+	Instance       int            `json:"instance"`
+	ProposalNumber ProposalNumber `json:"proposal_number"`
+	Value          interface{}    `json:"value"`
+}
+
+// Accepted tells proposers and learners that AcceptorID accepted Value.
+type Accepted struct {
+	RequestID      string         `json:"request_id"`
+	AcceptorID     string         `json:"acceptor_ID"`
+	Instance       int            `json:"instance"`
+	ProposalNumber ProposalNumber `json:"proposal_number"`
+	Value          interface{}    `json:"value"`
+}
+
+// Nack tells the proposer of ProposalNumber that the acceptor rejected its Phase
+// message for Instance because it promised PromisedNumber, so the proposer can
+// give up the round without waiting for a timeout and retry past that ballot.
+type Nack struct {
+	RequestID      string         `json:"request_id"`
+	AcceptorID     string         `json:"acceptor_ID"`
+	Phase          string         `json:"phase"`
+	Instance       int            `json:"instance"`
+	ProposalNumber ProposalNumber `json:"proposal_number"`
+	PromisedNumber ProposalNumber `json:"promised_number"`
+}
+
+// ProposalNumber is a ballot; ProposerID keeps the ballots of different proposers apart.
+type ProposalNumber struct {
+	BallotNumber int    `json:"ballot_number"`
+	ProposerID   string `json:"proposer_ID"`
+}
+
+// GreaterThan orders proposal numbers by ballot, breaking ties by proposer ID.
+func (n ProposalNumber) GreaterThan(other ProposalNumber) bool {
+	if n.BallotNumber != other.BallotNumber {
+		return n.BallotNumber > other.BallotNumber
+	}
+	return n.ProposerID > other.ProposerID
+}
+
+// Any opens the fast ballot ProposalNumber from Instance on. The coordinator sends
+// it once phase 1 of the ballot succeeded; acceptors that did not promise a higher
+// ballot then accept the first value a client sends them for each instance.
+type Any struct {
+	RequestID      string         `json:"request_id"`
+	Instance       int            `json:"instance"`
+	ProposalNumber ProposalNumber `json:"proposal_number"`
+}
+
+// FastAccept carries a value a client sends straight to the acceptors for
+// Instance. Acceptors answer it with an Accepted in the fast ballot.
+type FastAccept struct {
+	RequestID string      `json:"request_id"`
+	ClientID  string      `json:"client_ID"`
+	Instance  int         `json:"instance"`
+	Value     interface{} `json:"value"`
+}
+
+// SameValue reports whether a and b are the same value, however they were decoded.
+func SameValue(a, b interface{}) bool {
+	if a, ok := a.(string); ok {
+		if b, ok := b.(string); ok {
+			return a == b
+		}
+	}
+	return ValueKey(a) == ValueKey(b)
+}
+
+// ValueKey identifies a value by its JSON encoding once decoded generically, which
+// is the same for a value and its copies that went through either wire codec: maps
+// encode their keys sorted, where structs keep the order of their fields.
+func ValueKey(value interface{}) string {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%#v", value)
+	}
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err == nil {
+		if normalized, err := json.Marshal(generic); err == nil {
+			return string(normalized)
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,221 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Kinds of quorum systems a QuorumSpec describes.
+const (
+	MajorityQuorum = "majority"
+	FlexibleQuorum = "flexible"
+	GridQuorum     = "grid"
+	WeightedQuorum = "weighted"
+)
+
+// Phase is the Paxos phase a quorum is gathered for.
+type Phase int
+
+const (
+	Phase1 Phase = 1
+	Phase2 Phase = 2
+)
+
+// phaseOf returns the phase of the message type a Nack rejected.
+func phaseOf(messageType string) Phase {
+	if messageType == PrepareMessage {
+		return Phase1
+	}
+	return Phase2
+}
+
+// QuorumSystem decides which sets of acceptors are quorums of each phase. Paxos
+// stays safe as long as every phase-1 quorum intersects every phase-2 quorum.
+type QuorumSystem interface {
+	// Quorum reports whether the acceptors in votes form a quorum of phase.
+	Quorum(phase Phase, votes map[string]bool) bool
+	// Blocked reports whether the acceptors in rejected leave no quorum of phase.
+	Blocked(phase Phase, rejected map[string]bool) bool
+}
+
+// QuorumSpec describes the quorum system of a configuration. Phases may need
+// different quorums (Flexible Paxos): a write-heavy cluster can make phase 2, run
+// for every value, smaller at the cost of a larger phase 1, run on leader changes.
+type QuorumSpec struct {
+	// Kind is one of the *Quorum constants. Empty means majority.
+	Kind string `json:"kind,omitempty"`
+	// Phase1 and Phase2 are the votes each phase needs under flexible and weighted
+	// quorums. Zero means a majority of all votes.
+	Phase1 int `json:"phase1,omitempty"`
+	Phase2 int `json:"phase2,omitempty"`
+	// Grid arranges the members in rows. Phase 1 needs an acceptor of every row and
+	// phase 2 every acceptor of one row.
+	Grid [][]string `json:"grid,omitempty"`
+	// Weights are the votes of the members under weighted quorums. Unlisted members
+	// have one vote.
+	Weights map[string]int `json:"weights,omitempty"`
+}
+
+// IsZero reports whether q describes plain majority quorums.
+func (q QuorumSpec) IsZero() bool {
+	return (q.Kind == "" || q.Kind == MajorityQuorum) && q.Phase1 == 0 && q.Phase2 == 0 &&
+		len(q.Grid) == 0 && len(q.Weights) == 0
+}
+
+// Validate checks that q describes intersecting quorums of the acceptors of
+// config.
+func (q QuorumSpec) Validate(config Configuration) error {
+	n := config.Acceptors()
+	switch q.Kind {
+	case "", MajorityQuorum:
+		if !q.IsZero() {
+			return fmt.Errorf("majority quorums take no sizes, grid or weights")
+		}
+		return nil
+
+	case FlexibleQuorum:
+		if len(q.Grid) > 0 || len(q.Weights) > 0 {
+			return fmt.Errorf("flexible quorums take no grid or weights")
+		}
+		return checkThresholds(q.Phase1, q.Phase2, n)
+
+	case WeightedQuorum:
+		if len(config.Members) == 0 {
+			return fmt.Errorf("weighted quorums need explicit members")
+		}
+		if len(q.Grid) > 0 {
+			return fmt.Errorf("weighted quorums take no grid")
+		}
+		total := n
+		for id, weight := range q.Weights {
+			if !slices.Contains(config.Members, id) {
+				return fmt.Errorf("weight given for %s, which is not a member", id)
+			}
+			if weight <= 0 {
+				return fmt.Errorf("weight %d of %s is not positive", weight, id)
+			}
+			total += weight - 1
+		}
+		return checkThresholds(q.Phase1, q.Phase2, total)
+
+	case GridQuorum:
+		if len(config.Members) == 0 {
+			return fmt.Errorf("grid quorums need explicit members")
+		}
+		if q.Phase1 != 0 || q.Phase2 != 0 || len(q.Weights) > 0 {
+			return fmt.Errorf("grid quorums take no sizes or weights")
+		}
+		seen := make(map[string]bool)
+		for i, row := range q.Grid {
+			if len(row) == 0 {
+				return fmt.Errorf("row %d of the grid is empty", i+1)
+			}
+			for _, id := range row {
+				if seen[id] {
+					return fmt.Errorf("%s appears twice in the grid", id)
+				}
+				if !slices.Contains(config.Members, id) {
+					return fmt.Errorf("%s is in the grid but not a member", id)
+				}
+				seen[id] = true
+			}
+		}
+		if len(seen) != len(config.Members) {
+			return fmt.Errorf("the grid places %d of %d members", len(seen), len(config.Members))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown quorum kind %q", q.Kind)
+}
+
+// checkThresholds checks that phases needing phase1 and phase2 of total votes can
+// both be reached and intersect. Zero means a majority.
+func checkThresholds(phase1, phase2, total int) error {
+	if phase1 < 0 || phase2 < 0 {
+		return fmt.Errorf("quorum sizes %d and %d must not be negative", phase1, phase2)
+	}
+	phase1, phase2 = orMajority(phase1, total), orMajority(phase2, total)
+	if phase1 > total || phase2 > total {
+		return fmt.Errorf("quorum sizes %d and %d exceed the %d votes", phase1, phase2, total)
+	}
+	if phase1+phase2 <= total {
+		return fmt.Errorf("phase 1 quorums of %d and phase 2 quorums of %d out of %d votes need not intersect",
+			phase1, phase2, total)
+	}
+	return nil
+}
+
+func orMajority(size, total int) int {
+	if size == 0 {
+		return majority(total)
+	}
+	return size
+}
+
+// thresholdQuorums gives every acceptor weights[id] votes, or one, and needs
+// phase1 or phase2 of the total votes in each phase. Majority and flexible quorums
+// are threshold quorums where all acceptors have one vote.
+type thresholdQuorums struct {
+	weights map[string]int
+	total   int
+	phase1  int
+	phase2  int
+}
+
+func (t thresholdQuorums) votes(acceptors map[string]bool) int {
+	votes := 0
+	for id, ok := range acceptors {
+		if !ok {
+			continue
+		}
+		if weight, weighted := t.weights[id]; weighted {
+			votes += weight
+		} else {
+			votes++
+		}
+	}
+	return votes
+}
+
+func (t thresholdQuorums) need(phase Phase) int {
+	if phase == Phase1 {
+		return t.phase1
+	}
+	return t.phase2
+}
+
+func (t thresholdQuorums) Quorum(phase Phase, votes map[string]bool) bool {
+	return t.votes(votes) >= t.need(phase)
+}
+
+func (t thresholdQuorums) Blocked(phase Phase, rejected map[string]bool) bool {
+	return t.total-t.votes(rejected) < t.need(phase)
+}
+
+// gridQuorums needs an acceptor of every row in phase 1 and every acceptor of one
+// row in phase 2, so any phase-2 row meets every phase-1 quorum.
+type gridQuorums struct {
+	rows [][]string
+}
+
+func (g gridQuorums) Quorum(phase Phase, votes map[string]bool) bool {
+	if phase == Phase1 {
+		return !slices.ContainsFunc(g.rows, func(row []string) bool { return !containsAny(votes, row) })
+	}
+	return slices.ContainsFunc(g.rows, func(row []string) bool { return containsAll(votes, row) })
+}
+
+func (g gridQuorums) Blocked(phase Phase, rejected map[string]bool) bool {
+	if phase == Phase1 {
+		return slices.ContainsFunc(g.rows, func(row []string) bool { return containsAll(rejected, row) })
+	}
+	return !slices.ContainsFunc(g.rows, func(row []string) bool { return !containsAny(rejected, row) })
+}
+
+func containsAny(set map[string]bool, ids []string) bool {
+	return slices.ContainsFunc(ids, func(id string) bool { return set[id] })
+}
+
+func containsAll(set map[string]bool, ids []string) bool {
+	return !slices.ContainsFunc(ids, func(id string) bool { return !set[id] })
+}
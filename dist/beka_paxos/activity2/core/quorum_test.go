@@ -0,0 +1,48 @@
+package core
+
+import "testing"
+
+func TestQuorumSpecValidate(t *testing.T) {
+	members := Configuration{Members: []string{"a", "b", "c", "d"}}
+	five := Configuration{Size: 5}
+	tests := []struct {
+		name   string
+		spec   QuorumSpec
+		config Configuration
+		valid  bool
+	}{
+		{"majority", QuorumSpec{}, five, true},
+		{"majority with sizes", QuorumSpec{Kind: MajorityQuorum, Phase1: 3}, five, false},
+
+		{"flexible", QuorumSpec{Kind: FlexibleQuorum, Phase1: 4, Phase2: 2}, five, true},
+		{"flexible majority phase 2", QuorumSpec{Kind: FlexibleQuorum, Phase1: 3}, five, true},
+		{"flexible quorums that need not intersect", QuorumSpec{Kind: FlexibleQuorum, Phase1: 3, Phase2: 2}, five, false},
+		{"flexible quorum larger than the cluster", QuorumSpec{Kind: FlexibleQuorum, Phase1: 6, Phase2: 1}, five, false},
+		{"flexible negative size", QuorumSpec{Kind: FlexibleQuorum, Phase1: -1, Phase2: 5}, five, false},
+		{"flexible with a grid", QuorumSpec{Kind: FlexibleQuorum, Grid: [][]string{{"a"}}}, five, false},
+
+		{"grid", QuorumSpec{Kind: GridQuorum, Grid: [][]string{{"a", "b"}, {"c", "d"}}}, members, true},
+		{"grid without members", QuorumSpec{Kind: GridQuorum, Grid: [][]string{{"a"}}}, five, false},
+		{"grid with sizes", QuorumSpec{Kind: GridQuorum, Phase1: 2, Grid: [][]string{{"a", "b"}, {"c", "d"}}}, members, false},
+		{"grid with an empty row", QuorumSpec{Kind: GridQuorum, Grid: [][]string{{"a", "b", "c", "d"}, {}}}, members, false},
+		{"grid placing a member twice", QuorumSpec{Kind: GridQuorum, Grid: [][]string{{"a", "b"}, {"b", "c", "d"}}}, members, false},
+		{"grid with a non-member", QuorumSpec{Kind: GridQuorum, Grid: [][]string{{"a", "b"}, {"c", "e"}}}, members, false},
+		{"grid missing a member", QuorumSpec{Kind: GridQuorum, Grid: [][]string{{"a", "b"}, {"c"}}}, members, false},
+
+		// Weights of 3, 1, 1 and 1 make 6 votes.
+		{"weighted", QuorumSpec{Kind: WeightedQuorum, Weights: map[string]int{"a": 3}, Phase1: 4, Phase2: 3}, members, true},
+		{"weighted quorums that need not intersect", QuorumSpec{Kind: WeightedQuorum, Weights: map[string]int{"a": 3}, Phase1: 3, Phase2: 3}, members, false},
+		{"weighted without members", QuorumSpec{Kind: WeightedQuorum, Weights: map[string]int{"a": 3}}, five, false},
+		{"weighted non-member", QuorumSpec{Kind: WeightedQuorum, Weights: map[string]int{"e": 3}}, members, false},
+		{"weighted zero weight", QuorumSpec{Kind: WeightedQuorum, Weights: map[string]int{"a": 0}}, members, false},
+		{"weighted with a grid", QuorumSpec{Kind: WeightedQuorum, Grid: [][]string{{"a"}}}, members, false},
+
+		{"unknown kind", QuorumSpec{Kind: "ring"}, five, false},
+	}
+	for _, tt := range tests {
+		err := tt.spec.Validate(tt.config)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: got %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package core
+
+import "slices"
+
+// Round tracks the responses to one proposal number for one log instance. Responses
+// are counted once per acceptor of the configuration, so duplicated messages and
+// acceptors outside the configuration cannot fake a quorum.
+type Round struct {
+	RequestID    string
+	Instance     int
+	Number       ProposalNumber
+	config       Configuration
+	promised     map[string]bool
+	accepted     map[string]bool
+	nacked       map[string]bool
+	nackedPhase  Phase
+	promisedHint ProposalNumber
+	highest      Promise
+	// candidates are the values accepted under the highest proposal number the
+	// promising acceptors reported. Only fast ballots leave more than one.
+	candidates   []candidate
+	lastAccepted int
+}
+
+type candidate struct {
+	value interface{}
+	votes int
+}
+
+// NewRound creates a round that needs a quorum of config in the phase it runs.
+func NewRound(instance int, number ProposalNumber, config Configuration) *Round {
+	return &Round{
+		Instance: instance,
+		Number:   number,
+		config:   config,
+		promised: make(map[string]bool),
+		accepted: make(map[string]bool),
+		nacked:   make(map[string]bool),
+	}
+}
+
+func (r *Round) Prepare() Prepare {
+	return Prepare{RequestID: r.RequestID, Instance: r.Instance, ProposalNumber: r.Number}
+}
+
+func (r *Round) Accept(value interface{}) Accept {
+	return Accept{RequestID: r.RequestID, Instance: r.Instance, ProposalNumber: r.Number, Value: value}
+}
+
+// AddPromise records promise if it answers this round and reports whether it did.
+func (r *Round) AddPromise(promise Promise) bool {
+	if promise.Instance != r.Instance || promise.ProposalNumber != r.Number || !r.config.Counts(promise.AcceptorID) {
+		return false
+	}
+	if r.promised[promise.AcceptorID] {
+		return true
+	}
+
+	r.promised[promise.AcceptorID] = true
+	r.lastAccepted = max(r.lastAccepted, promise.LastInstance)
+	if promise.AcceptedValue == nil {
+		return true
+	}
+	switch {
+	case promise.AcceptedNumber.GreaterThan(r.highest.AcceptedNumber):
+		r.highest = promise
+		r.candidates = []candidate{{value: promise.AcceptedValue, votes: 1}}
+	case promise.AcceptedNumber == r.highest.AcceptedNumber:
+		key := ValueKey(promise.AcceptedValue)
+		i := slices.IndexFunc(r.candidates, func(c candidate) bool { return ValueKey(c.value) == key })
+		if i < 0 {
+			r.candidates = append(r.candidates, candidate{value: promise.AcceptedValue})
+			i = len(r.candidates) - 1
+		}
+		r.candidates[i].votes++
+	}
+	return true
+}
+
+// Promised reports whether a quorum promised this round.
+func (r *Round) Promised() bool {
+	return r.config.IsQuorum(Phase1, r.promised)
+}
+
+// AdoptedValue returns the value of the highest-numbered proposal accepted by the
+// promising acceptors, or nil if none of them accepted one for the instance. When
+// they accepted several values in a fast ballot, at most one of them can have been
+// chosen there: the one accepted by every member of some fast quorum that promised,
+// so by at least a fast quorum less the acceptors that did not promise.
+func (r *Round) AdoptedValue() interface{} {
+	if len(r.candidates) <= 1 {
+		return r.highest.AcceptedValue
+	}
+	need := r.config.FastQuorum() - (r.config.Acceptors() - len(r.promised))
+	best := r.candidates[0]
+	for _, c := range r.candidates {
+		if c.votes >= need {
+			return c.value
+		}
+		if c.votes > best.votes {
+			best = c
+		}
+	}
+	return best.value
+}
+
+// LastAccepted returns the highest instance any promising acceptor accepted a value for.
+func (r *Round) LastAccepted() int {
+	return r.lastAccepted
+}
+
+// Covers reports whether the promises of this round let instance go straight to
+// phase 2 under config: the quorum was gathered in the same configuration, and
+// instance is either the round's own, for which the promises reported what was
+// accepted, so AdoptedValue has to be proposed there, or a later instance none of
+// the promising acceptors accepted anything at or beyond.
+func (r *Round) Covers(instance int, config Configuration) bool {
+	if !r.Promised() || !r.config.Equal(config) {
+		return false
+	}
+	return instance == r.Instance || instance > r.Instance && instance > r.lastAccepted
+}
+
+// AddAccepted records accepted if it answers this round and reports whether it did.
+func (r *Round) AddAccepted(accepted Accepted) bool {
+	if accepted.Instance != r.Instance || accepted.ProposalNumber != r.Number || !r.config.Counts(accepted.AcceptorID) {
+		return false
+	}
+	r.accepted[accepted.AcceptorID] = true
+	return true
+}
+
+// Chosen reports whether a quorum accepted this round.
+func (r *Round) Chosen() bool {
+	return r.config.IsQuorum(Phase2, r.accepted)
+}
+
+// AddNack records nack if it answers this round and reports whether it did.
+func (r *Round) AddNack(nack Nack) bool {
+	if nack.Instance != r.Instance || nack.ProposalNumber != r.Number || !r.config.Counts(nack.AcceptorID) {
+		return false
+	}
+	r.nacked[nack.AcceptorID] = true
+	r.nackedPhase = phaseOf(nack.Phase)
+	if nack.PromisedNumber.GreaterThan(r.promisedHint) {
+		r.promisedHint = nack.PromisedNumber
+	}
+	return true
+}
+
+// Rejected reports whether enough acceptors rejected this round that it can no
+// longer reach a quorum of the phase they rejected.
+func (r *Round) Rejected() bool {
+	return len(r.nacked) > 0 && r.config.Blocked(r.nackedPhase, r.nacked)
+}
+
+// PromisedHint returns the highest proposal number the rejecting acceptors promised.
+func (r *Round) PromisedHint() ProposalNumber {
+	return r.promisedHint
+}
+
+// majority returns the smallest number of acceptors that forms a majority.
+func majority(numberOfAccepters int) int {
+	return numberOfAccepters/2 + 1
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+)
+
+var (
+	ballot1 = ProposalNumber{BallotNumber: 1, ProposerID: "p"}
+	ballot2 = ProposalNumber{BallotNumber: 2, ProposerID: "p"}
+	ballot3 = ProposalNumber{BallotNumber: 3, ProposerID: "p"}
+	ballot4 = ProposalNumber{BallotNumber: 4, ProposerID: "p"}
+)
+
+// vote is what one acceptor reports in its promise: nothing accepted when value
+// is nil.
+type vote struct {
+	number ProposalNumber
+	value  interface{}
+}
+
+// promisedRound returns a round of ballot4 for instance 1 that collected a promise
+// for every vote, from acceptors a0, a1 and so on.
+func promisedRound(t *testing.T, config Configuration, votes []vote) *Round {
+	t.Helper()
+	round := NewRound(1, ballot4, config)
+	for i, v := range votes {
+		promise := Promise{AcceptorID: fmt.Sprintf("a%d", i), Instance: 1, ProposalNumber: ballot4}
+		if v.value != nil {
+			promise.AcceptedNumber, promise.AcceptedValue, promise.LastInstance = v.number, v.value, 1
+		}
+		if !round.AddPromise(promise) {
+			t.Fatalf("promise %+v not counted", promise)
+		}
+	}
+	return round
+}
+
+func TestAdoptedValue(t *testing.T) {
+	five := Configuration{Size: 5}
+	// Phase 1 needs 4 of 5, so a fast quorum is 4 as well.
+	flexible := Configuration{Size: 5, Quorum: &QuorumSpec{Kind: FlexibleQuorum, Phase1: 4, Phase2: 2}}
+	none := vote{}
+	x, y := vote{ballot2, "x"}, vote{ballot2, "y"}
+
+	tests := []struct {
+		name   string
+		config Configuration
+		votes  []vote
+		want   interface{}
+	}{
+		{"nothing accepted", five, []vote{none, none, none}, nil},
+		{"classic value", five, []vote{{ballot1, "old"}, none, none}, "old"},
+		{"highest ballot wins", five, []vote{{ballot1, "old"}, {ballot2, "new"}, none}, "new"},
+		{"higher classic ballot beats fast votes", five, []vote{x, x, {ballot3, "z"}}, "z"},
+
+		// With 3 of 5 promising, 2 acceptors are unknown, so a value accepted by 2
+		// may have been chosen by a fast quorum of 4 = 2 + 2.
+		{"collision at threshold", five, []vote{y, x, x}, "x"},
+		{"collision below threshold", five, []vote{x, y, none}, "x"},
+		// With 4 promising, 1 is unknown and a chosen value needs 3 votes here.
+		{"collision at threshold of 4 promises", five, []vote{y, x, x, x}, "x"},
+		{"collision below threshold of 4 promises", five, []vote{y, x, x, none}, "x"},
+		{"tie below threshold of 4 promises", five, []vote{y, y, x, x}, "y"},
+		// With all 5 promising, nothing is unknown and a chosen value shows 4 votes.
+		{"collision at threshold of 5 promises", five, []vote{y, x, x, x, x}, "x"},
+		{"collision below threshold of 5 promises", five, []vote{y, y, x, x, x}, "x"},
+
+		// Under the flexible quorums 4 acceptors promise, so a chosen value shows 3 votes.
+		{"flexible collision at threshold", flexible, []vote{y, x, x, x}, "x"},
+		{"flexible collision below threshold", flexible, []vote{y, y, x, none}, "y"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			round := promisedRound(t, tt.config, tt.votes)
+			if !round.Promised() {
+				t.Fatal("round not promised")
+			}
+			if got := round.AdoptedValue(); got != tt.want {
+				t.Fatalf("adopted %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCovers(t *testing.T) {
+	three := Configuration{Size: 3}
+	round := NewRound(3, ballot2, three)
+	if round.Covers(3, three) {
+		t.Fatal("round covers an instance before its promises arrived")
+	}
+	round.AddPromise(Promise{AcceptorID: "a0", Instance: 3, ProposalNumber: ballot2, LastInstance: 5})
+	round.AddPromise(Promise{AcceptorID: "a1", Instance: 3, ProposalNumber: ballot2, LastInstance: 2})
+
+	tests := []struct {
+		name     string
+		instance int
+		config   Configuration
+		want     bool
+	}{
+		{"earlier instance", 2, three, false},
+		{"own instance", 3, three, true},
+		{"instance a promising acceptor accepted after", 4, three, false},
+		{"last accepted instance", 5, three, false},
+		{"instance past everything accepted", 6, three, true},
+		{"other configuration", 6, Configuration{Size: 5}, false},
+	}
+	for _, tt := range tests {
+		if got := round.Covers(tt.instance, tt.config); got != tt.want {
+			t.Errorf("%s: covers %d = %v, want %v", tt.name, tt.instance, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+// Package cputime reads the CPU time of the running process.
+package cputime
+
+import (
+	"errors"
+	"time"
+)
+
+func Process() (time.Duration, error) {
+	return 0, errors.New("CPU time is not available on this platform")
+}
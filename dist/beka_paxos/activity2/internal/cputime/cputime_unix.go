@@ -0,0 +1,18 @@
+//go:build unix
+
+// Package cputime reads the CPU time of the running process.
+package cputime
+
+import (
+	"syscall"
+	"time"
+)
+
+// Process returns the user and system CPU time the process used so far.
+func Process() (time.Duration, error) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, err
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano()), nil
+}
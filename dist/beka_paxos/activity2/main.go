@@ -1,72 +1,69 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
 
 	"github.com/beka-birhanu/paxos-lab-activity2/paxos"
 	"github.com/streadway/amqp"
 )
 
 func main() {
-	// Load serverID from the environment and convert to integer
-	serverID := os.Getenv("SERVER_ID")
-	if serverID == "" {
-		fmt.Println("SERVER_ID environment variable is not set")
+	// Load the settings from the config file, the environment and the flags
+	opts, err := loadOptions(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
 		return
 	}
-
-	// Load numberOfAcceptor from the environment and convert to integer
-	numberOfAcceptorStr := os.Getenv("NUMBER_OF_ACCEPTOR")
-	if numberOfAcceptorStr == "" {
-		fmt.Println("NUMBER_OF_ACCEPTOR environment variable is not set")
-		return
-	}
-
-	numberOfAcceptor, err := strconv.Atoi(numberOfAcceptorStr)
 	if err != nil {
-		fmt.Printf("Invalid NUMBER_OF_ACCEPTOR value: %s\n", numberOfAcceptorStr)
-		return
+		fmt.Println("Invalid configuration:", err)
+		os.Exit(2)
 	}
 
-	// Load RabbitMQ configuration from environment variables
-	rabbitmqHost := os.Getenv("RABBITMQ_HOST")
-	if rabbitmqHost == "" {
-		fmt.Println("RABBITMQ_HOST environment variable is not set")
-		return
+	// Select how this server talks to the rest of the cluster
+	var transport paxos.Transport
+	switch opts.Transport {
+	case "amqp":
+		transport, err = newAMQPTransport(opts.BrokerURL)
+	case "tcp":
+		transport, err = paxos.NewTCPTransport(opts.TCPListenAddr, opts.TCPPeers)
 	}
-
-	rabbitmqPort := os.Getenv("RABBITMQ_PORT")
-	if rabbitmqPort == "" {
-		fmt.Println("RABBITMQ_PORT environment variable is not set")
-		return
+	if err != nil {
+		fmt.Println("Failed to create transport:", err)
+		os.Exit(1)
 	}
 
-	rabbitmqUser := os.Getenv("RABBITMQ_USER")
-	if rabbitmqUser == "" {
-		fmt.Println("RABBITMQ_USER environment variable is not set")
-		return
+	// Create and start the Paxos server
+	server, err := paxos.NewServer(transport, opts.Config)
+	if err != nil {
+		transport.Close()
+		fmt.Println("Failed to create Paxos server:", err)
+		os.Exit(1)
 	}
 
-	rabbitmqPass := os.Getenv("RABBITMQ_PASS")
-	if rabbitmqPass == "" {
-		fmt.Println("RABBITMQ_PASS environment variable is not set")
-		return
+	// Stop gracefully on SIGINT and SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := server.Serve(ctx); err != nil {
+		fmt.Println("Paxos server failed:", err)
+		os.Exit(1)
 	}
+}
 
-	// Construct RabbitMQ connection URL
-	rabbitmqURL := fmt.Sprintf("amqp://%s:%s@%s:%s/", rabbitmqUser, rabbitmqPass, rabbitmqHost, rabbitmqPort)
-
-	// Connect to RabbitMQ
-	conn, err := amqp.Dial(rabbitmqURL)
+// newAMQPTransport connects to the RabbitMQ broker at brokerURL.
+func newAMQPTransport(brokerURL string) (paxos.Transport, error) {
+	conn, err := amqp.Dial(brokerURL)
 	if err != nil {
-		fmt.Println("Failed to connect to RabbitMQ:", err)
-		return
+		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
 	}
-	defer conn.Close()
 
-	// Create and start the Paxos server
-	server := paxos.NewServer(conn, serverID, numberOfAcceptor)
-	server.Serve()
+	transport, err := paxos.NewAMQPTransport(conn)
+	if err != nil {
+		return nil, err
+	}
+	return transport, nil
 }
@@ -1,67 +1,175 @@
 package paxos
 
 import (
+	"context"
+	"log"
 	"sync"
-	"time"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/core"
 )
 
+// Acceptor runs a core.Acceptor on the messages of its channels and makes its
+// state durable in a write-ahead log.
 type Acceptor struct {
-	mu             sync.Mutex
-	promisedNumber ProposalNumber
-	acceptedValue  interface{}
-	prepareChan    <-chan Prepare
-	promiseChan    chan<- Promise
-	acceptChan     <-chan Accept
-	acceptedChan   chan<- Accepted
+	mu               sync.Mutex
+	state            *core.Acceptor
+	wal              *WAL
+	prepareChan      <-chan Prepare
+	promiseChan      chan<- Promise
+	acceptChan       <-chan Accept
+	acceptedChan     chan<- Accepted
+	nackChan         chan<- Nack
+	anyChan          <-chan Any
+	fastAcceptChan   <-chan FastAccept
+	fastAcceptedChan chan<- Accepted
 }
 
-// NewAcceptor creates and initializes a new Acceptor with the provided channels
+// NewAcceptor creates and initializes a new Acceptor with the provided channels and
+// restores the state recorded in wal. A nil wal keeps the state in memory only.
 func NewAcceptor(
+	id string,
+	wal *WAL,
 	prepareChan <-chan Prepare,
 	promiseChan chan<- Promise,
 	acceptChan <-chan Accept,
 	acceptedChan chan<- Accepted,
-) *Acceptor {
-	return &Acceptor{
-		promisedNumber: ProposalNumber{},
-		acceptedValue:  nil,
-		prepareChan:    prepareChan,
-		promiseChan:    promiseChan,
-		acceptChan:     acceptChan,
-		acceptedChan:   acceptedChan,
+	nackChan chan<- Nack,
+	anyChan <-chan Any,
+	fastAcceptChan <-chan FastAccept,
+	fastAcceptedChan chan<- Accepted,
+) (*Acceptor, error) {
+	a := &Acceptor{
+		wal:              wal,
+		prepareChan:      prepareChan,
+		promiseChan:      promiseChan,
+		acceptChan:       acceptChan,
+		acceptedChan:     acceptedChan,
+		nackChan:         nackChan,
+		anyChan:          anyChan,
+		fastAcceptChan:   fastAcceptChan,
+		fastAcceptedChan: fastAcceptedChan,
+	}
+	if wal == nil {
+		// A nil *WAL would make a non-nil core.Storage.
+		a.state = core.NewAcceptor(id, nil)
+		return a, nil
+	}
+	a.state = core.NewAcceptor(id, wal)
+
+	records, err := wal.Replay()
+	if err != nil {
+		return nil, err
 	}
+	a.state.Restore(records)
+	log.Printf("Acceptor restored %d WAL records, promised %+v", len(records), a.state.Promised())
+	return a, nil
 }
 
-func (a *Acceptor) Start() {
+// Start handles prepare, accept and fast ballot messages until ctx is done.
+func (a *Acceptor) Start(ctx context.Context) {
 	for {
 		select {
+		case <-ctx.Done():
+			return
+
 		case p := <-a.prepareChan:
-			a.mu.Lock()
-			if p.ProposalNumber.BallotNumber > a.promisedNumber.BallotNumber {
-				a.promisedNumber = p.ProposalNumber
-				a.promiseChan <- Promise{ProposalNumber: a.promisedNumber}
+			if promise, ok := a.HandlePrepare(p); ok {
+				send(ctx, a.promiseChan, promise)
+			} else {
+				send(ctx, a.nackChan, a.Nack(prepareMessageType, p.RequestID, p.Instance, p.ProposalNumber))
 			}
-			a.mu.Unlock()
 
 		case ac := <-a.acceptChan:
-			a.mu.Lock()
-			if ac.ProposalNumber.BallotNumber > a.promisedNumber.BallotNumber ||
-				(ac.ProposalNumber.BallotNumber == a.promisedNumber.BallotNumber &&
-					ac.ProposalNumber.ProposerID == a.promisedNumber.ProposerID) {
-				a.promisedNumber = ac.ProposalNumber
-				a.acceptedValue = ac.Value
-				a.acceptedChan <- Accepted{ProposalNumber: a.promisedNumber, Value: a.acceptedValue}
+			if accepted, ok := a.HandleAccept(ac); ok {
+				send(ctx, a.acceptedChan, accepted)
+			} else {
+				send(ctx, a.nackChan, a.Nack(acceptMessageType, ac.RequestID, ac.Instance, ac.ProposalNumber))
 			}
-			a.mu.Unlock()
 
-		default:
-			time.Sleep(time.Millisecond)
+		case open := <-a.anyChan:
+			a.HandleAny(open)
+
+		case fa := <-a.fastAcceptChan:
+			// A client that gets no answer waits for the coordinator to recover the
+			// instance, so rejections are not sent back.
+			if accepted, ok := a.HandleFastAccept(fa); ok {
+				send(ctx, a.fastAcceptedChan, accepted)
+			}
 		}
 	}
 }
 
+// HandlePrepare processes p and returns the promise to send back, if any.
+func (a *Acceptor) HandlePrepare(p Prepare) (Promise, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.state.HandlePrepare(p)
+}
+
+// HandleAccept processes ac and returns the acknowledgement to send back, if any.
+func (a *Acceptor) HandleAccept(ac Accept) (Accepted, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.state.HandleAccept(ac)
+}
+
+// HandleAny opens the fast ballot of open unless a higher ballot was promised.
+func (a *Acceptor) HandleAny(open Any) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.state.HandleAny(open)
+}
+
+// HandleFastAccept accepts the value of fa in the open fast ballot and returns the
+// acknowledgement to send back, if any.
+func (a *Acceptor) HandleFastAccept(fa FastAccept) (Accepted, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.state.HandleFastAccept(fa)
+}
+
+// Nack returns the rejection of the phase message requestID sent for instance with
+// proposal number rejected, carrying the ballot this acceptor promised.
+func (a *Acceptor) Nack(phase string, requestID string, instance int, rejected ProposalNumber) Nack {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.state.Nack(phase, requestID, instance, rejected)
+}
+
+// Compact forgets what was accepted for the instances up to and including
+// instance, which must be decided and covered by a snapshot, and rewrites the WAL
+// to the remaining state.
+func (a *Acceptor) Compact(instance int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	records, compacted := a.state.Compact(instance)
+	if !compacted || a.wal == nil {
+		return nil
+	}
+	return a.wal.Rewrite(records)
+}
+
+// Close closes the write-ahead log. The acceptor must not handle messages after.
+func (a *Acceptor) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.wal == nil {
+		return nil
+	}
+	return a.wal.Close()
+}
+
+// send delivers v on ch unless ctx is done first.
+func send[T any](ctx context.Context, ch chan<- T, v T) {
+	select {
+	case ch <- v:
+	case <-ctx.Done():
+	}
+}
+
 func (a *Acceptor) GetBallotNumber() int {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	return a.promisedNumber.BallotNumber
+	return a.state.Promised().BallotNumber
 }
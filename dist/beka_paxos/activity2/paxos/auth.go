@@ -0,0 +1,289 @@
+package paxos
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replayWindow is how far below the highest sequence number seen from a sender a
+// message may still arrive, as concurrent broadcasts can overtake each other.
+// Sequence numbers are nanosecond timestamps, so it is a duration, and the
+// servers' clocks have to agree to within it.
+const replayWindow = uint64(time.Second)
+
+var (
+	ErrUnsigned       = errors.New("message is not signed")
+	ErrUnknownSender  = errors.New("message is from an unknown sender")
+	ErrBadSignature   = errors.New("message signature is invalid")
+	ErrSenderMismatch = errors.New("message claims to be from another server than its sender")
+	ErrReplayed       = errors.New("message was already received")
+)
+
+// Authenticator signs the messages a server sends and verifies the ones it
+// receives, so that only servers holding a configured key can take part in the
+// protocol even on a broker other publishers can reach. Signed messages carry a
+// sequence number, and Verify rejects a message it already verified once.
+type Authenticator interface {
+	Sign(message *QueueMessage) error
+	Verify(message QueueMessage) error
+}
+
+// HMACAuthenticator signs with HMAC-SHA256. Each sender has its own key; a cluster
+// that shares one key lists it for every member.
+type HMACAuthenticator struct {
+	id       string
+	keys     map[string][]byte
+	sequence *sequencer
+}
+
+// NewHMACAuthenticator signs as id with keys[id] and accepts messages from the
+// other senders in keys.
+func NewHMACAuthenticator(id string, keys map[string][]byte) (*HMACAuthenticator, error) {
+	if len(keys[id]) == 0 {
+		return nil, fmt.Errorf("no HMAC key for %s", id)
+	}
+	return &HMACAuthenticator{id: id, keys: keys, sequence: newSequencer()}, nil
+}
+
+func (a *HMACAuthenticator) Sign(message *QueueMessage) error {
+	message.Sender = a.id
+	message.Sequence = a.sequence.next()
+	message.Signature = a.mac(a.keys[a.id], *message)
+	return nil
+}
+
+func (a *HMACAuthenticator) Verify(message QueueMessage) error {
+	if len(message.Signature) == 0 {
+		return ErrUnsigned
+	}
+	key, ok := a.keys[message.Sender]
+	if !ok {
+		return ErrUnknownSender
+	}
+	if !hmac.Equal(message.Signature, a.mac(key, message)) {
+		return ErrBadSignature
+	}
+	return a.sequence.observe(message.Sender, message.Sequence)
+}
+
+func (a *HMACAuthenticator) mac(key []byte, message QueueMessage) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(signedBytes(message))
+	return h.Sum(nil)
+}
+
+// Ed25519Authenticator signs with the server's private key and verifies with the
+// public keys of the other servers, so no server can sign for another.
+type Ed25519Authenticator struct {
+	id       string
+	private  ed25519.PrivateKey
+	public   map[string]ed25519.PublicKey
+	sequence *sequencer
+}
+
+func NewEd25519Authenticator(id string, private ed25519.PrivateKey, public map[string]ed25519.PublicKey) *Ed25519Authenticator {
+	return &Ed25519Authenticator{id: id, private: private, public: public, sequence: newSequencer()}
+}
+
+func (a *Ed25519Authenticator) Sign(message *QueueMessage) error {
+	message.Sender = a.id
+	message.Sequence = a.sequence.next()
+	message.Signature = ed25519.Sign(a.private, signedBytes(*message))
+	return nil
+}
+
+func (a *Ed25519Authenticator) Verify(message QueueMessage) error {
+	if len(message.Signature) == 0 {
+		return ErrUnsigned
+	}
+	key, ok := a.public[message.Sender]
+	if !ok {
+		return ErrUnknownSender
+	}
+	if !ed25519.Verify(key, signedBytes(message), message.Signature) {
+		return ErrBadSignature
+	}
+	return a.sequence.observe(message.Sender, message.Sequence)
+}
+
+// signedBytes is what a signature covers: every field of message but the
+// signature, each prefixed with its length. JSON messages are signed the way
+// servers that predate binary messages sign them, so those servers can still
+// verify them during a rolling upgrade. Binary messages also cover the wire version
+// they are encoded in and the highest one their sender supports.
+func signedBytes(message QueueMessage) []byte {
+	b := []byte("paxos-message-v1")
+	if message.Version > JSONWireVersion {
+		b = []byte("paxos-message-v2")
+	}
+	for _, field := range [][]byte{[]byte(message.Type), []byte(message.Sender), message.Body} {
+		b = binary.AppendUvarint(b, uint64(len(field)))
+		b = append(b, field...)
+	}
+	b = binary.BigEndian.AppendUint64(b, message.Clock)
+	if message.Version > JSONWireVersion {
+		b = binary.AppendUvarint(b, uint64(message.Version))
+		b = binary.AppendUvarint(b, uint64(message.MaxVersion))
+	}
+	return binary.BigEndian.AppendUint64(b, message.Sequence)
+}
+
+// sequencer numbers the messages a server signs and remembers the numbers it
+// verified from every sender. A number is the time the message was signed in
+// nanoseconds, or the previous number plus one if that is higher, so a restarted
+// server continues above the numbers it used before. Having forgotten what it
+// verified before, a restarted server rejects every number from before it started,
+// less replayWindow.
+type sequencer struct {
+	last    atomic.Uint64
+	floor   uint64
+	mu      sync.Mutex
+	senders map[string]*sequenceWindow
+}
+
+// sequenceWindow is the highest sequence number verified from a sender and the
+// numbers verified within replayWindow below it, in the order they arrived.
+type sequenceWindow struct {
+	highest uint64
+	seen    map[uint64]struct{}
+	order   []uint64
+}
+
+func newSequencer() *sequencer {
+	return &sequencer{
+		floor:   uint64(time.Now().UnixNano()) - replayWindow,
+		senders: make(map[string]*sequenceWindow),
+	}
+}
+
+func (s *sequencer) next() uint64 {
+	for {
+		last := s.last.Load()
+		next := max(last+1, uint64(time.Now().UnixNano()))
+		if s.last.CompareAndSwap(last, next) {
+			return next
+		}
+	}
+}
+
+// observe records that the message numbered sequence from sender was verified. It
+// fails with ErrReplayed if that number was seen already, predates this server or
+// is too far below the highest one to tell.
+func (s *sequencer) observe(sender string, sequence uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sequence < s.floor {
+		return fmt.Errorf("%w: sequence %d from %s predates this server", ErrReplayed, sequence, sender)
+	}
+	w, ok := s.senders[sender]
+	if !ok {
+		w = &sequenceWindow{seen: make(map[uint64]struct{})}
+		s.senders[sender] = w
+	}
+	if _, seen := w.seen[sequence]; seen || sequence+replayWindow <= w.highest {
+		return fmt.Errorf("%w: sequence %d from %s", ErrReplayed, sequence, sender)
+	}
+	w.add(sequence)
+	return nil
+}
+
+// add records n and forgets the numbers that fell out of the window. Numbers that
+// arrived out of order may be kept a little longer; the window check covers them.
+func (w *sequenceWindow) add(n uint64) {
+	w.highest = max(w.highest, n)
+	w.seen[n] = struct{}{}
+	w.order = append(w.order, n)
+	for len(w.order) > 0 && w.order[0]+replayWindow <= w.highest {
+		delete(w.seen, w.order[0])
+		w.order = w.order[1:]
+	}
+}
+
+// claimedSender returns the server ID the body of message names as its author, or
+// "" for message types that do not name one.
+func claimedSender(message QueueMessage) string {
+	body := newBody(message.Type)
+	if body == nil || decodeBody(message, body) != nil {
+		return ""
+	}
+	switch body := body.(type) {
+	case *Prepare:
+		return body.ProposalNumber.ProposerID
+	case *Accept:
+		return body.ProposalNumber.ProposerID
+	case *Promise:
+		return body.AcceptorID
+	case *Accepted:
+		return body.AcceptorID
+	case *Nack:
+		return body.AcceptorID
+	case *Heartbeat:
+		return body.LeaderID
+	case *HeartbeatAck:
+		return body.FollowerID
+	case *Any:
+		return body.ProposalNumber.ProposerID
+	case *FastAccept:
+		return body.ClientID
+	}
+	return ""
+}
+
+// authenticate verifies message and that the server it names as its author is its
+// signer. It returns the reason for rejecting it as a metric label, or "".
+func (s *Server) authenticate(message QueueMessage) (string, error) {
+	if s.auth == nil {
+		return "", nil
+	}
+	if err := s.auth.Verify(message); err != nil {
+		switch {
+		case errors.Is(err, ErrUnsigned):
+			return "unsigned", err
+		case errors.Is(err, ErrUnknownSender):
+			return "unknown_sender", err
+		case errors.Is(err, ErrReplayed):
+			return "replayed", err
+		default:
+			return "bad_signature", err
+		}
+	}
+	if claimed := claimedSender(message); claimed != "" && claimed != message.Sender {
+		return "sender_mismatch", fmt.Errorf("%w: %s signed a message from %s", ErrSenderMismatch, message.Sender, claimed)
+	}
+	return "", nil
+}
+
+// ReadKeys reads lines of a server ID and a base64-encoded key, separated by
+// blanks. Empty lines and lines starting with # are skipped.
+func ReadKeys(r io.Reader) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected an ID and a key", line)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		keys[fields[0]] = key
+	}
+	return keys, scanner.Err()
+}
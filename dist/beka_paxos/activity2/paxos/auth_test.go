@@ -0,0 +1,190 @@
+package paxos
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// authenticators returns a signer and a verifier of every kind.
+func authenticators(t *testing.T) map[string][2]Authenticator {
+	t.Helper()
+	keys := map[string][]byte{"node1": []byte("key1"), "node2": []byte("key2")}
+	hmac1, err := NewHMACAuthenticator("node1", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmac2, err := NewHMACAuthenticator("node2", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public1, private1, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public2, private2, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := map[string]ed25519.PublicKey{"node1": public1, "node2": public2}
+	return map[string][2]Authenticator{
+		"hmac":    {hmac1, hmac2},
+		"ed25519": {NewEd25519Authenticator("node1", private1, public), NewEd25519Authenticator("node2", private2, public)},
+	}
+}
+
+// sequencerOf returns the sequencer of an authenticator from authenticators.
+func sequencerOf(a Authenticator) *sequencer {
+	switch a := a.(type) {
+	case *HMACAuthenticator:
+		return a.sequence
+	case *Ed25519Authenticator:
+		return a.sequence
+	}
+	return nil
+}
+
+// sign signs a heartbeat in version as signer and passes it through the wire
+// encoding, as a receiver would see it.
+func sign(t *testing.T, signer Authenticator, version int) QueueMessage {
+	t.Helper()
+	message := QueueMessage{Type: heartbeatMessageType, Body: []byte("{}"), Version: version, MaxVersion: LatestWireVersion}
+	if err := signer.Sign(&message); err != nil {
+		t.Fatal(err)
+	}
+	data, err := EncodeMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := DecodeMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return received
+}
+
+func TestAuthenticatorRejectsReplays(t *testing.T) {
+	for name, pair := range authenticators(t) {
+		t.Run(name, func(t *testing.T) {
+			signer, verifier := pair[0], pair[1]
+			for _, version := range []int{JSONWireVersion, BinaryWireVersion} {
+				first := sign(t, signer, version)
+				second := sign(t, signer, version)
+				if second.Sequence <= first.Sequence {
+					t.Fatalf("sequence %d after %d", second.Sequence, first.Sequence)
+				}
+
+				// Concurrent broadcasts may arrive out of order.
+				if err := verifier.Verify(second); err != nil {
+					t.Fatal(err)
+				}
+				if err := verifier.Verify(first); err != nil {
+					t.Fatal(err)
+				}
+				for _, message := range []QueueMessage{first, second} {
+					if err := verifier.Verify(message); !errors.Is(err, ErrReplayed) {
+						t.Fatalf("replayed version %d message: got %v, want %v", version, err, ErrReplayed)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAuthenticatorRejectsReplaysAfterRestart(t *testing.T) {
+	for name, pair := range authenticators(t) {
+		t.Run(name, func(t *testing.T) {
+			message := sign(t, pair[0], BinaryWireVersion)
+			if err := pair[1].Verify(message); err != nil {
+				t.Fatal(err)
+			}
+
+			// The verifier restarts more than replayWindow after the message was
+			// signed and no longer remembers it.
+			restarted := sequencerOf(pair[1])
+			restarted.floor = message.Sequence + 1
+			clear(restarted.senders)
+			if err := pair[1].Verify(message); !errors.Is(err, ErrReplayed) {
+				t.Fatalf("got %v, want %v", err, ErrReplayed)
+			}
+			if err := pair[1].Verify(sign(t, pair[0], BinaryWireVersion)); err != nil {
+				t.Fatalf("message signed after the restart: %v", err)
+			}
+		})
+	}
+}
+
+func TestSequencer(t *testing.T) {
+	s := newSequencer()
+	first := s.next()
+	if first < s.floor+replayWindow {
+		t.Fatalf("first sequence %d is below the start time %d", first, s.floor+replayWindow)
+	}
+	if next := s.next(); next <= first {
+		t.Fatalf("sequence %d after %d", next, first)
+	}
+
+	steps := []struct {
+		sequence uint64
+		replayed bool
+	}{
+		{first, false},
+		{first, true},
+		{first + replayWindow, false},
+		{first + 1, false},
+		{first + 1, true},
+		{first, true},
+		{s.floor - 1, true},
+		{first + 2*replayWindow, false},
+		{first + replayWindow, true},
+		{first + replayWindow + 2, false},
+	}
+	for i, step := range steps {
+		err := s.observe("node1", step.sequence)
+		if replayed := errors.Is(err, ErrReplayed); replayed != step.replayed {
+			t.Fatalf("step %d: observe(%d) = %v, want replayed %t", i, step.sequence, err, step.replayed)
+		}
+	}
+	if err := s.observe("node2", first); err != nil {
+		t.Fatalf("first message of another sender: %v", err)
+	}
+}
+
+func TestAuthenticatorSignsVersionsAndSequence(t *testing.T) {
+	tamper := map[string]func(*QueueMessage){
+		"version":     func(m *QueueMessage) { m.Version = JSONWireVersion },
+		"max version": func(m *QueueMessage) { m.MaxVersion = JSONWireVersion },
+		"sequence":    func(m *QueueMessage) { m.Sequence++ },
+	}
+	for name, pair := range authenticators(t) {
+		for field, change := range tamper {
+			t.Run(name+"/"+field, func(t *testing.T) {
+				message := sign(t, pair[0], BinaryWireVersion)
+				change(&message)
+				if err := pair[1].Verify(message); !errors.Is(err, ErrBadSignature) {
+					t.Fatalf("got %v, want %v", err, ErrBadSignature)
+				}
+			})
+		}
+	}
+}
+
+// TestJSONMessagesSignedLikeBefore checks that JSON messages are signed the way
+// servers that predate binary messages sign and verify them.
+func TestJSONMessagesSignedLikeBefore(t *testing.T) {
+	message := QueueMessage{Type: heartbeatMessageType, Sender: "node1", Body: []byte("{}"), Clock: 3, Sequence: 5,
+		Version: JSONWireVersion, MaxVersion: LatestWireVersion}
+	want := []byte("paxos-message-v1")
+	for _, field := range [][]byte{[]byte(message.Type), []byte(message.Sender), message.Body} {
+		want = binary.AppendUvarint(want, uint64(len(field)))
+		want = append(want, field...)
+	}
+	want = binary.BigEndian.AppendUint64(want, message.Clock)
+	want = binary.BigEndian.AppendUint64(want, message.Sequence)
+	if got := signedBytes(message); !bytes.Equal(got, want) {
+		t.Fatalf("signed bytes %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,155 @@
+package paxos
+
+import (
+	"context"
+	"time"
+)
+
+// batchKey is the only key of a batch once it went through JSON.
+const batchKey = "paxos:batch"
+
+// Batch is a log entry holding several values, decided together in one instance
+// and applied in order.
+type Batch struct {
+	Values []interface{} `json:"paxos:batch"`
+}
+
+// batchValues returns the values of a log entry: those of a batch, or the entry
+// itself. Batches that went through JSON arrive as generic maps.
+func batchValues(value interface{}) []interface{} {
+	switch value := value.(type) {
+	case Batch:
+		return value.Values
+	case map[string]interface{}:
+		if values, ok := value[batchKey].([]interface{}); ok && len(value) == 1 {
+			return values
+		}
+	}
+	return []interface{}{value}
+}
+
+// position is where a proposed value ended up: its instance, its index in the
+// batch decided there and the ballot that batch was chosen with, which is zero
+// when a fast round chose it.
+type position struct {
+	instance int
+	index    int
+	ballot   ProposalNumber
+}
+
+type batchRequest struct {
+	value interface{}
+	done  chan batchResult
+}
+
+type batchResult struct {
+	position
+	err error
+}
+
+// propose gets value chosen in the log. With batching, values proposed while
+// earlier entries are in flight are collected into one batch, and up to
+// cfg.PipelineDepth entries are proposed at a time. With fast rounds every server
+// batches the values it sends to the acceptors.
+func (s *Server) propose(ctx context.Context, value interface{}) (position, error) {
+	if s.cfg.BatchSize <= 1 {
+		return s.proposeEntry(ctx, value)
+	}
+	if s.closing.Load() {
+		return position{}, ErrServerClosed
+	}
+	if !s.cfg.FastRounds && !s.leadership.isLeader() {
+		return position{}, ErrNotLeader
+	}
+
+	request := &batchRequest{value: value, done: make(chan batchResult, 1)}
+	select {
+	case s.batchRequests <- request:
+	case <-s.closed:
+		return position{}, ErrServerClosed
+	case <-ctx.Done():
+		return position{}, ctx.Err()
+	}
+	select {
+	case result := <-request.done:
+		return result.position, result.err
+	case <-ctx.Done():
+		return position{}, ctx.Err()
+	}
+}
+
+// runBatcher collects proposed values into batches. A batch is proposed once it is
+// full or, when it holds a value for cfg.FlushInterval, and a pipeline slot is
+// free; values keep joining it while all slots are taken.
+func (s *Server) runBatcher(ctx context.Context) {
+	slots := make(chan struct{}, s.cfg.PipelineDepth)
+	var pending []*batchRequest
+	var flush <-chan time.Time
+	var timer *time.Timer
+	due := false
+
+	for {
+		requests := s.batchRequests
+		if len(pending) >= s.cfg.BatchSize {
+			requests = nil
+		}
+		var slot chan struct{}
+		if len(pending) > 0 && (due || len(pending) >= s.cfg.BatchSize) {
+			slot = slots
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, request := range pending {
+				request.done <- batchResult{err: ErrServerClosed}
+			}
+			return
+
+		case request := <-requests:
+			pending = append(pending, request)
+			if len(pending) == 1 {
+				if s.cfg.FlushInterval > 0 {
+					timer = time.NewTimer(s.cfg.FlushInterval)
+					flush = timer.C
+				} else {
+					due = true
+				}
+			}
+
+		case <-flush:
+			due = true
+
+		case slot <- struct{}{}:
+			batch := pending
+			pending, flush, due = nil, nil, false
+			if timer != nil {
+				timer.Stop()
+			}
+			go func() {
+				defer func() { <-slots }()
+				s.proposeBatch(ctx, batch)
+			}()
+		}
+	}
+}
+
+// proposeBatch proposes the values of batch as one entry and reports where each
+// ended up to the request that proposed it.
+func (s *Server) proposeBatch(ctx context.Context, batch []*batchRequest) {
+	s.metrics.batched(len(batch))
+	var value interface{} = batch[0].value
+	if len(batch) > 1 {
+		values := make([]interface{}, len(batch))
+		for i, request := range batch {
+			values[i] = request.value
+		}
+		value = Batch{Values: values}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.ProposeTimeout)
+	defer cancel()
+	pos, err := s.proposeEntry(ctx, value)
+	for i, request := range batch {
+		request.done <- batchResult{position: position{instance: pos.instance, index: i, ballot: pos.ballot}, err: err}
+	}
+}
@@ -0,0 +1,84 @@
+package paxos
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/internal/cputime"
+)
+
+// BenchmarkIdleCluster measures the CPU a three-server cluster burns while no
+// proposals arrive, so only heartbeats and blocked loops remain. Each operation
+// is 10ms of idling; the result is reported as a percentage of one core.
+func BenchmarkIdleCluster(b *testing.B) {
+	servers := startCluster(b, 3, Config{})
+	waitForLeader(b, servers, "warmup")
+	time.Sleep(100 * time.Millisecond)
+
+	before, err := cputime.Process()
+	if err != nil {
+		b.Skip(err)
+	}
+	start := time.Now()
+	b.ResetTimer()
+	for range b.N {
+		time.Sleep(10 * time.Millisecond)
+	}
+	b.StopTimer()
+	after, err := cputime.Process()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(100*(after-before).Seconds()/time.Since(start).Seconds(), "%cpu")
+	b.ReportMetric(float64(runtime.NumGoroutine()), "goroutines")
+}
+
+// BenchmarkThroughput measures proposals decided by the leader of a three-server
+// cluster while 64 clients propose concurrently, across batch sizes and pipeline
+// depths, in classic and in fast ballots. Each operation is one decided proposal.
+func BenchmarkThroughput(b *testing.B) {
+	for _, fast := range []bool{false, true} {
+		for _, bc := range []struct {
+			batchSize     int
+			pipelineDepth int
+		}{
+			{1, 1},
+			{1, 0},
+			{64, 1},
+			{64, 0},
+		} {
+			name := fmt.Sprintf("batch=%d/pipeline=%d", bc.batchSize, bc.pipelineDepth)
+			if fast {
+				name = "fast/" + name
+			}
+			b.Run(name, func(b *testing.B) {
+				benchmarkThroughput(b, Config{BatchSize: bc.batchSize, PipelineDepth: bc.pipelineDepth, FastRounds: fast})
+			})
+		}
+	}
+}
+
+func benchmarkThroughput(b *testing.B, cfg Config) {
+	servers := startCluster(b, 3, cfg)
+	leader := waitForLeader(b, servers, "warmup")
+
+	var next atomic.Int64
+	b.SetParallelism(max(1, 64/runtime.GOMAXPROCS(0)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_, err := leader.Propose(ctx, fmt.Sprintf("value-%d", next.Add(1)))
+			cancel()
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "proposals/s")
+}
@@ -0,0 +1,160 @@
+package paxos
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// startCluster runs n servers under cfg on one memory bus until the test ends.
+func startCluster(tb testing.TB, n int, cfg Config) []*Server {
+	tb.Helper()
+	cfgs := make([]Config, n)
+	for i := range cfgs {
+		cfgs[i] = cfg
+	}
+	return startServers(tb, cfgs)
+}
+
+// startServers runs a server under each of cfgs on one memory bus until the test
+// ends, filling in the IDs, data directories and number of acceptors.
+func startServers(tb testing.TB, cfgs []Config) []*Server {
+	tb.Helper()
+	dir := tb.TempDir()
+	bus := NewMemoryBus()
+	servers := make([]*Server, len(cfgs))
+	for i, cfg := range cfgs {
+		cfg.ID = fmt.Sprintf("node%d", i+1)
+		cfg.DataDir = filepath.Join(dir, cfg.ID)
+		cfg.NumberOfAccepters = len(cfgs)
+		server, err := NewServer(bus.Join(), cfg)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		servers[i] = server
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			server.Run(ctx)
+		}()
+	}
+	tb.Cleanup(func() {
+		stop()
+		wg.Wait()
+	})
+	return servers
+}
+
+// waitForLeader returns the server that got elected, found by proposing value to
+// each server until one accepts.
+func waitForLeader(tb testing.TB, servers []*Server, value interface{}) *Server {
+	tb.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, server := range servers {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_, err := server.Propose(ctx, value)
+			cancel()
+			if err == nil {
+				return server
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	tb.Fatal("no leader got elected")
+	return nil
+}
+
+func TestClusterAgreesOnLog(t *testing.T) {
+	servers := startCluster(t, 3, Config{})
+	leader := waitForLeader(t, servers, "first")
+
+	const proposals = 30
+	var wg sync.WaitGroup
+	errs := make(chan error, proposals)
+	for i := range proposals {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := proposeToLeader(servers, leader, fmt.Sprintf("value-%d", i)); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("propose: %s", err)
+	}
+
+	// Followers, and a leader that lost its leadership, learn the decisions
+	// asynchronously.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		want := servers[0].Log().Entries(1)
+		decided := make(map[interface{}]int)
+		for _, entry := range want {
+			for _, value := range batchValues(entry.Value) {
+				decided[value]++
+			}
+		}
+		agreed := true
+		for i := range proposals {
+			agreed = agreed && decided[fmt.Sprintf("value-%d", i)] == 1
+		}
+		for _, server := range servers[1:] {
+			agreed = agreed && reflect.DeepEqual(server.Log().Entries(1), want)
+		}
+		if agreed {
+			return
+		}
+		if time.Now().After(deadline) {
+			for i := range proposals {
+				if n := decided[fmt.Sprintf("value-%d", i)]; n != 1 {
+					t.Fatalf("value-%d decided %d times, want once", i, n)
+				}
+			}
+			t.Fatal("the logs of the servers disagree")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// proposeToLeader proposes value to leader, or to whichever server took over if
+// leader lost its leadership before it proposed value.
+func proposeToLeader(servers []*Server, leader *Server, value interface{}) error {
+	deadline := time.Now().Add(5 * time.Second)
+	for i := 0; ; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := leader.Propose(ctx, value)
+		cancel()
+		if !errors.Is(err, ErrNotLeader) || time.Now().After(deadline) {
+			return err
+		}
+		// Servers that campaigned at the same time may still take over.
+		leader = servers[i%len(servers)]
+		time.Sleep(10 * time.Millisecond)
+	}
+}
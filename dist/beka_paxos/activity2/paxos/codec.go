@@ -0,0 +1,513 @@
+package paxos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/core"
+)
+
+// Wire versions. Version 1 is JSON, which every server understands. Version 2
+// encodes messages and their bodies in a length-prefixed binary format in which
+// values keep their types: integers stay integers instead of becoming float64.
+const (
+	JSONWireVersion   = 1
+	BinaryWireVersion = 2
+	LatestWireVersion = BinaryWireVersion
+)
+
+// binaryMagic starts every binary frame, so it cannot be mistaken for JSON.
+const binaryMagic = 0xA5
+
+// maxFrameSize bounds the binary frames and JSON lines a TCP peer may send.
+const maxFrameSize = 16 * 1024 * 1024
+
+var ErrUnsupportedVersion = errors.New("unsupported wire version")
+
+// Codec encodes messages and their bodies in one wire version.
+type Codec interface {
+	Version() int
+	EncodeBody(v interface{}) ([]byte, error)
+	DecodeBody(data []byte, v interface{}) error
+	EncodeMessage(message QueueMessage) ([]byte, error)
+}
+
+// CodecFor returns the codec of the given wire version.
+func CodecFor(version int) (Codec, error) {
+	switch version {
+	case JSONWireVersion:
+		return jsonCodec{}, nil
+	case BinaryWireVersion:
+		return binaryCodec{}, nil
+	}
+	return nil, fmt.Errorf("%w %d", ErrUnsupportedVersion, version)
+}
+
+// EncodeMessage encodes message in the wire version its body was encoded in.
+func EncodeMessage(message QueueMessage) ([]byte, error) {
+	codec, err := CodecFor(max(message.Version, JSONWireVersion))
+	if err != nil {
+		return nil, err
+	}
+	return codec.EncodeMessage(message)
+}
+
+// DecodeMessage decodes a message of any supported wire version.
+func DecodeMessage(data []byte) (QueueMessage, error) {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	if len(data) > 0 && data[0] == '{' {
+		var message QueueMessage
+		err := json.Unmarshal(data, &message)
+		message.Version = JSONWireVersion
+		return message, err
+	}
+	if len(data) < 2 || data[0] != binaryMagic {
+		return QueueMessage{}, errors.New("message is neither JSON nor a binary frame")
+	}
+	if data[1] != BinaryWireVersion {
+		return QueueMessage{}, fmt.Errorf("%w %d", ErrUnsupportedVersion, data[1])
+	}
+
+	r := &binaryReader{data: data[2:]}
+	message := QueueMessage{
+		Version:    BinaryWireVersion,
+		MaxVersion: int(r.uvarint()),
+		Type:       r.string(),
+		Sender:     r.string(),
+		Clock:      r.uvarint(),
+		Body:       r.bytes(),
+		Signature:  r.bytes(),
+	}
+	// Frames of servers that predate sequence numbers end after the signature.
+	if len(r.data) > 0 {
+		message.Sequence = r.uvarint()
+	}
+	return message, r.err
+}
+
+// decodeBody decodes the body of message into v.
+func decodeBody(message QueueMessage, v interface{}) error {
+	codec, err := CodecFor(max(message.Version, JSONWireVersion))
+	if err != nil {
+		return err
+	}
+	return codec.DecodeBody(message.Body, v)
+}
+
+// newBody returns a pointer to the body type of messageType, or nil if it is not
+// a protocol message.
+func newBody(messageType string) interface{} {
+	switch messageType {
+	case prepareMessageType:
+		return &Prepare{}
+	case promiseMessageType:
+		return &Promise{}
+	case acceptMessageType:
+		return &Accept{}
+	case acceptedMessageType:
+		return &Accepted{}
+	case nackMessageType:
+		return &Nack{}
+	case decideMessageType:
+		return &Decide{}
+	case heartbeatMessageType:
+		return &Heartbeat{}
+	case heartbeatAckMessageType:
+		return &HeartbeatAck{}
+	case anyMessageType:
+		return &Any{}
+	case fastAcceptMessageType:
+		return &FastAccept{}
+	case fastAcceptedMessageType:
+		return &Accepted{}
+	}
+	return nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Version() int { return JSONWireVersion }
+
+func (jsonCodec) EncodeBody(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) DecodeBody(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+func (jsonCodec) EncodeMessage(message QueueMessage) ([]byte, error) { return json.Marshal(message) }
+
+type binaryCodec struct{}
+
+func (binaryCodec) Version() int { return BinaryWireVersion }
+
+func (binaryCodec) EncodeMessage(message QueueMessage) ([]byte, error) {
+	b := []byte{binaryMagic, BinaryWireVersion}
+	b = binary.AppendUvarint(b, uint64(message.MaxVersion))
+	b = appendString(b, message.Type)
+	b = appendString(b, message.Sender)
+	b = binary.AppendUvarint(b, message.Clock)
+	b = appendBytes(b, message.Body)
+	b = appendBytes(b, message.Signature)
+	return binary.AppendUvarint(b, message.Sequence), nil
+}
+
+func (binaryCodec) EncodeBody(v interface{}) ([]byte, error) {
+	var b []byte
+	var err error
+	switch m := v.(type) {
+	case Prepare:
+		b = appendString(b, m.RequestID)
+		b = binary.AppendVarint(b, int64(m.Instance))
+		b = appendNumber(b, m.ProposalNumber)
+	case Promise:
+		b = appendString(b, m.RequestID)
+		b = appendString(b, m.AcceptorID)
+		b = binary.AppendVarint(b, int64(m.Instance))
+		b = appendNumber(b, m.ProposalNumber)
+		b = appendNumber(b, m.AcceptedNumber)
+		b, err = appendValue(b, m.AcceptedValue)
+		b = binary.AppendVarint(b, int64(m.LastInstance))
+	case Accept:
+		b = appendString(b, m.RequestID)
+		b = binary.AppendVarint(b, int64(m.Instance))
+		b = appendNumber(b, m.ProposalNumber)
+		b, err = appendValue(b, m.Value)
+	case Accepted:
+		b = appendString(b, m.RequestID)
+		b = appendString(b, m.AcceptorID)
+		b = binary.AppendVarint(b, int64(m.Instance))
+		b = appendNumber(b, m.ProposalNumber)
+		b, err = appendValue(b, m.Value)
+	case Nack:
+		b = appendString(b, m.RequestID)
+		b = appendString(b, m.AcceptorID)
+		b = appendString(b, m.Phase)
+		b = binary.AppendVarint(b, int64(m.Instance))
+		b = appendNumber(b, m.ProposalNumber)
+		b = appendNumber(b, m.PromisedNumber)
+	case Decide:
+		b = binary.AppendVarint(b, int64(m.Instance))
+		b, err = appendValue(b, m.Value)
+	case Heartbeat:
+		b = appendString(b, m.LeaderID)
+		b = appendString(b, m.Address)
+		b = appendNumber(b, m.ProposalNumber)
+		b = binary.AppendVarint(b, int64(m.Sequence))
+		b = binary.AppendVarint(b, int64(m.LastInstance))
+	case HeartbeatAck:
+		b = appendString(b, m.FollowerID)
+		b = appendString(b, m.LeaderID)
+		b = binary.AppendVarint(b, int64(m.Sequence))
+	case core.Record:
+		b = appendString(b, m.Type)
+		b = binary.AppendVarint(b, int64(m.Instance))
+		b = appendNumber(b, m.ProposalNumber)
+		b, err = appendValue(b, m.Value)
+	case Any:
+		b = appendString(b, m.RequestID)
+		b = binary.AppendVarint(b, int64(m.Instance))
+		b = appendNumber(b, m.ProposalNumber)
+	case FastAccept:
+		b = appendString(b, m.RequestID)
+		b = appendString(b, m.ClientID)
+		b = binary.AppendVarint(b, int64(m.Instance))
+		b, err = appendValue(b, m.Value)
+	default:
+		return json.Marshal(v)
+	}
+	return b, err
+}
+
+func (binaryCodec) DecodeBody(data []byte, v interface{}) error {
+	r := &binaryReader{data: data}
+	switch m := v.(type) {
+	case *Prepare:
+		*m = Prepare{RequestID: r.string(), Instance: r.int(), ProposalNumber: r.number()}
+	case *Promise:
+		*m = Promise{RequestID: r.string(), AcceptorID: r.string(), Instance: r.int(),
+			ProposalNumber: r.number(), AcceptedNumber: r.number(), AcceptedValue: r.value(), LastInstance: r.int()}
+	case *Accept:
+		*m = Accept{RequestID: r.string(), Instance: r.int(), ProposalNumber: r.number(), Value: r.value()}
+	case *Accepted:
+		*m = Accepted{RequestID: r.string(), AcceptorID: r.string(), Instance: r.int(),
+			ProposalNumber: r.number(), Value: r.value()}
+	case *Nack:
+		*m = Nack{RequestID: r.string(), AcceptorID: r.string(), Phase: r.string(), Instance: r.int(),
+			ProposalNumber: r.number(), PromisedNumber: r.number()}
+	case *Decide:
+		*m = Decide{Instance: r.int(), Value: r.value()}
+	case *Heartbeat:
+		*m = Heartbeat{LeaderID: r.string(), Address: r.string(), ProposalNumber: r.number(),
+			Sequence: r.int(), LastInstance: r.int()}
+	case *HeartbeatAck:
+		*m = HeartbeatAck{FollowerID: r.string(), LeaderID: r.string(), Sequence: r.int()}
+	case *core.Record:
+		*m = core.Record{Type: r.string(), Instance: r.int(), ProposalNumber: r.number(), Value: r.value()}
+	case *Any:
+		*m = Any{RequestID: r.string(), Instance: r.int(), ProposalNumber: r.number()}
+	case *FastAccept:
+		*m = FastAccept{RequestID: r.string(), ClientID: r.string(), Instance: r.int(), Value: r.value()}
+	default:
+		return json.Unmarshal(data, v)
+	}
+	if r.err == nil && len(r.data) > 0 {
+		r.err = fmt.Errorf("%d trailing bytes", len(r.data))
+	}
+	return r.err
+}
+
+// Tags of the typed values in binary bodies. Values of other types travel as
+// JSON and decode the way JSON does.
+const (
+	valueNil byte = iota
+	valueString
+	valueInt
+	valueFloat
+	valueBool
+	valueBytes
+	valueJSON
+	valueBatch
+)
+
+func appendValue(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, valueNil), nil
+	case string:
+		return appendString(append(b, valueString), v), nil
+	case int:
+		return binary.AppendVarint(append(b, valueInt), int64(v)), nil
+	case int8:
+		return binary.AppendVarint(append(b, valueInt), int64(v)), nil
+	case int16:
+		return binary.AppendVarint(append(b, valueInt), int64(v)), nil
+	case int32:
+		return binary.AppendVarint(append(b, valueInt), int64(v)), nil
+	case int64:
+		return binary.AppendVarint(append(b, valueInt), v), nil
+	case uint8:
+		return binary.AppendVarint(append(b, valueInt), int64(v)), nil
+	case uint16:
+		return binary.AppendVarint(append(b, valueInt), int64(v)), nil
+	case uint32:
+		return binary.AppendVarint(append(b, valueInt), int64(v)), nil
+	case uint:
+		if uint64(v) <= math.MaxInt64 {
+			return binary.AppendVarint(append(b, valueInt), int64(v)), nil
+		}
+	case uint64:
+		if v <= math.MaxInt64 {
+			return binary.AppendVarint(append(b, valueInt), int64(v)), nil
+		}
+	case float32:
+		return binary.BigEndian.AppendUint64(append(b, valueFloat), math.Float64bits(float64(v))), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(b, valueFloat), math.Float64bits(v)), nil
+	case bool:
+		if v {
+			return append(b, valueBool, 1), nil
+		}
+		return append(b, valueBool, 0), nil
+	case []byte:
+		return appendBytes(append(b, valueBytes), v), nil
+	case Batch:
+		b = binary.AppendUvarint(append(b, valueBatch), uint64(len(v.Values)))
+		var err error
+		for _, value := range v.Values {
+			if b, err = appendValue(b, value); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return b, err
+	}
+	return appendBytes(append(b, valueJSON), data), nil
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(s))), s...)
+}
+
+func appendBytes(b []byte, data []byte) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(data))), data...)
+}
+
+func appendNumber(b []byte, n ProposalNumber) []byte {
+	return appendString(binary.AppendVarint(b, int64(n.BallotNumber)), n.ProposerID)
+}
+
+// binaryReader reads binary fields until the first error, after which every read
+// returns the zero value and err says what went wrong.
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+func (r *binaryReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.data = nil
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.fail(errors.New("malformed varint"))
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *binaryReader) int() int {
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.fail(errors.New("malformed varint"))
+		return 0
+	}
+	r.data = r.data[n:]
+	return int(v)
+}
+
+func (r *binaryReader) bytes() []byte {
+	n := r.uvarint()
+	if n > uint64(len(r.data)) {
+		r.fail(errors.New("field exceeds message"))
+		return nil
+	}
+	if n == 0 {
+		return nil
+	}
+	b := r.data[:n:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *binaryReader) string() string {
+	return string(r.bytes())
+}
+
+func (r *binaryReader) number() ProposalNumber {
+	return ProposalNumber{BallotNumber: r.int(), ProposerID: r.string()}
+}
+
+func (r *binaryReader) value() interface{} {
+	if len(r.data) == 0 {
+		r.fail(errors.New("missing value"))
+		return nil
+	}
+	tag := r.data[0]
+	r.data = r.data[1:]
+	switch tag {
+	case valueNil:
+		return nil
+	case valueString:
+		return r.string()
+	case valueInt:
+		v, n := binary.Varint(r.data)
+		if n <= 0 {
+			r.fail(errors.New("malformed varint"))
+			return nil
+		}
+		r.data = r.data[n:]
+		return v
+	case valueFloat:
+		if len(r.data) < 8 {
+			r.fail(errors.New("short float"))
+			return nil
+		}
+		v := math.Float64frombits(binary.BigEndian.Uint64(r.data))
+		r.data = r.data[8:]
+		return v
+	case valueBool:
+		if len(r.data) < 1 {
+			r.fail(errors.New("short bool"))
+			return nil
+		}
+		v := r.data[0] != 0
+		r.data = r.data[1:]
+		return v
+	case valueBytes:
+		return append([]byte{}, r.bytes()...)
+	case valueJSON:
+		var v interface{}
+		if err := json.Unmarshal(r.bytes(), &v); err != nil {
+			r.fail(err)
+		}
+		return v
+	case valueBatch:
+		n := r.uvarint()
+		if n > uint64(len(r.data)) {
+			r.fail(errors.New("batch exceeds message"))
+			return nil
+		}
+		batch := Batch{Values: make([]interface{}, n)}
+		for i := range batch.Values {
+			batch.Values[i] = r.value()
+		}
+		return batch
+	}
+	r.fail(fmt.Errorf("unknown value tag %d", tag))
+	return nil
+}
+
+// wireVersions picks the wire version a server sends with: the highest one that
+// every server it heard from within window supports. Servers that fell silent are
+// forgotten, so one that was upgraded or removed stops holding the cluster back.
+// A server that still gets a version it cannot decode drops the message, which
+// the protocol tolerates like any lost message.
+type wireVersions struct {
+	mu     sync.Mutex
+	max    int
+	window time.Duration
+	peers  map[string]peerVersion
+}
+
+type peerVersion struct {
+	max  int
+	seen time.Time
+}
+
+func newWireVersions(max int, window time.Duration) *wireVersions {
+	return &wireVersions{max: max, window: window, peers: make(map[string]peerVersion)}
+}
+
+// observe records the highest version the sender of message supports. Senders
+// that do not announce one predate versioning and only speak JSON.
+func (w *wireVersions) observe(message QueueMessage, now time.Time) {
+	if message.Sender == "" {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.peers[message.Sender] = peerVersion{max: max(message.MaxVersion, JSONWireVersion), seen: now}
+}
+
+// current returns the version to send with. Until anybody was heard from it is
+// JSON, the one version every server speaks.
+func (w *wireVersions) current(now time.Time) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	version := 0
+	for id, peer := range w.peers {
+		if now.Sub(peer.seen) > w.window {
+			delete(w.peers, id)
+			continue
+		}
+		if version == 0 || peer.max < version {
+			version = peer.max
+		}
+	}
+	if version == 0 {
+		return JSONWireVersion
+	}
+	return min(version, w.max)
+}
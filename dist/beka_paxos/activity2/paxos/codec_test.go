@@ -0,0 +1,146 @@
+package paxos
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBinaryCodecKeepsValueTypes(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  interface{}
+	}{
+		{nil, nil},
+		{"text", "text"},
+		{42, int64(42)},
+		{int64(-7), int64(-7)},
+		{uint32(7), int64(7)},
+		{1.5, 1.5},
+		{2.0, 2.0},
+		{true, true},
+		{[]byte{0, 1, 0xff}, []byte{0, 1, 0xff}},
+		{[]byte{}, []byte{}},
+		{Batch{Values: []interface{}{"a", 1}}, Batch{Values: []interface{}{"a", int64(1)}}},
+		// Other types go through JSON, so numbers nested in them become float64.
+		{map[string]interface{}{"a": map[string]interface{}{"b": "c"}, "n": 1},
+			map[string]interface{}{"a": map[string]interface{}{"b": "c"}, "n": 1.0}},
+		{[]interface{}{"x", 2}, []interface{}{"x", 2.0}},
+	}
+	for _, tt := range tests {
+		body, err := binaryCodec{}.EncodeBody(Accept{RequestID: "r", Instance: 3, Value: tt.value})
+		if err != nil {
+			t.Fatalf("encode %#v: %s", tt.value, err)
+		}
+		var accept Accept
+		if err := (binaryCodec{}).DecodeBody(body, &accept); err != nil {
+			t.Fatalf("decode %#v: %s", tt.value, err)
+		}
+		if !reflect.DeepEqual(accept.Value, tt.want) || accept.Instance != 3 {
+			t.Errorf("%#v decoded as %#v, want %#v", tt.value, accept.Value, tt.want)
+		}
+	}
+}
+
+func TestJSONCodecTurnsIntegersIntoFloats(t *testing.T) {
+	body, err := jsonCodec{}.EncodeBody(Accept{Value: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var accept Accept
+	if err := (jsonCodec{}).DecodeBody(body, &accept); err != nil {
+		t.Fatal(err)
+	}
+	if accept.Value != 42.0 {
+		t.Fatalf("decoded %#v, want float64 42", accept.Value)
+	}
+}
+
+func TestEncodeMessageRoundTrips(t *testing.T) {
+	for _, version := range []int{JSONWireVersion, BinaryWireVersion} {
+		message := QueueMessage{
+			Type:       acceptMessageType,
+			Body:       []byte(`{"instance":1}`),
+			Sender:     "node1",
+			Clock:      9,
+			Signature:  []byte{1, 2, 3},
+			Sequence:   1 << 62,
+			Version:    version,
+			MaxVersion: LatestWireVersion,
+		}
+		data, err := EncodeMessage(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodeMessage(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, message) {
+			t.Errorf("version %d: decoded %+v, want %+v", version, decoded, message)
+		}
+	}
+}
+
+func TestDecodeMessageWithoutSequence(t *testing.T) {
+	message := QueueMessage{Type: heartbeatMessageType, Body: []byte{1}, Sender: "node1", Version: BinaryWireVersion, MaxVersion: BinaryWireVersion}
+	data, err := EncodeMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A server that predates sequence numbers ends the frame after the signature,
+	// where the zero sequence number takes one byte.
+	decoded, err := DecodeMessage(bytes.TrimSuffix(data, []byte{0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, message) {
+		t.Fatalf("decoded %+v, want %+v", decoded, message)
+	}
+}
+
+func TestMixedWireVersionCluster(t *testing.T) {
+	keys := map[string][]byte{"node1": []byte("key1"), "node2": []byte("key2"), "node3": []byte("key3")}
+	cfgs := make([]Config, 3)
+	for i := range cfgs {
+		auth, err := NewHMACAuthenticator(fmt.Sprintf("node%d", i+1), keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfgs[i].Authenticator = auth
+	}
+	// node1 predates the binary wire format, so everybody has to speak JSON to it.
+	cfgs[0].WireVersion = JSONWireVersion
+	servers := startServers(t, cfgs)
+	leader := waitForLeader(t, servers, "first")
+
+	values := []interface{}{"text", 7.5, true}
+	for _, value := range values {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := leader.Propose(ctx, value)
+		cancel()
+		if err != nil {
+			t.Fatalf("propose %v: %s", value, err)
+		}
+	}
+
+	want := leader.Log().Entries(1)
+	deadline := time.Now().Add(5 * time.Second)
+	for _, server := range servers {
+		for !reflect.DeepEqual(server.Log().Entries(1), want) {
+			if time.Now().After(deadline) {
+				t.Fatalf("log of %s disagrees with the leader's:\n got %v\nwant %v", server.cfg.ID, server.Log().Entries(1), want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		var metrics strings.Builder
+		server.metrics.WriteTo(&metrics)
+		if strings.Contains(metrics.String(), "paxos_messages_rejected_total{") {
+			t.Fatalf("%s rejected messages:\n%s", server.cfg.ID, metrics.String())
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package paxos
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+)
+
+// Config holds the settings of a single Paxos server.
+type Config struct {
+	ID string
+	// Members are the IDs of the initial acceptors. Without them the cluster counts
+	// the votes of any NumberOfAccepters acceptors until members are configured.
+	Members           []string
+	NumberOfAccepters int
+	// Quorum is the quorum system of the initial configuration, majorities when
+	// zero. Reconfigurations keep it unless they replace it.
+	Quorum QuorumSpec
+	// ReconfigWindow is the number of instances after which a decided
+	// reconfiguration takes effect.
+	ReconfigWindow int
+	// DataDir holds the acceptor write-ahead log and the latest snapshot.
+	DataDir string
+	// TraceFile, if set, is the file protocol trace events are appended to as JSON
+	// lines.
+	TraceFile string
+	// Authenticator, if set, signs the messages the server sends and drops received
+	// ones it cannot verify.
+	Authenticator Authenticator
+	// WireVersion is the highest wire version the server speaks. Lowering it to 1
+	// keeps the server on JSON, for example while a cluster is upgraded.
+	WireVersion int
+	// Values proposed while earlier log entries are in flight are batched into one
+	// entry of up to BatchSize values; 1 turns batching off. A batch is proposed
+	// once a value waited FlushInterval in it and fewer than PipelineDepth entries
+	// are in flight. PipelineDepth defaults to ReconfigWindow, which bounds the
+	// entries in flight anyway.
+	BatchSize     int
+	FlushInterval time.Duration
+	PipelineDepth int
+	// FastRounds lets every server send proposed values straight to the acceptors
+	// in a fast ballot the leader keeps open, so an uncontended value is chosen in
+	// one round trip. Values that collide are recovered by the leader in a classic
+	// round. Each server batches the values it sends, and reads go through the log
+	// instead of the leader lease because the leader may learn a fast decision last.
+	// Fast rounds save a message delay, not throughput: a fast quorum waits for more
+	// acceptors to write their log, all of them with three servers.
+	FastRounds bool
+	// ListenAddr is the address the HTTP API listens on. Address must reach it.
+	ListenAddr string
+	// ProposeTimeout bounds how long an HTTP request waits for its proposal to be
+	// decided.
+	ProposeTimeout time.Duration
+	// ShutdownTimeout bounds how long a shutdown waits for requests and proposals
+	// in flight.
+	ShutdownTimeout time.Duration
+	// Address is the host:port other servers reach this server's HTTP API on when
+	// they forward requests to it as the leader.
+	Address string
+
+	// HeartbeatInterval is how often the leader renews its lease. ElectionTimeout,
+	// jittered up to twice its value, is how long a server waits without hearing
+	// from a leader before it campaigns. LeaseDuration must stay below
+	// ElectionTimeout so a lease runs out before anybody else can get elected.
+	HeartbeatInterval time.Duration
+	ElectionTimeout   time.Duration
+	LeaseDuration     time.Duration
+
+	// PhaseTimeout bounds each prepare or accept round. A round that misses it is
+	// resent up to MaxRetries times in all, after a jittered backoff that starts at
+	// RetryBackoff and doubles up to MaxRetryBackoff.
+	PhaseTimeout    time.Duration
+	MaxRetries      int
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+
+	// A snapshot of the state machine is taken every SnapshotInterval once
+	// SnapshotThreshold entries were applied since the last one. The log keeps
+	// SnapshotRetain entries before the snapshot for lagging servers.
+	SnapshotThreshold int
+	SnapshotInterval  time.Duration
+	SnapshotRetain    int
+}
+
+func (c Config) withDefaults() Config {
+	if c.DataDir == "" {
+		c.DataDir = "data"
+	}
+	if c.ListenAddr == "" {
+		c.ListenAddr = ":8080"
+	}
+	if c.ProposeTimeout == 0 {
+		c.ProposeTimeout = time.Second
+	}
+	if c.WireVersion == 0 {
+		c.WireVersion = LatestWireVersion
+	}
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = 5 * time.Second
+	}
+	if c.ReconfigWindow == 0 {
+		c.ReconfigWindow = 8
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = 64
+	}
+	if c.PipelineDepth == 0 {
+		c.PipelineDepth = c.ReconfigWindow
+	}
+	if c.HeartbeatInterval == 0 {
+		c.HeartbeatInterval = 100 * time.Millisecond
+	}
+	if c.ElectionTimeout == 0 {
+		c.ElectionTimeout = time.Second
+	}
+	if c.LeaseDuration == 0 {
+		c.LeaseDuration = c.ElectionTimeout / 2
+	}
+	if c.PhaseTimeout == 0 {
+		c.PhaseTimeout = 300 * time.Millisecond
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = 3
+	}
+	if c.RetryBackoff == 0 {
+		c.RetryBackoff = 10 * time.Millisecond
+	}
+	if c.MaxRetryBackoff == 0 {
+		c.MaxRetryBackoff = 200 * time.Millisecond
+	}
+	if c.SnapshotThreshold == 0 {
+		c.SnapshotThreshold = 1000
+	}
+	if c.SnapshotInterval == 0 {
+		c.SnapshotInterval = 10 * time.Second
+	}
+	if c.SnapshotRetain == 0 {
+		c.SnapshotRetain = 100
+	}
+	return c
+}
+
+// Validate reports every setting of c that a server cannot run with, after unset
+// settings took their defaults.
+func (c Config) Validate() error {
+	c = c.withDefaults()
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.ID != "", "server ID is not set")
+	check(c.NumberOfAccepters > 0 || len(c.Members) > 0, "neither the number of acceptors nor the members are set")
+	check(c.NumberOfAccepters >= 0, "number of acceptors %d is negative", c.NumberOfAccepters)
+	check(len(c.Members) == 0 || c.NumberOfAccepters == 0 || len(c.Members) == c.NumberOfAccepters,
+		"%d members are listed but the number of acceptors is %d", len(c.Members), c.NumberOfAccepters)
+	check(!slices.Contains(c.Members, ""), "members contain an empty ID")
+	if err := c.Quorum.Validate(c.initialConfiguration()); err != nil {
+		check(false, "invalid quorum: %s", err)
+	}
+	check(!c.FastRounds || c.initialConfiguration().FastCapable(),
+		"fast rounds need majority or flexible quorums, not %s quorums", c.Quorum.Kind)
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		check(false, "invalid listen address %q: %s", c.ListenAddr, err)
+	}
+	if c.Address != "" {
+		if _, _, err := net.SplitHostPort(c.Address); err != nil {
+			check(false, "invalid address %q: %s", c.Address, err)
+		}
+	}
+	check(c.WireVersion >= JSONWireVersion && c.WireVersion <= LatestWireVersion,
+		"wire version %d is not between %d and %d", c.WireVersion, JSONWireVersion, LatestWireVersion)
+	check(c.ReconfigWindow > 0, "reconfiguration window %d is not positive", c.ReconfigWindow)
+	check(c.BatchSize > 0, "batch size %d is not positive", c.BatchSize)
+	check(c.FlushInterval >= 0, "flush interval %s is negative", c.FlushInterval)
+	check(c.PipelineDepth > 0, "pipeline depth %d is not positive", c.PipelineDepth)
+	check(c.MaxRetries > 0, "max retries %d is not positive", c.MaxRetries)
+	check(c.SnapshotThreshold > 0, "snapshot threshold %d is not positive", c.SnapshotThreshold)
+	check(c.SnapshotRetain >= 0, "snapshot retain %d is negative", c.SnapshotRetain)
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"propose timeout", c.ProposeTimeout},
+		{"shutdown timeout", c.ShutdownTimeout},
+		{"heartbeat interval", c.HeartbeatInterval},
+		{"election timeout", c.ElectionTimeout},
+		{"lease duration", c.LeaseDuration},
+		{"phase timeout", c.PhaseTimeout},
+		{"retry backoff", c.RetryBackoff},
+		{"max retry backoff", c.MaxRetryBackoff},
+		{"snapshot interval", c.SnapshotInterval},
+	} {
+		check(d.value > 0, "%s %s is not positive", d.name, d.value)
+	}
+	check(c.LeaseDuration < c.ElectionTimeout, "lease duration %s is not below the election timeout %s",
+		c.LeaseDuration, c.ElectionTimeout)
+	check(c.HeartbeatInterval < c.LeaseDuration, "heartbeat interval %s is not below the lease duration %s",
+		c.HeartbeatInterval, c.LeaseDuration)
+	check(c.RetryBackoff <= c.MaxRetryBackoff, "retry backoff %s exceeds the max retry backoff %s",
+		c.RetryBackoff, c.MaxRetryBackoff)
+	return errors.Join(errs...)
+}
+
+func (c Config) retryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:   c.MaxRetries,
+		PhaseTimeout: c.PhaseTimeout,
+		Backoff:      c.RetryBackoff,
+		MaxBackoff:   c.MaxRetryBackoff,
+	}
+}
+
+func (c Config) initialConfiguration() Configuration {
+	var config Configuration
+	if len(c.Members) > 0 {
+		config = Configuration{Members: c.Members}
+	} else {
+		config = Configuration{Size: c.NumberOfAccepters}
+	}
+	if !c.Quorum.IsZero() {
+		quorum := c.Quorum
+		config.Quorum = &quorum
+	}
+	return config
+}
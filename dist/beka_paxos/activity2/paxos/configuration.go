@@ -0,0 +1,21 @@
+package paxos
+
+import "github.com/beka-birhanu/paxos-lab-activity2/core"
+
+// Configurations and their quorum systems are those of the core state machines.
+type (
+	Configuration = core.Configuration
+	QuorumSpec    = core.QuorumSpec
+	QuorumSystem  = core.QuorumSystem
+	Phase         = core.Phase
+)
+
+const (
+	MajorityQuorum = core.MajorityQuorum
+	FlexibleQuorum = core.FlexibleQuorum
+	GridQuorum     = core.GridQuorum
+	WeightedQuorum = core.WeightedQuorum
+
+	Phase1 = core.Phase1
+	Phase2 = core.Phase2
+)
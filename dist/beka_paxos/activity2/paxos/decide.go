@@ -0,0 +1,102 @@
+package paxos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const decideMessageType = "DECIDE"
+
+// Decide tells every server the value chosen for an instance.
+type Decide struct {
+	Instance int         `json:"instance"`
+	Value    interface{} `json:"value"`
+}
+
+var catchUpClient = &http.Client{Timeout: time.Second}
+
+// commit records a decision locally and announces it to the other servers.
+func (s *Server) commit(instance int, value interface{}) {
+	s.log.Commit(instance, value)
+	s.tracer.decide(instance, value)
+	s.broadcast(decideMessageType, Decide{Instance: instance, Value: value}, s.transport.BroadcastToAcceptors)
+}
+
+func (s *Server) onDecide(decide Decide) {
+	s.log.Commit(decide.Instance, decide.Value)
+}
+
+// catchUp fetches the entries this server missed from the leader, which announces
+// its last instance in every heartbeat. At most one catch-up runs at a time.
+func (s *Server) catchUp(hb Heartbeat) {
+	if hb.LeaderID == s.cfg.ID || hb.LastInstance < s.log.NextInstance() {
+		return
+	}
+	if !s.catchingUp.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer s.catchingUp.Store(false)
+		entries, err := fetchLog(hb.Address, s.log.NextInstance())
+		if errors.Is(err, errLogCompacted) {
+			entries, err = s.catchUpSnapshot(hb)
+		}
+		if err != nil {
+			log.Printf("Error: Failed to catch up from leader %s: %s", hb.LeaderID, err)
+			return
+		}
+		for _, entry := range entries {
+			s.log.Commit(entry.Instance, entry.Value)
+		}
+		log.Printf("Caught up %d entries from leader %s", len(entries), hb.LeaderID)
+	}()
+}
+
+// catchUpSnapshot installs the leader's snapshot when the entries this server is
+// missing were compacted, and fetches the log entries after it.
+func (s *Server) catchUpSnapshot(hb Heartbeat) ([]LogEntry, error) {
+	snap, err := fetchSnapshot(hb.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch snapshot: %w", err)
+	}
+	if err := s.saveSnapshot(snap); err != nil {
+		log.Printf("Error: Failed to save snapshot: %s", err)
+	}
+	s.installSnapshot(snap)
+	log.Printf("Installed snapshot up to instance %d from leader %s", snap.Index, hb.LeaderID)
+	return fetchLog(hb.Address, snap.Index+1)
+}
+
+func fetchLog(address string, from int) ([]LogEntry, error) {
+	u := url.URL{Scheme: "http", Host: address, Path: "/log", RawQuery: "from=" + strconv.Itoa(from)}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(forwardedHeader, "catch-up")
+
+	resp, err := catchUpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return nil, errLogCompacted
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var entries []LogEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
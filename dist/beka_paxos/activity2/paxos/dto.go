@@ -2,7 +2,21 @@ package paxos
 
 import "encoding/json"
 
+// QueueMessage is what servers exchange over the transport. Sender is the ID of the
+// server that sent it and Clock its Lamport clock when tracing is enabled. Signature
+// covers all other fields when servers authenticate their messages, and Sequence
+// then numbers the messages of the sender so replays can be told apart.
+//
+// Version is the wire version the message and its Body are encoded in; it follows
+// from the encoding, so JSON messages do not carry it. MaxVersion is the highest
+// version the sender supports.
 type QueueMessage struct {
-	Type string          `json:"Type"`
-	Body json.RawMessage `json:"Body"`
+	Type       string          `json:"Type"`
+	Body       json.RawMessage `json:"Body"`
+	Sender     string          `json:"Sender,omitempty"`
+	Clock      uint64          `json:"Clock,omitempty"`
+	Signature  []byte          `json:"Signature,omitempty"`
+	Sequence   uint64          `json:"Sequence,omitempty"`
+	Version    int             `json:"-"`
+	MaxVersion int             `json:"MaxVersion,omitempty"`
 }
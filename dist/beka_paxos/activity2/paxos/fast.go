@@ -0,0 +1,225 @@
+package paxos
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/core"
+)
+
+const (
+	anyMessageType          = "ANY"
+	fastAcceptMessageType   = "FAST_ACCEPT"
+	fastAcceptedMessageType = "FAST_ACCEPTED"
+)
+
+// fastRounds is the coordinator's view of the fast ballot: the ballot it opened and
+// the instances clients proposed in, so it can recover the ones that stall.
+type fastRounds struct {
+	mu      sync.Mutex
+	open    Any
+	floor   int
+	pending map[int]time.Time
+}
+
+// note records that a client proposed for instance.
+func (f *fastRounds) note(instance int, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.pending[instance]; !ok {
+		f.pending[instance] = now
+	}
+}
+
+// due returns the highest instance clients proposed for and whether one of them
+// has been pending longer than timeout.
+func (f *fastRounds) due(now time.Time, timeout time.Duration, decided func(int) bool) (int, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	highest, stale := 0, false
+	for instance, since := range f.pending {
+		if decided(instance) {
+			delete(f.pending, instance)
+			continue
+		}
+		highest = max(highest, instance)
+		stale = stale || now.Sub(since) > timeout
+	}
+	return highest, stale
+}
+
+// proposeFast sends value straight to the acceptors for the next free instance and
+// waits for it to be decided there. When another client's value wins the instance,
+// it moves on to the next one. An instance whose fast ballot collided or stalled is
+// recovered by the coordinator.
+func (s *Server) proposeFast(ctx context.Context, value interface{}) (position, error) {
+	s.proposeMu.RLock()
+	defer s.proposeMu.RUnlock()
+	if s.closing.Load() {
+		return position{}, ErrServerClosed
+	}
+	start := time.Now()
+	result := "error"
+	defer func() {
+		s.metrics.proposed(result, time.Since(start))
+	}()
+
+	for {
+		instance := s.reserveInstance()
+		if _, err := s.configFor(ctx, instance); err != nil {
+			s.releaseInstance(instance)
+			return position{}, err
+		}
+		s.broadcast(fastAcceptMessageType, FastAccept{
+			RequestID: s.proposer.newRequestID(),
+			ClientID:  s.cfg.ID,
+			Instance:  instance,
+			Value:     value,
+		}, s.transport.BroadcastToAcceptors)
+
+		chosen, err := s.awaitDecision(ctx, instance)
+		if err != nil {
+			s.releaseInstance(instance)
+			result = "no_consensus"
+			return position{}, contextError(err)
+		}
+		if core.SameValue(chosen, value) {
+			result = "decided"
+			return position{instance: instance}, nil
+		}
+		s.metrics.instanceRetried("fast")
+		log.Printf("Instance %d already decided with %v, retrying on another instance.", instance, chosen)
+	}
+}
+
+// awaitDecision blocks until this server learned the value of instance.
+func (s *Server) awaitDecision(ctx context.Context, instance int) (interface{}, error) {
+	for {
+		changed := s.log.Changed()
+		if value, ok := s.log.Get(instance); ok {
+			return value, nil
+		}
+		if instance < s.log.FirstInstance() {
+			return nil, fmt.Errorf("instance %d was compacted", instance)
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// runFastRounds coordinates fast ballots while this server leads: it keeps a fast
+// ballot open and recovers the instances whose fast ballot collided or stalled
+// with a classic round.
+func (s *Server) runFastRounds(ctx context.Context) {
+	if !s.cfg.FastRounds {
+		return
+	}
+	ticker := time.NewTicker(s.cfg.PhaseTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		collided := false
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case instance := <-s.learner.collisions:
+			log.Printf("Info: Fast ballot collided at instance %d, recovering.", instance)
+			collided = true
+		}
+		if !s.leadership.isCaughtUp() {
+			s.fast.mu.Lock()
+			s.fast.open = Any{}
+			s.fast.mu.Unlock()
+			continue
+		}
+		s.proposeMu.RLock()
+		if collided {
+			s.recoverFast(ctx, "collision")
+		} else if _, stale := s.fast.due(time.Now(), s.cfg.PhaseTimeout, s.decided); stale {
+			s.recoverFast(ctx, "timeout")
+		}
+		s.openFastBallot(ctx)
+		s.proposeMu.RUnlock()
+	}
+}
+
+func (s *Server) decided(instance int) bool {
+	_, ok := s.log.Get(instance)
+	return ok || instance < s.log.FirstInstance()
+}
+
+// recoverFast decides every undecided instance up to the highest one clients
+// proposed for, or one that promising acceptors accepted a value in, with classic
+// rounds. Each runs phase 1 with a new ballot, which closes the fast ballot, and
+// keeps the value that may have been chosen in it.
+func (s *Server) recoverFast(ctx context.Context, reason string) {
+	s.fast.mu.Lock()
+	s.fast.open = Any{}
+	s.fast.mu.Unlock()
+
+	highest, _ := s.fast.due(time.Now(), s.cfg.PhaseTimeout, s.decided)
+	for instance := s.log.NextInstance(); instance <= highest; instance++ {
+		if s.decided(instance) {
+			continue
+		}
+		config, err := s.membership.configFor(instance)
+		if err != nil {
+			log.Printf("Error: Cannot recover instance %d: %s", instance, err)
+			return
+		}
+		rctx, cancel := context.WithTimeout(ctx, s.cfg.ProposeTimeout)
+		chosen, _, lastAccepted, err := s.proposer.Recover(rctx, instance, NoOp, s.acceptor.GetBallotNumber(), config)
+		cancel()
+
+		s.fast.mu.Lock()
+		s.fast.floor = max(s.fast.floor, instance+1)
+		s.fast.mu.Unlock()
+		if err != nil {
+			log.Printf("Error: Failed to recover instance %d: %s", instance, err)
+			return
+		}
+		s.metrics.fastRecovered(reason)
+		log.Printf("Recovered instance %d with %v", instance, chosen)
+		s.commit(instance, chosen)
+		highest = max(highest, lastAccepted)
+	}
+}
+
+// openFastBallot sends ANY for the ballot the local proposer prepared, running
+// phase 1 first if it has none. The fast ballot starts past every instance that
+// was or may have been accepted in a classic round. ANY is resent on every call so
+// acceptors that missed it or restarted rejoin the fast ballot.
+func (s *Server) openFastBallot(ctx context.Context) {
+	round := s.proposer.preparedRound()
+	if round == nil {
+		instance := s.log.NextInstance()
+		config, err := s.membership.configFor(instance)
+		if err != nil {
+			return
+		}
+		pctx, cancel := context.WithTimeout(ctx, s.cfg.ProposeTimeout)
+		round = s.proposer.prepare(pctx, instance, s.acceptor.GetBallotNumber(), config)
+		cancel()
+		if round == nil {
+			log.Println("Error: Failed to prepare a fast ballot.")
+			return
+		}
+	}
+
+	s.fast.mu.Lock()
+	from := max(round.Instance, round.LastAccepted()+1, s.log.LastInstance()+1, s.fast.floor)
+	if s.fast.open.ProposalNumber != round.Number {
+		log.Printf("Opening fast ballot %+v from instance %d", round.Number, from)
+		s.fast.open = Any{RequestID: s.proposer.newRequestID(), Instance: from, ProposalNumber: round.Number}
+	}
+	open := s.fast.open
+	s.fast.mu.Unlock()
+	s.broadcast(anyMessageType, open, s.transport.BroadcastToAcceptors)
+}
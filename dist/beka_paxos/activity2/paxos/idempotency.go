@@ -0,0 +1,100 @@
+package paxos
+
+import (
+	"context"
+	"sync"
+)
+
+// IdempotencyHeader carries the key a client sends with a proposal and reuses when
+// it retries it, so a retry returns the instance the first attempt was decided in
+// instead of proposing the value again.
+const IdempotencyHeader = "Idempotency-Key"
+
+// idempotencyKey is the JSON key that tells keyed values apart in the log.
+const idempotencyKey = "paxos:idempotency_key"
+
+// KeyedValue is a value proposed with an idempotency key. The key goes through the
+// log, so every server, and every later leader, learns which keys were decided.
+type KeyedValue struct {
+	Key   string      `json:"paxos:idempotency_key"`
+	Value interface{} `json:"value"`
+}
+
+// keyOf returns the idempotency key of a log value, if it is a KeyedValue. Keyed
+// values that went through JSON arrive as generic maps.
+func keyOf(value interface{}) (string, bool) {
+	switch value := value.(type) {
+	case KeyedValue:
+		return value.Key, true
+	case map[string]interface{}:
+		key, ok := value[idempotencyKey].(string)
+		_, hasValue := value["value"]
+		return key, ok && hasValue && len(value) == 2
+	}
+	return "", false
+}
+
+// Unkeyed returns the value a client proposed: that of a keyed value, or value itself.
+func Unkeyed(value interface{}) interface{} {
+	switch keyed := value.(type) {
+	case KeyedValue:
+		return keyed.Value
+	case map[string]interface{}:
+		if _, ok := keyOf(keyed); ok {
+			return keyed["value"]
+		}
+	}
+	return value
+}
+
+// keyedCalls joins the proposals of one key in flight on this server, so a client
+// retrying before its first attempt was decided waits for that attempt.
+type keyedCalls struct {
+	mu    sync.Mutex
+	calls map[string]*keyedCall
+}
+
+type keyedCall struct {
+	done chan struct{}
+	pos  position
+	err  error
+}
+
+// proposeOnce gets value chosen with idempotency key key and returns where it was
+// decided, unless a proposal with key was decided recently; then it returns where
+// that proposal was.
+func (s *Server) proposeOnce(ctx context.Context, key string, value interface{}) (position, error) {
+	s.keyed.mu.Lock()
+	if call, ok := s.keyed.calls[key]; ok {
+		s.keyed.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.pos, call.err
+		case <-ctx.Done():
+			return position{}, ctx.Err()
+		}
+	}
+	call := &keyedCall{done: make(chan struct{})}
+	s.keyed.calls[key] = call
+	s.keyed.mu.Unlock()
+
+	defer func() {
+		s.keyed.mu.Lock()
+		delete(s.keyed.calls, key)
+		s.keyed.mu.Unlock()
+		close(call.done)
+	}()
+
+	if pos, ok := s.kv.decidedKey(key); ok {
+		call.pos = pos
+		return call.pos, nil
+	}
+	pos, err := s.propose(ctx, KeyedValue{Key: key, Value: value})
+	if err == nil {
+		// Later calls look the key up in the store, so it has to be applied there
+		// before this call stops answering for it.
+		_, err = s.kv.WaitApplied(ctx, pos.instance, pos.index)
+	}
+	call.pos, call.err = pos, err
+	return call.pos, call.err
+}
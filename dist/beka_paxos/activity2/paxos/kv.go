@@ -0,0 +1,416 @@
+package paxos
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	PutOp    = "PUT"
+	GetOp    = "GET"
+	DeleteOp = "DELETE"
+	CASOp    = "CAS"
+
+	// kvResultWindow is how many instances back the results of applied commands are
+	// kept for the requests waiting on them, and their IDs for deduplication.
+	kvResultWindow = 1024
+)
+
+// Command is a key-value operation agreed through the log. ID makes every command
+// unique, so two clients writing the same key and value are still told apart, and
+// a command decided twice within kvResultWindow instances is only executed once.
+type Command struct {
+	ID       string      `json:"id"`
+	Op       string      `json:"op"`
+	Key      string      `json:"key"`
+	Value    string      `json:"value,omitempty"`
+	Expected *string     `json:"expected,omitempty"`
+	Members  []string    `json:"members,omitempty"`
+	Quorum   *QuorumSpec `json:"quorum,omitempty"`
+}
+
+type CommandResult struct {
+	Value   string `json:"value"`
+	Found   bool   `json:"found"`
+	Applied bool   `json:"applied"`
+}
+
+// appliedCommand is a command executed within the last kvResultWindow instances.
+type appliedCommand struct {
+	ID       string        `json:"id"`
+	Instance int           `json:"instance"`
+	Result   CommandResult `json:"result"`
+}
+
+// KVStore is the state machine every server builds by applying the log in order.
+// It also remembers where the keyed values of recent instances were decided and
+// which commands recent instances executed.
+type KVStore struct {
+	mu         sync.Mutex
+	data       map[string]string
+	applied    int
+	results    map[int][]CommandResult
+	keys       map[string]position
+	keysAt     map[int][]string
+	commands   map[string]appliedCommand
+	commandsAt map[int][]string
+	changed    chan struct{}
+}
+
+func NewKVStore() *KVStore {
+	return &KVStore{
+		data:       make(map[string]string),
+		results:    make(map[int][]CommandResult),
+		keys:       make(map[string]position),
+		keysAt:     make(map[int][]string),
+		commands:   make(map[string]appliedCommand),
+		commandsAt: make(map[int][]string),
+		changed:    make(chan struct{}),
+	}
+}
+
+func newCommand(op, key string) Command {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return Command{ID: hex.EncodeToString(id), Op: op, Key: key}
+}
+
+// decodeCommand turns a log value back into a Command. Values that went through
+// the wire arrive as generic JSON maps, and values that are no command at all,
+// like NoOp, report false.
+func decodeCommand(value interface{}) (Command, bool) {
+	if command, ok := value.(Command); ok {
+		return command, true
+	}
+	if _, ok := value.(map[string]interface{}); !ok {
+		return Command{}, false
+	}
+
+	body, err := json.Marshal(value)
+	if err != nil {
+		return Command{}, false
+	}
+	var command Command
+	if err := json.Unmarshal(body, &command); err != nil || command.Op == "" {
+		return Command{}, false
+	}
+	return command, true
+}
+
+// Apply executes the value decided for instance, which must directly follow the
+// last applied instance. The values of a batch are executed in order.
+func (kv *KVStore) Apply(instance int, value interface{}) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if instance != kv.applied+1 {
+		return
+	}
+	values := batchValues(value)
+	results := make([]CommandResult, len(values))
+	for i, value := range values {
+		if command, ok := decodeCommand(value); ok {
+			results[i] = kv.apply(instance, command)
+		}
+		if key, ok := keyOf(value); ok {
+			kv.keys[key] = position{instance: instance, index: i}
+			kv.keysAt[instance] = append(kv.keysAt[instance], key)
+		}
+	}
+	kv.results[instance] = results
+	delete(kv.results, instance-kvResultWindow)
+	for _, key := range kv.keysAt[instance-kvResultWindow] {
+		delete(kv.keys, key)
+	}
+	delete(kv.keysAt, instance-kvResultWindow)
+	for _, id := range kv.commandsAt[instance-kvResultWindow] {
+		delete(kv.commands, id)
+	}
+	delete(kv.commandsAt, instance-kvResultWindow)
+	kv.applied = instance
+	close(kv.changed)
+	kv.changed = make(chan struct{})
+}
+
+// apply executes command decided at instance, unless a command with the same ID was
+// already executed within the last kvResultWindow instances, whose result it
+// returns instead.
+func (kv *KVStore) apply(instance int, command Command) CommandResult {
+	if command.ID == "" {
+		return kv.execute(command)
+	}
+	if applied, ok := kv.commands[command.ID]; ok {
+		return applied.Result
+	}
+	result := kv.execute(command)
+	kv.commands[command.ID] = appliedCommand{ID: command.ID, Instance: instance, Result: result}
+	kv.commandsAt[instance] = append(kv.commandsAt[instance], command.ID)
+	return result
+}
+
+func (kv *KVStore) execute(command Command) CommandResult {
+	current, found := kv.data[command.Key]
+	switch command.Op {
+	case PutOp:
+		kv.data[command.Key] = command.Value
+		return CommandResult{Value: command.Value, Found: true, Applied: true}
+	case DeleteOp:
+		delete(kv.data, command.Key)
+		return CommandResult{Value: current, Found: found, Applied: true}
+	case CASOp:
+		if command.Expected == nil && found || command.Expected != nil && (!found || current != *command.Expected) {
+			return CommandResult{Value: current, Found: found, Applied: false}
+		}
+		kv.data[command.Key] = command.Value
+		return CommandResult{Value: command.Value, Found: true, Applied: true}
+	case GetOp:
+		return CommandResult{Value: current, Found: found, Applied: true}
+	case ReconfigureOp:
+		return CommandResult{Applied: true}
+	default:
+		log.Printf("Info: Unknown command %s at key %s", command.Op, command.Key)
+		return CommandResult{}
+	}
+}
+
+// WaitApplied blocks until instance has been applied and returns the result of the
+// command at index in the batch decided there.
+func (kv *KVStore) WaitApplied(ctx context.Context, instance, index int) (CommandResult, error) {
+	for {
+		kv.mu.Lock()
+		if kv.applied >= instance {
+			var result CommandResult
+			if results := kv.results[instance]; index < len(results) {
+				result = results[index]
+			}
+			kv.mu.Unlock()
+			return result, nil
+		}
+		changed := kv.changed
+		kv.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return CommandResult{}, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// decidedKey returns where the keyed value with idempotency key key was decided,
+// if that was within the last kvResultWindow applied instances.
+func (kv *KVStore) decidedKey(key string) (position, bool) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	pos, ok := kv.keys[key]
+	return pos, ok
+}
+
+func (kv *KVStore) Get(key string) (string, bool) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	value, ok := kv.data[key]
+	return value, ok
+}
+
+// Snapshot returns a copy of the data, the last instance applied to it and the
+// commands executed within the last kvResultWindow instances.
+func (kv *KVStore) Snapshot() (int, map[string]string, []appliedCommand) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	commands := make([]appliedCommand, 0, len(kv.commands))
+	for _, command := range kv.commands {
+		commands = append(commands, command)
+	}
+	slices.SortFunc(commands, func(a, b appliedCommand) int { return cmp.Compare(a.Instance, b.Instance) })
+	return kv.applied, maps.Clone(kv.data), commands
+}
+
+// Restore replaces the state with a snapshot taken after applying instance.
+// Restoring the executed commands keeps duplicates from executing again, as they
+// would not on the servers that applied the log.
+func (kv *KVStore) Restore(instance int, data map[string]string, commands []appliedCommand) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if instance <= kv.applied {
+		return
+	}
+	kv.data = maps.Clone(data)
+	if kv.data == nil {
+		kv.data = make(map[string]string)
+	}
+	kv.applied = instance
+	clear(kv.results)
+	clear(kv.keys)
+	clear(kv.keysAt)
+	clear(kv.commands)
+	clear(kv.commandsAt)
+	for _, command := range commands {
+		if command.Instance > instance-kvResultWindow && command.Instance <= instance {
+			kv.commands[command.ID] = command
+			kv.commandsAt[command.Instance] = append(kv.commandsAt[command.Instance], command.ID)
+		}
+	}
+	close(kv.changed)
+	kv.changed = make(chan struct{})
+}
+
+func (kv *KVStore) Applied() int {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.applied
+}
+
+// runStateMachine applies decided log entries to the store in instance order.
+func (s *Server) runStateMachine(ctx context.Context) {
+	for {
+		changed := s.log.Changed()
+		for {
+			instance := s.kv.Applied() + 1
+			value, ok := s.log.Get(instance)
+			if !ok {
+				break
+			}
+			s.kv.Apply(instance, value)
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Execute agrees on command through the log and returns its result once the local
+// store applied it. Reconfigurations are decided alone, so the membership finds
+// them as whole log entries.
+func (s *Server) Execute(ctx context.Context, command Command) (CommandResult, error) {
+	var pos position
+	var err error
+	if command.Op == ReconfigureOp {
+		pos, err = s.proposeEntry(ctx, command)
+	} else {
+		pos, err = s.propose(ctx, command)
+	}
+	if err != nil {
+		return CommandResult{}, err
+	}
+	return s.kv.WaitApplied(ctx, pos.instance, pos.index)
+}
+
+// Read returns the current value of key. A leader holding its lease answers from
+// its local store once it applied everything it decided; otherwise the read is
+// ordered through the log like a write.
+func (s *Server) Read(ctx context.Context, key string) (CommandResult, error) {
+	if !s.cfg.FastRounds && s.leadership.hasLease(time.Now()) {
+		if _, err := s.kv.WaitApplied(ctx, s.log.NextInstance()-1, 0); err != nil {
+			return CommandResult{}, err
+		}
+		value, found := s.kv.Get(key)
+		return CommandResult{Value: value, Found: found, Applied: true}, nil
+	}
+	return s.Execute(ctx, newCommand(GetOp, key))
+}
+
+func (s *Server) kvHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.leadership.isLeader() {
+		s.forwardToLeader(w, r)
+		return
+	}
+
+	key := r.PathValue("key")
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.ProposeTimeout)
+	defer cancel()
+
+	var result CommandResult
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		result, err = s.Read(ctx, key)
+
+	case http.MethodPut:
+		command := newCommand(PutOp, key)
+		body, readErr := io.ReadAll(r.Body)
+		if readErr != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+			return
+		}
+		command.Value = string(body)
+		result, err = s.Execute(ctx, command)
+
+	case http.MethodDelete:
+		result, err = s.Execute(ctx, newCommand(DeleteOp, key))
+
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	s.writeResult(w, r, key, result, err)
+}
+
+func (s *Server) casHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.leadership.isLeader() {
+		s.forwardToLeader(w, r)
+		return
+	}
+
+	var body struct {
+		Expected *string `json:"expected"`
+		Value    string  `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		log.Printf("Error: while decoding CAS request: %s", err)
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+		return
+	}
+
+	key := r.PathValue("key")
+	command := newCommand(CASOp, key)
+	command.Expected = body.Expected
+	command.Value = body.Value
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.ProposeTimeout)
+	defer cancel()
+	result, err := s.Execute(ctx, command)
+	if err == nil && !result.Applied {
+		writeJSON(w, http.StatusConflict, map[string]interface{}{"key": key, "value": result.Value, "found": result.Found, "applied": false})
+		return
+	}
+	s.writeResult(w, r, key, result, err)
+}
+
+func (s *Server) writeResult(w http.ResponseWriter, r *http.Request, key string, result CommandResult, err error) {
+	switch {
+	case errors.Is(err, ErrNotLeader):
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Leadership lost"})
+	case errors.Is(err, ErrServerClosed):
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Server shutting down"})
+	case err != nil:
+		log.Printf("Error: %s %s failed: %s", r.Method, key, err)
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	case r.Method == http.MethodGet && !result.Found:
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"key": key, "found": false})
+	default:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "value": result.Value, "found": result.Found, "applied": result.Applied})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error: while encoding response: %s", err)
+	}
+}
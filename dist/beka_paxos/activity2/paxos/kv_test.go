@@ -0,0 +1,75 @@
+package paxos
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// applyAll applies values to kv at the instances following the last applied one.
+func applyAll(kv *KVStore, values ...interface{}) {
+	for _, value := range values {
+		kv.Apply(kv.Applied()+1, value)
+	}
+}
+
+func resultAt(t *testing.T, kv *KVStore, instance, index int) CommandResult {
+	t.Helper()
+	result, err := kv.WaitApplied(context.Background(), instance, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestKVStoreExecutesDuplicatesOnce(t *testing.T) {
+	cas := Command{ID: "cas", Op: CASOp, Key: "k", Value: "a"}
+	put := Command{ID: "put", Op: PutOp, Key: "k", Value: "b"}
+
+	kv := NewKVStore()
+	// Executed again, the CAS would fail on the key the PUT wrote.
+	applyAll(kv, cas, put, Batch{Values: []interface{}{cas, put}})
+
+	if value, _ := kv.Get("k"); value != "b" {
+		t.Fatalf("k = %q, want b", value)
+	}
+	if result := resultAt(t, kv, 3, 0); !result.Applied || result.Value != "a" {
+		t.Fatalf("duplicate CAS returned %+v, want the result of the first", result)
+	}
+	if result := resultAt(t, kv, 3, 1); !result.Applied || result.Value != "b" {
+		t.Fatalf("duplicate PUT returned %+v, want the result of the first", result)
+	}
+}
+
+func TestKVStoreForgetsCommandsOutsideWindow(t *testing.T) {
+	put := Command{ID: "put", Op: PutOp, Key: "k", Value: "a"}
+	kv := NewKVStore()
+	applyAll(kv, put)
+	for kv.Applied() < kvResultWindow {
+		applyAll(kv, Command{ID: fmt.Sprintf("other-%d", kv.Applied()), Op: PutOp, Key: "k", Value: "b"})
+	}
+
+	applyAll(kv, put)
+	if value, _ := kv.Get("k"); value != "b" {
+		t.Fatalf("k = %q, duplicate within the window executed", value)
+	}
+	applyAll(kv, put)
+	if value, _ := kv.Get("k"); value != "a" {
+		t.Fatalf("k = %q, command outside the window not executed", value)
+	}
+}
+
+func TestKVStoreRestoreKeepsExecutedCommands(t *testing.T) {
+	put := Command{ID: "put", Op: PutOp, Key: "k", Value: "a"}
+	kv := NewKVStore()
+	applyAll(kv, put, Command{ID: "other", Op: PutOp, Key: "k", Value: "b"})
+
+	restored := NewKVStore()
+	restored.Restore(kv.Snapshot())
+	for _, store := range []*KVStore{kv, restored} {
+		applyAll(store, put)
+		if value, _ := store.Get("k"); value != "b" {
+			t.Fatalf("k = %q, duplicate executed", value)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package paxos
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	heartbeatMessageType    = "HEARTBEAT"
+	heartbeatAckMessageType = "HEARTBEAT_ACK"
+
+	// NoOp is proposed by a new leader to learn instances its predecessors may have
+	// decided. It carries no command.
+	NoOp = "paxos:no-op"
+
+	forwardedHeader = "X-Paxos-Forwarded-By"
+)
+
+// Heartbeat is broadcast by the leader. Followers that acknowledge it refuse to
+// promise any other proposer until LeaseDuration after they received it.
+type Heartbeat struct {
+	LeaderID       string         `json:"leader_ID"`
+	Address        string         `json:"address"`
+	ProposalNumber ProposalNumber `json:"proposal_number"`
+	Sequence       int            `json:"sequence"`
+	LastInstance   int            `json:"last_instance"`
+}
+
+type HeartbeatAck struct {
+	FollowerID string `json:"follower_ID"`
+	LeaderID   string `json:"leader_ID"`
+	Sequence   int    `json:"sequence"`
+}
+
+// leadership tracks which server leads the cluster. A server becomes leader by
+// completing phase 1 and keeps a lease for as long as a quorum acknowledges its
+// heartbeats. The lease starts when a heartbeat is sent, before any follower
+// receives it, so it always ends before the followers' grants do, assuming clocks
+// advance at the same rate.
+type leadership struct {
+	mu              sync.Mutex
+	id              string
+	address         string
+	configuration   func() Configuration
+	leaseDuration   time.Duration
+	electionTimeout time.Duration
+	leaderID        string
+	leaderAddress   string
+	ballot          ProposalNumber
+	lastHeartbeat   time.Time
+	leading         bool
+	caughtUp        bool
+	sequence        int
+	sentAt          map[int]time.Time
+	acks            map[int]map[string]bool
+	leaseExpiry     time.Time
+	lastRenewal     time.Time
+}
+
+// newLeadership tracks leadership for a server whose acknowledgement quorum comes
+// from the current configuration.
+func newLeadership(cfg Config, configuration func() Configuration) *leadership {
+	return &leadership{
+		id:              cfg.ID,
+		address:         cfg.Address,
+		configuration:   configuration,
+		leaseDuration:   cfg.LeaseDuration,
+		electionTimeout: cfg.ElectionTimeout,
+		sentAt:          make(map[int]time.Time),
+		acks:            make(map[int]map[string]bool),
+	}
+}
+
+// observeHeartbeat follows hb unless another leader still holds a lease granted by
+// this server with a higher ballot, and reports whether hb should be acknowledged.
+func (l *leadership) observeHeartbeat(hb Heartbeat, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if hb.LeaderID != l.leaderID && l.ballot.GreaterThan(hb.ProposalNumber) &&
+		now.Before(l.lastHeartbeat.Add(l.leaseDuration)) {
+		return false
+	}
+	if l.leading && hb.LeaderID != l.id {
+		log.Printf("Stepping down, %s leads with %+v", hb.LeaderID, hb.ProposalNumber)
+		l.leading = false
+	}
+
+	l.leaderID = hb.LeaderID
+	l.leaderAddress = hb.Address
+	l.ballot = hb.ProposalNumber
+	l.lastHeartbeat = now
+	return true
+}
+
+func (l *leadership) observeAck(ack HeartbeatAck, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sentAt, ok := l.sentAt[ack.Sequence]
+	if !l.leading || ack.LeaderID != l.id || !ok {
+		return
+	}
+	config := l.configuration()
+	if !config.Counts(ack.FollowerID) {
+		return
+	}
+	if l.acks[ack.Sequence] == nil {
+		l.acks[ack.Sequence] = make(map[string]bool)
+	}
+	l.acks[ack.Sequence][ack.FollowerID] = true
+	// Another leader needs a phase-1 quorum, which meets every phase-2 quorum.
+	if !config.IsQuorum(Phase2, l.acks[ack.Sequence]) {
+		return
+	}
+
+	if expiry := sentAt.Add(l.leaseDuration); expiry.After(l.leaseExpiry) {
+		l.leaseExpiry = expiry
+		l.lastRenewal = now
+	}
+}
+
+// allowPrepare reports whether the local acceptor may promise proposerID, which is
+// not the case while this server grants a lease to another leader.
+func (l *leadership) allowPrepare(proposerID string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return proposerID == l.leaderID || !now.Before(l.lastHeartbeat.Add(l.leaseDuration))
+}
+
+func (l *leadership) becomeLeader(ballot ProposalNumber, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.leading = true
+	l.caughtUp = false
+	l.leaderID = l.id
+	l.leaderAddress = l.address
+	l.ballot = ballot
+	l.lastHeartbeat = now
+	l.lastRenewal = now
+	l.leaseExpiry = time.Time{}
+	clear(l.sentAt)
+	clear(l.acks)
+}
+
+// setBallot makes heartbeats advertise ballot, the one a leader prepared again
+// while it caught up.
+func (l *leadership) setBallot(ballot ProposalNumber) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ballot = ballot
+}
+
+func (l *leadership) stepDown() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.leading = false
+}
+
+func (l *leadership) setCaughtUp() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.caughtUp = true
+}
+
+// nextHeartbeat returns the heartbeat to broadcast if this server leads. A leader
+// that could not renew its lease for an election timeout steps down instead.
+func (l *leadership) nextHeartbeat(now time.Time) (Heartbeat, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.leading {
+		return Heartbeat{}, false
+	}
+	if now.Sub(l.lastRenewal) > l.electionTimeout {
+		log.Println("Stepping down, lease not renewed by a quorum.")
+		l.leading = false
+		return Heartbeat{}, false
+	}
+
+	l.sequence++
+	l.sentAt[l.sequence] = now
+	for sequence := range l.sentAt {
+		if sequence < l.sequence-10 {
+			delete(l.sentAt, sequence)
+			delete(l.acks, sequence)
+		}
+	}
+	return Heartbeat{LeaderID: l.id, Address: l.address, ProposalNumber: l.ballot, Sequence: l.sequence}, true
+}
+
+func (l *leadership) shouldCampaign(now time.Time, timeout time.Duration) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !l.leading && now.Sub(l.lastHeartbeat) > timeout
+}
+
+func (l *leadership) isLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leading
+}
+
+// isCaughtUp reports whether this server leads and learned every instance its
+// predecessors may have decided.
+func (l *leadership) isCaughtUp() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leading && l.caughtUp
+}
+
+// hasLease reports whether this server may serve reads from its local state.
+func (l *leadership) hasLease(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leading && l.caughtUp && now.Before(l.leaseExpiry)
+}
+
+// leader returns the current leader unless it has been silent for an election timeout.
+func (l *leadership) leader(now time.Time) (id string, address string, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.leaderID == "" || now.Sub(l.lastHeartbeat) > l.electionTimeout {
+		return "", "", false
+	}
+	return l.leaderID, l.leaderAddress, true
+}
+
+// runLeadership sends heartbeats while this server leads and campaigns once the
+// leader has been silent for a jittered election timeout.
+func (s *Server) runLeadership(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	// A campaign runs on its own, so heartbeats keep going out while the new leader
+	// catches up and the followers' election timers do not run out meanwhile.
+	var campaigning atomic.Bool
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	timeout := s.electionTimeout()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		now := time.Now()
+		if hb, ok := s.leadership.nextHeartbeat(now); ok {
+			hb.LastInstance = s.log.LastInstance()
+			s.broadcast(heartbeatMessageType, hb, s.transport.BroadcastToAcceptors)
+			continue
+		}
+		if !campaigning.Load() && s.leadership.shouldCampaign(now, timeout) {
+			campaigning.Store(true)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer campaigning.Store(false)
+				s.campaign(ctx)
+			}()
+			timeout = s.electionTimeout()
+		}
+	}
+}
+
+func (s *Server) electionTimeout() time.Duration {
+	return s.cfg.ElectionTimeout + time.Duration(rand.Int63n(int64(s.cfg.ElectionTimeout)))
+}
+
+// campaign runs phase 1 for the whole log and, once elected, learns every instance
+// a previous leader may have decided before the new leader serves lease reads.
+func (s *Server) campaign(parent context.Context) {
+	s.proposeMu.Lock()
+	defer s.proposeMu.Unlock()
+	if s.closing.Load() {
+		return
+	}
+
+	// A campaign holds off shutdown, so it gives up as soon as shutdown begins.
+	parent, stop := s.untilClosed(parent)
+	defer stop()
+	ctx, cancel := context.WithTimeout(parent, s.cfg.ElectionTimeout)
+	defer cancel()
+
+	log.Println("No heartbeat from a leader, campaigning.")
+	instance := s.log.NextInstance()
+	config, err := s.membership.configFor(instance)
+	if err != nil {
+		log.Printf("Error: Cannot campaign: %s", err)
+		return
+	}
+	round := s.proposer.prepare(ctx, instance, s.acceptor.GetBallotNumber(), config)
+	if round == nil {
+		log.Println("Campaign failed.")
+		return
+	}
+
+	log.Printf("Elected leader with %+v", round.Number)
+	s.leadership.becomeLeader(round.Number, time.Now())
+	if hb, ok := s.leadership.nextHeartbeat(time.Now()); ok {
+		hb.LastInstance = s.log.LastInstance()
+		s.broadcast(heartbeatMessageType, hb, s.transport.BroadcastToAcceptors)
+	}
+
+	ballot := round.Number
+	for instance := s.log.NextInstance(); instance <= round.LastAccepted(); instance = s.log.NextInstance() {
+		config, err := s.membership.configFor(instance)
+		if err != nil {
+			log.Printf("Error: %s, stepping down.", err)
+			s.leadership.stepDown()
+			return
+		}
+		ctx, cancel := context.WithTimeout(parent, s.cfg.ElectionTimeout)
+		// The prepared round covers its own instance; later ones need phase 1 of
+		// their own, which may take a higher ballot.
+		chosen, number, err := s.proposer.Propose(ctx, instance, NoOp, s.acceptor.GetBallotNumber(), config)
+		cancel()
+		if err != nil {
+			log.Printf("Error: Failed to learn instance %d: %s, stepping down.", instance, err)
+			s.leadership.stepDown()
+			return
+		}
+		if number != ballot {
+			s.leadership.setBallot(number)
+			ballot = number
+		}
+		s.commit(instance, chosen)
+	}
+	s.leadership.setCaughtUp()
+}
+
+func (s *Server) onHeartbeat(hb Heartbeat) {
+	if !s.leadership.observeHeartbeat(hb, time.Now()) {
+		return
+	}
+	s.catchUp(hb)
+	ack := HeartbeatAck{FollowerID: s.cfg.ID, LeaderID: hb.LeaderID, Sequence: hb.Sequence}
+	s.broadcast(heartbeatAckMessageType, ack, s.transport.BroadcastToProposers)
+}
+
+// broadcast encodes v as a message of the given type and sends it with send.
+func (s *Server) broadcast(messageType string, v interface{}, send func(QueueMessage) error) {
+	version := s.wire.current(time.Now())
+	codec, err := CodecFor(version)
+	if err != nil {
+		log.Printf("Error: No codec for %s message: %v", messageType, err)
+		return
+	}
+	body, err := codec.EncodeBody(v)
+	if err != nil {
+		log.Printf("Error marshaling %s message: %v", messageType, err)
+		return
+	}
+	message := QueueMessage{Type: messageType, Body: body, Sender: s.cfg.ID, Version: version, MaxVersion: s.cfg.WireVersion}
+	s.tracer.send(&message)
+	if s.auth != nil {
+		if err := s.auth.Sign(&message); err != nil {
+			log.Printf("Error: Failed to sign %s message: %v", messageType, err)
+			return
+		}
+	}
+	if err := send(message); err != nil {
+		log.Printf("Error: Failed to broadcast %s message: %v", messageType, err)
+		return
+	}
+	s.metrics.messageSent(messageType)
+}
+
+// forwardToLeader proxies r to the leader. A request is forwarded at most once, so
+// servers that disagree about the leader cannot bounce it between them.
+func (s *Server) forwardToLeader(w http.ResponseWriter, r *http.Request) {
+	leaderID, address, ok := s.leadership.leader(time.Now())
+	if !ok || leaderID == s.cfg.ID || r.Header.Get(forwardedHeader) != "" {
+		writeError(w, http.StatusServiceUnavailable, CodeNotLeader, "No leader available")
+		return
+	}
+
+	log.Printf("Forwarding %s %s to leader %s at %s", r.Method, r.URL.Path, leaderID, address)
+	r.Header.Set(forwardedHeader, s.cfg.ID)
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: address})
+	proxy.ServeHTTP(w, r)
+}
+
+func (s *Server) leaderHandler(w http.ResponseWriter, r *http.Request) {
+	leaderID, address, _ := s.leadership.leader(time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		ID           string `json:"ID"`
+		LeaderID     string `json:"leader_ID"`
+		Address      string `json:"address"`
+		IsLeader     bool   `json:"is_leader"`
+		HasLease     bool   `json:"has_lease"`
+		LastInstance int    `json:"last_instance"`
+		Applied      int    `json:"applied"`
+	}{s.cfg.ID, leaderID, address, s.leadership.isLeader(), s.leadership.hasLease(time.Now()), s.log.LastInstance(), s.kv.Applied()})
+}
@@ -0,0 +1,216 @@
+package paxos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/core"
+)
+
+// Learner learns chosen values from the Accepted messages every acceptor sends,
+// counting them with a core.Votes under the configuration of their instance.
+// Chosen values are recorded in the log, so any server learns decisions whether or
+// not it proposes.
+type Learner struct {
+	mu               sync.Mutex
+	log              *Log
+	configFor        func(instance int) (Configuration, error)
+	votes            *core.Votes
+	acceptedChan     <-chan Accepted
+	fastAcceptedChan <-chan Accepted
+	// collisions receives instances whose fast ballot can no longer choose a value.
+	collisions chan int
+}
+
+// NewLearner creates a learner that records the values it learns from acceptedChan
+// and, for fast ballots, fastAcceptedChan in log. configFor returns the
+// configuration an instance is decided under.
+func NewLearner(log *Log, configFor func(instance int) (Configuration, error), acceptedChan, fastAcceptedChan <-chan Accepted) *Learner {
+	return &Learner{
+		log:              log,
+		configFor:        configFor,
+		votes:            core.NewVotes(),
+		acceptedChan:     acceptedChan,
+		fastAcceptedChan: fastAcceptedChan,
+		collisions:       make(chan int, 1),
+	}
+}
+
+// Start learns from the accepted messages until ctx is done.
+func (l *Learner) Start(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case accepted := <-l.acceptedChan:
+			l.HandleAccepted(accepted)
+		case accepted := <-l.fastAcceptedChan:
+			l.HandleFastAccepted(accepted)
+		}
+	}
+}
+
+// HandleAccepted counts accepted and returns the chosen value once its proposal
+// reached a quorum.
+func (l *Learner) HandleAccepted(accepted Accepted) (interface{}, bool) {
+	config, ok := l.undecided(accepted.Instance)
+	if !ok {
+		return nil, false
+	}
+	l.mu.Lock()
+	value, chosen := l.votes.Add(accepted, config)
+	if chosen {
+		l.votes.ForgetBefore(l.log.NextInstance())
+	}
+	l.mu.Unlock()
+
+	if !chosen {
+		return nil, false
+	}
+	log.Printf("Learned value for instance %d: %v", accepted.Instance, value)
+	l.log.Commit(accepted.Instance, value)
+	return value, true
+}
+
+// HandleFastAccepted counts accepted, a vote in a fast ballot, and returns the
+// chosen value once a fast quorum accepted it. Once no value of the ballot can get
+// there anymore, the instance is reported on collisions.
+func (l *Learner) HandleFastAccepted(accepted Accepted) (interface{}, bool) {
+	config, ok := l.undecided(accepted.Instance)
+	if !ok {
+		return nil, false
+	}
+	l.mu.Lock()
+	value, chosen, collided := l.votes.AddFast(accepted, config)
+	if chosen {
+		l.votes.ForgetBefore(l.log.NextInstance())
+	}
+	l.mu.Unlock()
+
+	if collided {
+		select {
+		case l.collisions <- accepted.Instance:
+		default:
+		}
+	}
+	if !chosen {
+		return nil, false
+	}
+	log.Printf("Learned value for instance %d in a fast ballot: %v", accepted.Instance, value)
+	l.log.Commit(accepted.Instance, value)
+	return value, true
+}
+
+// undecided returns the configuration instance is decided under, unless this
+// server already learned it or cannot tell the configuration yet.
+func (l *Learner) undecided(instance int) (Configuration, bool) {
+	if _, ok := l.log.Get(instance); ok || instance < l.log.FirstInstance() {
+		l.mu.Lock()
+		l.votes.Forget(instance)
+		l.mu.Unlock()
+		return Configuration{}, false
+	}
+	config, err := l.configFor(instance)
+	if err != nil {
+		// The decision reaches this server through DECIDE or catch-up instead.
+		return Configuration{}, false
+	}
+	return config, true
+}
+
+// Chosen returns the value chosen for instance, if this server learned it.
+func (l *Learner) Chosen(instance int) (interface{}, bool) {
+	return l.log.Get(instance)
+}
+
+// Subscribe streams the decisions from instance from on in instance order until
+// ctx is done. Decisions already compacted into a snapshot are skipped.
+func (l *Learner) Subscribe(ctx context.Context, from int) <-chan LogEntry {
+	decisions := make(chan LogEntry)
+	go func() {
+		defer close(decisions)
+		next := from
+		for {
+			changed := l.log.Changed()
+			next = max(next, l.log.FirstInstance())
+			for {
+				value, ok := l.log.Get(next)
+				if !ok {
+					break
+				}
+				select {
+				case decisions <- LogEntry{Instance: next, Value: value}:
+				case <-ctx.Done():
+					return
+				}
+				next++
+			}
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return decisions
+}
+
+// decisionHandler serves the value chosen for one instance as this server learned
+// it.
+func (s *Server) decisionHandler(w http.ResponseWriter, r *http.Request) {
+	instance, err := strconv.Atoi(r.PathValue("instance"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid instance")
+		return
+	}
+	value, ok := s.learner.Chosen(instance)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Instance %d not decided", instance)
+		return
+	}
+	writeJSON(w, http.StatusOK, LogEntry{Instance: instance, Value: value})
+}
+
+// decisionsHandler streams the decisions from the from parameter on as JSON lines
+// until the client goes away.
+func (s *Server) decisionsHandler(w http.ResponseWriter, r *http.Request) {
+	from := 1
+	if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+		var err error
+		from, err = strconv.Atoi(fromStr)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid from parameter")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	// The stream ends when the server shuts down, which would otherwise wait for
+	// it until the shutdown timeout.
+	ctx, cancel := s.untilClosed(r.Context())
+	defer cancel()
+
+	encoder := json.NewEncoder(w)
+	for decision := range s.learner.Subscribe(ctx, from) {
+		if err := encoder.Encode(decision); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
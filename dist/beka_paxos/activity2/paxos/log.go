@@ -0,0 +1,140 @@
+package paxos
+
+import (
+	"sort"
+	"sync"
+)
+
+type LogEntry struct {
+	Instance int         `json:"instance"`
+	Value    interface{} `json:"value"`
+}
+
+// Log records the value decided for each instance of the replicated log. Entries up
+// to base are covered by a snapshot and no longer kept.
+type Log struct {
+	mu      sync.RWMutex
+	base    int
+	entries map[int]interface{}
+	next    int
+	last    int
+	changed chan struct{}
+}
+
+// NewLog creates an empty replicated log whose first instance is 1.
+func NewLog() *Log {
+	return &Log{
+		entries: make(map[int]interface{}),
+		next:    1,
+		changed: make(chan struct{}),
+	}
+}
+
+// Commit records value as decided for instance. A decided instance never changes,
+// so committing an already decided instance is a no-op.
+func (l *Log) Commit(instance int, value interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.entries[instance]; ok || instance <= l.base {
+		return
+	}
+	l.entries[instance] = value
+	l.last = max(l.last, instance)
+	l.advance()
+}
+
+// advance moves next past the decided entries and wakes up waiters.
+func (l *Log) advance() {
+	for {
+		if _, ok := l.entries[l.next]; !ok {
+			break
+		}
+		l.next++
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// Truncate drops the decided entries up to and including instance, which a
+// snapshot covers.
+func (l *Log) Truncate(instance int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	instance = min(instance, l.next-1)
+	for i := l.base + 1; i <= instance; i++ {
+		delete(l.entries, i)
+	}
+	l.base = max(l.base, instance)
+}
+
+// Restore moves the start of the log past a snapshot installed up to instance.
+func (l *Log) Restore(instance int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if instance <= l.base {
+		return
+	}
+	for i := range l.entries {
+		if i <= instance {
+			delete(l.entries, i)
+		}
+	}
+	l.base = instance
+	l.last = max(l.last, instance)
+	l.next = max(l.next, instance+1)
+	l.advance()
+}
+
+// FirstInstance returns the lowest instance whose entry is still kept.
+func (l *Log) FirstInstance() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.base + 1
+}
+
+func (l *Log) Get(instance int) (interface{}, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	value, ok := l.entries[instance]
+	return value, ok
+}
+
+// NextInstance returns the lowest instance that is not yet decided locally.
+func (l *Log) NextInstance() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.next
+}
+
+// LastInstance returns the highest instance decided locally. Lower instances may
+// still be missing.
+func (l *Log) LastInstance() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.last
+}
+
+// Changed returns a channel that is closed on the next commit.
+func (l *Log) Changed() <-chan struct{} {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.changed
+}
+
+// Entries returns the decided entries with from <= instance, ordered by instance.
+func (l *Log) Entries(from int) []LogEntry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entries := make([]LogEntry, 0, len(l.entries))
+	for instance, value := range l.entries {
+		if instance >= from {
+			entries = append(entries, LogEntry{Instance: instance, Value: value})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Instance < entries[j].Instance })
+	return entries
+}
@@ -0,0 +1,260 @@
+package paxos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+const ReconfigureOp = "RECONFIGURE"
+
+type configurationChange struct {
+	Decided   int           `json:"decided"`
+	Effective int           `json:"effective"`
+	Config    Configuration `json:"config"`
+}
+
+// membership derives the configuration of every instance from the log. A
+// reconfiguration decided at instance i takes effect at instance i+window, so the
+// configuration of an instance is known once the window before it is decided, and
+// the leader never has more than window instances in flight.
+type membership struct {
+	mu        sync.Mutex
+	log       *Log
+	window    int
+	processed int
+	history   []configurationChange
+}
+
+func newMembership(log *Log, initial Configuration, window int) *membership {
+	return &membership{
+		log:     log,
+		window:  window,
+		history: []configurationChange{{Effective: 1, Config: initial}},
+	}
+}
+
+// process reads the contiguous decided prefix of the log for reconfigurations.
+func (m *membership) process() {
+	for {
+		value, ok := m.log.Get(m.processed + 1)
+		if !ok {
+			return
+		}
+		m.processed++
+		if command, ok := decodeCommand(value); ok && command.Op == ReconfigureOp {
+			m.history = append(m.history, configurationChange{
+				Decided:   m.processed,
+				Effective: m.processed + m.window,
+				Config:    nextConfiguration(m.history[len(m.history)-1].Config, command.Members, command.Quorum),
+			})
+			log.Printf("Reconfiguration at instance %d to %v takes effect at instance %d",
+				m.processed, command.Members, m.processed+m.window)
+		}
+	}
+}
+
+// nextConfiguration returns the configuration of members a reconfiguration from
+// previous sets up. It keeps the quorum system of previous unless quorum replaces
+// it; a zero quorum switches back to majorities.
+func nextConfiguration(previous Configuration, members []string, quorum *QuorumSpec) Configuration {
+	config := Configuration{Members: members, Quorum: previous.Quorum}
+	if quorum != nil {
+		config.Quorum = quorum
+		if quorum.IsZero() {
+			config.Quorum = nil
+		}
+	}
+	return config
+}
+
+// configFor returns the configuration instance is decided under.
+func (m *membership) configFor(instance int) (Configuration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.process()
+	if m.processed < instance-m.window {
+		return Configuration{}, fmt.Errorf("configuration of instance %d unknown, instance %d not decided locally",
+			instance, m.processed+1)
+	}
+
+	config := m.history[0].Config
+	for _, change := range m.history {
+		if change.Effective <= instance {
+			config = change.Config
+		}
+	}
+	return config, nil
+}
+
+// snapshot returns the configuration changes decided up to instance.
+func (m *membership) snapshot(instance int) []configurationChange {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.process()
+	var history []configurationChange
+	for _, change := range m.history {
+		if change.Decided <= instance {
+			history = append(history, change)
+		}
+	}
+	return history
+}
+
+// restore replaces the history with one taken from a snapshot up to instance.
+func (m *membership) restore(instance int, history []configurationChange) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if instance <= m.processed || len(history) == 0 {
+		return
+	}
+	m.history = history
+	m.processed = instance
+}
+
+// current returns the configuration of the next undecided instance.
+func (m *membership) current() Configuration {
+	config, err := m.configFor(m.log.NextInstance())
+	if err != nil {
+		log.Printf("Error: %s", err)
+	}
+	return config
+}
+
+// latest returns the most recently decided configuration, which may not be in
+// effect yet.
+func (m *membership) latest() Configuration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.process()
+	return m.history[len(m.history)-1].Config
+}
+
+// Reconfigure agrees on members as the new configuration through the log. A nil
+// quorum keeps the quorum system of the latest configuration.
+func (s *Server) Reconfigure(ctx context.Context, members []string, quorum *QuorumSpec) error {
+	if err := validateReconfiguration(s.membership.latest(), members, quorum, s.cfg.FastRounds); err != nil {
+		return err
+	}
+	command := newCommand(ReconfigureOp, "")
+	command.Members = members
+	command.Quorum = quorum
+	_, err := s.Execute(ctx, command)
+	return err
+}
+
+// validateReconfiguration checks that the configuration a reconfiguration from
+// latest sets up can form quorums, fast ones too if fast is set.
+func validateReconfiguration(latest Configuration, members []string, quorum *QuorumSpec, fast bool) error {
+	if len(members) == 0 {
+		return fmt.Errorf("a configuration needs at least one member")
+	}
+	next := nextConfiguration(latest, members, quorum)
+	if fast && !next.FastCapable() {
+		return fmt.Errorf("fast rounds need majority or flexible quorums")
+	}
+	if next.Quorum == nil {
+		return nil
+	}
+	if err := next.Quorum.Validate(next); err != nil {
+		return fmt.Errorf("invalid quorum: %w", err)
+	}
+	return nil
+}
+
+// membersHandler lists the configuration and changes it. POST adds {"id": ...},
+// DELETE /members/{id} removes a member and PUT replaces the whole configuration
+// with {"members": [...]}, which is also how a cluster started without explicit
+// members switches to them; an optional "quorum" replaces the quorum system. Changes are serialized, each computed from the
+// latest decided configuration.
+func (s *Server) membersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && !s.leadership.isLeader() {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"current": s.membership.current(),
+			"latest":  s.membership.latest(),
+		})
+		return
+	}
+	if !s.leadership.isLeader() {
+		s.forwardToLeader(w, r)
+		return
+	}
+
+	s.reconfigMu.Lock()
+	defer s.reconfigMu.Unlock()
+	latest := s.membership.latest()
+
+	var members []string
+	var quorum *QuorumSpec
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"current": s.membership.current(),
+			"latest":  latest,
+		})
+		return
+
+	case http.MethodPost:
+		var body struct {
+			ID string `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ID == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+			return
+		}
+		if len(latest.Members) == 0 {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "Cluster has no explicit members, PUT the full member list first"})
+			return
+		}
+		if slices.Contains(latest.Members, body.ID) {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"latest": latest})
+			return
+		}
+		members = append(slices.Clone(latest.Members), body.ID)
+
+	case http.MethodDelete:
+		id := r.PathValue("id")
+		if !slices.Contains(latest.Members, id) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not a member: " + id})
+			return
+		}
+		members = slices.DeleteFunc(slices.Clone(latest.Members), func(member string) bool { return member == id })
+
+	case http.MethodPut:
+		var body struct {
+			Members []string    `json:"members"`
+			Quorum  *QuorumSpec `json:"quorum"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+			return
+		}
+		members, quorum = body.Members, body.Quorum
+
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	slices.Sort(members)
+	members = slices.Compact(members)
+	if err := validateReconfiguration(latest, members, quorum, s.cfg.FastRounds); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.ProposeTimeout)
+	defer cancel()
+	if err := s.Reconfigure(ctx, members, quorum); err != nil {
+		log.Printf("Error: Reconfiguration to %v failed: %s", members, err)
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"latest": nextConfiguration(latest, members, quorum)})
+}
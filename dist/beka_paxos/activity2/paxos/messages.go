@@ -1,24 +1,15 @@
 package paxos
 
-type Prepare struct {
-	ProposalNumber ProposalNumber `json:"proposal_number"`
-}
-
-type Promise struct {
-	ProposalNumber ProposalNumber `json:"proposal_number"`
-}
-
-type Accept struct {
-	ProposalNumber ProposalNumber `json:"proposal_number"`
-	Value          interface{}    `json:"value"`
-}
-
-type Accepted struct {
-	ProposalNumber ProposalNumber `json:"proposal_number"`
-	Value          interface{}    `json:"value"`
-}
-
-type ProposalNumber struct {
-	BallotNumber int    `json:"ballot_number"`
-	ProposerID   string `json:"proposer_ID"`
-}
+import "github.com/beka-birhanu/paxos-lab-activity2/core"
+
+// The messages of the protocol are those of the core state machines.
+type (
+	Prepare        = core.Prepare
+	Promise        = core.Promise
+	Accept         = core.Accept
+	Accepted       = core.Accepted
+	Nack           = core.Nack
+	Any            = core.Any
+	FastAccept     = core.FastAccept
+	ProposalNumber = core.ProposalNumber
+)
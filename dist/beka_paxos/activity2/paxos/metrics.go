@@ -0,0 +1,288 @@
+package paxos
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Metrics collects the counters and histograms served on /metrics in the
+// Prometheus text format. A nil *Metrics records nothing, so components can be
+// used without one.
+type Metrics struct {
+	messagesSent      *counterVec
+	messagesReceived  *counterVec
+	messagesRejected  *counterVec
+	rejectedBallots   *counterVec
+	rejectedRounds    *counterVec
+	retries           *counterVec
+	instanceRetries   *counterVec
+	proposals         *counterVec
+	roundsPerDecision *histogram
+	proposalLatency   *histogram
+	batchSize         *histogram
+	fastRecoveries    *counterVec
+
+	mu     sync.Mutex
+	gauges []gauge
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		messagesSent: newCounterVec("paxos_messages_sent_total",
+			"Messages broadcast by this server.", "type"),
+		messagesReceived: newCounterVec("paxos_messages_received_total",
+			"Messages received by this server.", "type"),
+		messagesRejected: newCounterVec("paxos_messages_rejected_total",
+			"Received messages dropped because they failed authentication.", "reason"),
+		rejectedBallots: newCounterVec("paxos_acceptor_rejected_ballots_total",
+			"Prepare and accept messages the local acceptor rejected.", "phase"),
+		rejectedRounds: newCounterVec("paxos_proposer_rejected_rounds_total",
+			"Rounds of the local proposer a quorum could no longer accept.", "phase"),
+		retries: newCounterVec("paxos_proposer_retries_total",
+			"Rounds the local proposer resent after a timeout or rejection.", "phase"),
+		instanceRetries: newCounterVec("paxos_instance_retries_total",
+			"Proposals moved to another instance after a different value was decided.", "round"),
+		proposals: newCounterVec("paxos_proposals_total",
+			"Proposals by outcome.", "result"),
+		roundsPerDecision: newHistogram("paxos_rounds_per_decision",
+			"Prepare and accept rounds the local proposer needed per decided instance.",
+			[]float64{1, 2, 3, 4, 6, 8, 12, 16}),
+		proposalLatency: newHistogram("paxos_proposal_latency_seconds",
+			"Time from receiving a proposal to its decision or failure.",
+			[]float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}),
+		batchSize: newHistogram("paxos_batch_size",
+			"Values the local server proposed together in one log entry.",
+			[]float64{1, 2, 4, 8, 16, 32, 64, 128, 256}),
+		fastRecoveries: newCounterVec("paxos_fast_recoveries_total",
+			"Instances of a fast ballot the local coordinator decided in a classic round.", "reason"),
+	}
+}
+
+// Gauge registers a gauge whose value is read from value on every scrape.
+func (m *Metrics) Gauge(name, help string, value func() float64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges = append(m.gauges, gauge{name: name, help: help, value: value})
+}
+
+func (m *Metrics) messageSent(messageType string) {
+	if m != nil {
+		m.messagesSent.inc(messageType)
+	}
+}
+
+func (m *Metrics) messageReceived(messageType string) {
+	if m != nil {
+		m.messagesReceived.inc(messageType)
+	}
+}
+
+func (m *Metrics) messageRejected(reason string) {
+	if m != nil {
+		m.messagesRejected.inc(reason)
+	}
+}
+
+func (m *Metrics) ballotRejected(phase string) {
+	if m != nil {
+		m.rejectedBallots.inc(phase)
+	}
+}
+
+func (m *Metrics) roundRejected(phase string) {
+	if m != nil {
+		m.rejectedRounds.inc(phase)
+	}
+}
+
+func (m *Metrics) retried(phase string) {
+	if m != nil {
+		m.retries.inc(phase)
+	}
+}
+
+func (m *Metrics) instanceRetried(round string) {
+	if m != nil {
+		m.instanceRetries.inc(round)
+	}
+}
+
+func (m *Metrics) decided(rounds int) {
+	if m != nil {
+		m.roundsPerDecision.observe(float64(rounds))
+	}
+}
+
+func (m *Metrics) proposed(result string, latency time.Duration) {
+	if m != nil {
+		m.proposals.inc(result)
+		m.proposalLatency.observe(latency.Seconds())
+	}
+}
+
+func (m *Metrics) batched(size int) {
+	if m != nil {
+		m.batchSize.observe(float64(size))
+	}
+}
+
+func (m *Metrics) fastRecovered(reason string) {
+	if m != nil {
+		m.fastRecoveries.inc(reason)
+	}
+}
+
+// WriteTo writes every metric in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	for _, c := range []*counterVec{m.messagesSent, m.messagesReceived, m.messagesRejected, m.rejectedBallots,
+		m.rejectedRounds, m.retries, m.instanceRetries, m.proposals, m.fastRecoveries} {
+		c.write(cw)
+	}
+	m.roundsPerDecision.write(cw)
+	m.proposalLatency.write(cw)
+	m.batchSize.write(cw)
+
+	m.mu.Lock()
+	gauges := slices.Clone(m.gauges)
+	m.mu.Unlock()
+	for _, g := range gauges {
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatFloat(g.value()))
+	}
+	return cw.n, cw.err
+}
+
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if _, err := s.metrics.WriteTo(w); err != nil {
+		log.Printf("Error: while writing metrics: %s", err)
+	}
+}
+
+func (s *Server) registerGauges() {
+	s.metrics.Gauge("paxos_acceptor_promised_ballot", "Ballot the local acceptor promised.",
+		func() float64 { return float64(s.acceptor.GetBallotNumber()) })
+	s.metrics.Gauge("paxos_leader", "Whether this server is the leader.", func() float64 {
+		if s.leadership.isLeader() {
+			return 1
+		}
+		return 0
+	})
+	s.metrics.Gauge("paxos_log_next_instance", "Lowest instance not decided locally.",
+		func() float64 { return float64(s.log.NextInstance()) })
+	s.metrics.Gauge("paxos_applied_instance", "Last instance applied to the key-value store.",
+		func() float64 { return float64(s.kv.Applied()) })
+	s.metrics.Gauge("paxos_wire_version", "Wire version this server sends messages in.",
+		func() float64 { return float64(s.wire.current(time.Now())) })
+}
+
+type counterVec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	label  string
+	values map[string]float64
+}
+
+func newCounterVec(name, help, label string) *counterVec {
+	return &counterVec{name: name, help: help, label: label, values: make(map[string]float64)}
+}
+
+func (c *counterVec) inc(labelValue string) {
+	c.mu.Lock()
+	c.values[labelValue]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	labels := make([]string, 0, len(c.values))
+	for label := range c.values {
+		labels = append(labels, label)
+	}
+	slices.Sort(labels)
+	for _, label := range labels {
+		fmt.Fprintf(w, "%s{%s=%q} %s\n", c.name, c.label, label, formatFloat(c.values[label]))
+	}
+}
+
+type histogram struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", h.name, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", h.name, formatFloat(h.sum), h.name, h.count)
+}
+
+type gauge struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
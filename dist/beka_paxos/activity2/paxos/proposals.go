@@ -0,0 +1,241 @@
+package paxos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/core"
+)
+
+// maxProposalSize bounds the body of a proposal request.
+const maxProposalSize = 1 << 20
+
+// Error codes of the proposals API. Clients retry the transient ones, NotLeader,
+// ShuttingDown, Timeout, NoQuorum and Preempted; Lost means another value was
+// chosen for the instance and retrying cannot change that.
+const (
+	CodeInvalid          = "invalid_request"
+	CodeTooLarge         = "too_large"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeNotLeader        = "not_leader"
+	CodeShuttingDown     = "shutting_down"
+	CodeTimeout          = "timeout"
+	CodeNoQuorum         = "no_quorum"
+	CodePreempted        = "preempted"
+	CodeLost             = "lost"
+	CodeCompacted        = "compacted"
+	CodeFutureInstance   = "future_instance"
+	CodeInternal         = "internal"
+)
+
+// ProposalRequest is the body of POST /v1/proposals. Without Instance the value is
+// appended to the log; with it, the value competes for that instance only.
+type ProposalRequest struct {
+	Value    interface{} `json:"value"`
+	Instance *int        `json:"instance,omitempty"`
+}
+
+// ProposalResponse reports the value chosen for an instance and the ballot it was
+// chosen with, which is omitted when a fast round chose it or this server learned
+// it earlier. Key is the idempotency key the proposal was made with.
+type ProposalResponse struct {
+	Instance int             `json:"instance"`
+	Value    interface{}     `json:"value"`
+	Ballot   *ProposalNumber `json:"ballot,omitempty"`
+	Key      string          `json:"key,omitempty"`
+}
+
+// ProposeAt gets value chosen for instance and returns the value chosen there,
+// which is another one when a different proposal won the instance, and the ballot
+// it was chosen with, zero when this server learned the value before. Instance has
+// to be decided already or not be past the next free instance.
+func (s *Server) ProposeAt(ctx context.Context, instance int, value interface{}) (interface{}, ProposalNumber, error) {
+	s.proposeMu.RLock()
+	defer s.proposeMu.RUnlock()
+	if s.closing.Load() {
+		return nil, ProposalNumber{}, ErrServerClosed
+	}
+	if !s.leadership.isLeader() {
+		return nil, ProposalNumber{}, ErrNotLeader
+	}
+	if chosen, ok := s.log.Get(instance); ok {
+		return chosen, ProposalNumber{}, nil
+	}
+	if instance < s.log.FirstInstance() {
+		return nil, ProposalNumber{}, ErrCompacted
+	}
+
+	claimed, err := s.claimInstance(instance)
+	if err != nil {
+		return nil, ProposalNumber{}, err
+	}
+	if !claimed {
+		// Another local proposal works on instance; its outcome is ours.
+		chosen, err := s.awaitDecision(ctx, instance)
+		if err != nil {
+			return nil, ProposalNumber{}, contextError(err)
+		}
+		return chosen, ProposalNumber{}, nil
+	}
+
+	start := time.Now()
+	result := "error"
+	defer func() {
+		s.metrics.proposed(result, time.Since(start))
+	}()
+	config, err := s.configFor(ctx, instance)
+	if err != nil {
+		s.releaseInstance(instance)
+		return nil, ProposalNumber{}, err
+	}
+	var chosen interface{}
+	var ballot ProposalNumber
+	if s.cfg.FastRounds {
+		// The fast ballot may be open at instance, and a classic accept must not
+		// reuse it.
+		chosen, ballot, _, err = s.proposer.Recover(ctx, instance, value, s.acceptor.GetBallotNumber(), config)
+	} else {
+		chosen, ballot, err = s.proposer.Propose(ctx, instance, value, s.acceptor.GetBallotNumber(), config)
+	}
+	if err != nil {
+		s.releaseInstance(instance)
+		result = "no_consensus"
+		return nil, ProposalNumber{}, err
+	}
+	s.commit(instance, chosen)
+	result = "decided"
+	return chosen, ballot, nil
+}
+
+// proposalsHandler serves POST /v1/proposals. It answers with a ProposalResponse,
+// or with {"code": ..., "error": ...} where code is one of the Code* constants;
+// Lost answers also hold the instance and the value chosen there.
+func (s *Server) proposalsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !s.leadership.isLeader() {
+		s.forwardToLeader(w, r)
+		return
+	}
+
+	var body ProposalRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxProposalSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, CodeTooLarge, fmt.Sprintf("Proposal exceeds %d bytes", tooLarge.Limit))
+			return
+		}
+		writeError(w, http.StatusBadRequest, CodeInvalid, "Invalid request payload: "+err.Error())
+		return
+	}
+	if body.Value == nil {
+		writeError(w, http.StatusBadRequest, CodeInvalid, "Missing value")
+		return
+	}
+	key := r.Header.Get(IdempotencyHeader)
+	if body.Instance != nil && *body.Instance < 1 {
+		writeError(w, http.StatusBadRequest, CodeInvalid, "Instance must be positive")
+		return
+	}
+	if body.Instance != nil && key != "" {
+		// Proposals for an instance are idempotent already: a retry finds the
+		// instance decided.
+		writeError(w, http.StatusBadRequest, CodeInvalid, "Proposals for an instance take no idempotency key")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.ProposeTimeout)
+	defer cancel()
+
+	if body.Instance != nil {
+		instance := *body.Instance
+		chosen, ballot, err := s.ProposeAt(ctx, instance, body.Value)
+		if err != nil {
+			s.writeProposalError(w, err)
+			return
+		}
+		chosen = Unkeyed(chosen)
+		if !core.SameValue(chosen, body.Value) {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{
+				"code":     CodeLost,
+				"error":    fmt.Sprintf("Instance %d was decided with another value", instance),
+				"instance": instance,
+				"value":    chosen,
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, ProposalResponse{Instance: instance, Value: chosen, Ballot: ballotOf(ballot)})
+		return
+	}
+
+	var pos position
+	var err error
+	if key != "" {
+		pos, err = s.proposeOnce(ctx, key, body.Value)
+	} else {
+		pos, err = s.propose(ctx, body.Value)
+	}
+	if err != nil {
+		s.writeProposalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ProposalResponse{Instance: pos.instance, Value: s.valueAt(pos, body.Value), Ballot: ballotOf(pos.ballot), Key: key})
+}
+
+// writeProposalError answers a proposal that failed with err.
+func (s *Server) writeProposalError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotLeader):
+		writeError(w, http.StatusServiceUnavailable, CodeNotLeader, "Leadership lost")
+	case errors.Is(err, ErrServerClosed):
+		writeError(w, http.StatusServiceUnavailable, CodeShuttingDown, "Server shutting down")
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, CodeTimeout, "Consensus not reached in time")
+	case errors.Is(err, ErrPreempted):
+		writeError(w, http.StatusServiceUnavailable, CodePreempted, "Preempted by a higher ballot")
+	case errors.Is(err, ErrNoQuorum):
+		writeError(w, http.StatusServiceUnavailable, CodeNoQuorum, "No quorum of acceptors answered")
+	case errors.Is(err, ErrCompacted):
+		writeError(w, http.StatusGone, CodeCompacted, "Instance compacted into a snapshot")
+	case errors.Is(err, ErrFutureInstance):
+		writeError(w, http.StatusConflict, CodeFutureInstance, err.Error())
+	default:
+		log.Printf("Error: Proposal failed: %s", err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+}
+
+// valueAt returns the value decided at pos without its idempotency key, or value
+// when the entry is no longer in the log.
+func (s *Server) valueAt(pos position, value interface{}) interface{} {
+	entry, ok := s.log.Get(pos.instance)
+	if !ok {
+		return value
+	}
+	values := batchValues(entry)
+	if pos.index >= len(values) {
+		return value
+	}
+	return Unkeyed(values[pos.index])
+}
+
+func ballotOf(number ProposalNumber) *ProposalNumber {
+	if number == (ProposalNumber{}) {
+		return nil
+	}
+	return &number
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]string{"code": code, "error": message})
+}
@@ -0,0 +1,93 @@
+package paxos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// decodeResponse decodes the JSON body recorded by response.
+func decodeResponse(t *testing.T, response *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		t.Fatalf("decode %q: %s", response.Body.String(), err)
+	}
+	return body
+}
+
+func TestWriteProposalError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{ErrNotLeader, http.StatusServiceUnavailable, CodeNotLeader},
+		{ErrServerClosed, http.StatusServiceUnavailable, CodeShuttingDown},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout, CodeTimeout},
+		{fmt.Errorf("accept: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, CodeTimeout},
+		{ErrNoQuorum, http.StatusServiceUnavailable, CodeNoQuorum},
+		{ErrPreempted, http.StatusServiceUnavailable, CodePreempted},
+		{ErrCompacted, http.StatusGone, CodeCompacted},
+		{ErrFutureInstance, http.StatusConflict, CodeFutureInstance},
+		{fmt.Errorf("disk full"), http.StatusInternalServerError, CodeInternal},
+	}
+	var s Server
+	for _, tt := range tests {
+		response := httptest.NewRecorder()
+		s.writeProposalError(response, tt.err)
+		if response.Code != tt.status {
+			t.Errorf("%v: status %d, want %d", tt.err, response.Code, tt.status)
+		}
+		if body := decodeResponse(t, response); body["code"] != tt.code || body["error"] == "" {
+			t.Errorf("%v: body %v, want code %q", tt.err, body, tt.code)
+		}
+	}
+}
+
+func TestProposalsHandler(t *testing.T) {
+	servers := startCluster(t, 3, Config{})
+	leader := waitForLeader(t, servers, "first")
+	handler := leader.Handler()
+	post := func(body string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/v1/proposals", strings.NewReader(body)))
+		return response
+	}
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/v1/proposals", nil))
+	if response.Code != http.StatusMethodNotAllowed || response.Header().Get("Allow") != http.MethodPost {
+		t.Fatalf("GET: status %d, Allow %q", response.Code, response.Header().Get("Allow"))
+	}
+	if body := decodeResponse(t, response); body["code"] != CodeMethodNotAllowed {
+		t.Fatalf("GET: body %v", body)
+	}
+
+	response = post(fmt.Sprintf(`{"value": %q}`, strings.Repeat("x", maxProposalSize)))
+	if response.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("large proposal: status %d", response.Code)
+	}
+	if body := decodeResponse(t, response); body["code"] != CodeTooLarge {
+		t.Fatalf("large proposal: body %v", body)
+	}
+
+	instance := leader.log.NextInstance()
+	response = post(fmt.Sprintf(`{"value": "a", "instance": %d}`, instance))
+	if response.Code != http.StatusOK {
+		t.Fatalf("proposal for instance %d: status %d, body %s", instance, response.Code, response.Body)
+	}
+	// Another value for the decided instance loses to the one chosen there.
+	response = post(fmt.Sprintf(`{"value": "b", "instance": %d}`, instance))
+	if response.Code != http.StatusConflict {
+		t.Fatalf("losing proposal: status %d, body %s", response.Code, response.Body)
+	}
+	body := decodeResponse(t, response)
+	if body["code"] != CodeLost || body["value"] != "a" || body["instance"] != float64(instance) {
+		t.Fatalf("losing proposal: body %v, want code %q with value a", body, CodeLost)
+	}
+}
@@ -2,98 +2,394 @@ package paxos
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/core"
 )
 
+// inflightChanSize bounds the responses queued for one in-flight round.
+const inflightChanSize = 32
+
 type Proposer struct {
-	proposalNumber    ProposalNumber
-	prepareChan       chan<- Prepare
-	promiseChan       <-chan Promise
-	acceptChan        chan<- Accept
-	acceptedChan      <-chan Accepted
-	maxRetry          int
-	numberOfAccepters int
+	id string
+	// prepareMu serializes phase 1, so concurrent proposals share one prepared
+	// ballot instead of preempting each other.
+	prepareMu      sync.Mutex
+	mu             sync.Mutex
+	proposalNumber ProposalNumber
+	prepared       *core.Round
+	// highestSeen is the highest ballot acceptors reported promising in NACKs. The
+	// next prepare starts past it instead of climbing one ballot per timeout.
+	highestSeen int
+	requests    atomic.Uint64
+	inflightMu  sync.Mutex
+	inflight    map[string]*inflightRound
+	prepareChan chan<- Prepare
+	acceptChan  chan<- Accept
+	policy      RetryPolicy
+	metrics     *Metrics
+}
+
+var errPhaseTimeout = errors.New("phase timed out")
+
+// RetryPolicy bounds how long the proposer waits for the responses to a round and
+// how often and how far apart it resends rounds that time out.
+type RetryPolicy struct {
+	MaxRetries   int
+	PhaseTimeout time.Duration
+	Backoff      time.Duration
+	MaxBackoff   time.Duration
+}
+
+// backoff returns how long to wait before the given retry: a random duration
+// between half and all of Backoff doubled per earlier retry, capped at MaxBackoff.
+// The jitter keeps competing proposers from retrying in lockstep.
+func (r RetryPolicy) backoff(retry int) time.Duration {
+	if r.Backoff <= 0 {
+		return 0
+	}
+	d := r.Backoff << min(retry-1, 16)
+	if r.MaxBackoff > 0 && d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// inflightRound receives the responses to one round, which are routed to it by
+// request ID.
+type inflightRound struct {
+	promises chan Promise
+	accepted chan Accepted
+	nacks    chan Nack
 }
 
-// NewProposer creates and returns a new Proposer instance.
+// NewProposer creates and returns a new Proposer instance. Responses reach it
+// through HandlePromise, HandleAccepted and HandleNack.
 func NewProposer(
 	proposerID string,
-	numberOfAccepters,
-	maxRetry int,
+	policy RetryPolicy,
 	prepareChan chan<- Prepare,
-	promiseChan <-chan Promise,
-	acceptChan chan<- Accept,
-	acceptedChan <-chan Accepted) *Proposer {
+	acceptChan chan<- Accept) *Proposer {
 	return &Proposer{
-		proposalNumber:    ProposalNumber{BallotNumber: 0, ProposerID: proposerID},
-		numberOfAccepters: numberOfAccepters,
-		maxRetry:          maxRetry,
-		prepareChan:       prepareChan,
-		promiseChan:       promiseChan,
-		acceptChan:        acceptChan,
-		acceptedChan:      acceptedChan,
+		id:             proposerID,
+		proposalNumber: ProposalNumber{BallotNumber: 0, ProposerID: proposerID},
+		policy:         policy,
+		inflight:       make(map[string]*inflightRound),
+		prepareChan:    prepareChan,
+		acceptChan:     acceptChan,
 	}
 }
 
-func (p *Proposer) Propose(ctx context.Context, value interface{}, ballotNumber int) interface{} {
-	p.proposalNumber.BallotNumber = ballotNumber
-	for range p.maxRetry {
-		p.proposalNumber.BallotNumber++
-		prepare := Prepare{ProposalNumber: p.proposalNumber}
-		p.prepareChan <- prepare
-
-		promises := 0
-		for promises <= p.numberOfAccepters/2 {
-			select {
-			case <-ctx.Done():
-				fmt.Printf("\nError: Time out on propose with Prepare:%v\n", prepare)
-				return nil
-			case <-time.After(time.Millisecond * 300):
-				fmt.Printf("\nInfo: Time out on propose with Prepare:%v  retrying...\n", prepare)
-				break
-			case promise := <-p.promiseChan:
-				fmt.Println(promise)
-				if promise.ProposalNumber.BallotNumber == p.proposalNumber.BallotNumber &&
-					promise.ProposalNumber.ProposerID == p.proposalNumber.ProposerID {
-					promises += 1
-				}
-			default:
-				time.Sleep(time.Millisecond)
-			}
+// Propose tries to get a value chosen for the given log instance and returns it
+// with the proposal number it was chosen with. The chosen value is not necessarily
+// value: if a quorum member already accepted a proposal for the instance, the value
+// of the highest-numbered one is proposed instead. Once phase 1 succeeded for a
+// ballot, the promise covers every later instance, so a stable proposer goes
+// straight to phase 2 for instances no promising acceptor has accepted anything
+// for. Votes are counted against config, the configuration instance is decided
+// under. Proposals for different instances may run concurrently. When no value
+// could be chosen it fails with ErrNoQuorum, ErrPreempted or the error of ctx.
+func (p *Proposer) Propose(ctx context.Context, instance int, value interface{}, ballotNumber int, config Configuration) (interface{}, ProposalNumber, error) {
+	number, adopted, prepareRounds, err := p.phase1(ctx, instance, ballotNumber, config)
+	if err != nil {
+		return nil, ProposalNumber{}, err
+	}
+	if adopted != nil {
+		value = adopted
+	}
+
+	chosen, acceptRounds, err := p.accept(ctx, instance, value, number, config)
+	if err != nil {
+		p.mu.Lock()
+		if p.prepared != nil && p.prepared.Number == number {
+			p.prepared = nil
 		}
-		if promises > p.numberOfAccepters/2 {
-			break
+		p.mu.Unlock()
+		return nil, ProposalNumber{}, err
+	}
+	p.metrics.decided(prepareRounds + acceptRounds)
+	return chosen, number, nil
+}
+
+// phase1 returns the proposal number instance is accepted with, the value it has to
+// adopt and the rounds it took, running phase 1 unless the prepared ballot already
+// covers instance.
+func (p *Proposer) phase1(ctx context.Context, instance int, ballotNumber int, config Configuration) (ProposalNumber, interface{}, int, error) {
+	p.prepareMu.Lock()
+	defer p.prepareMu.Unlock()
+
+	p.mu.Lock()
+	prepared := p.prepared
+	p.mu.Unlock()
+	if prepared != nil && prepared.Covers(instance, config) {
+		if instance == prepared.Instance {
+			return prepared.Number, prepared.AdoptedValue(), 0, nil
 		}
+		return prepared.Number, nil, 0, nil
 	}
 
-	for range p.maxRetry {
-		accept := Accept{Value: value, ProposalNumber: p.proposalNumber}
-		p.acceptChan <- accept
-
-		accepts := 0
-		for accepts <= p.numberOfAccepters/2 {
-			select {
-			case <-ctx.Done():
-				fmt.Printf("\nError: Time out on propose with Accept:%v\n", accept)
-				return nil
-			case <-time.After(time.Millisecond * 300):
-				fmt.Printf("\nInfo: Time out on propose with Accept:%v  retrying...\n", accept)
-				break
-			case ack := <-p.acceptedChan:
-				if ack.ProposalNumber.BallotNumber == p.proposalNumber.BallotNumber &&
-					ack.ProposalNumber.ProposerID == p.proposalNumber.ProposerID {
-					accepts++
-				}
-			default:
-				time.Sleep(time.Millisecond)
+	round, rounds, err := p.runPrepare(ctx, instance, ballotNumber, config)
+	if err != nil {
+		return ProposalNumber{}, nil, rounds, err
+	}
+	return round.Number, round.AdoptedValue(), rounds, nil
+}
+
+// Recover gets a value chosen for instance after its fast ballot collided or
+// stalled. It always runs phase 1 with a new ballot, which closes the fast ballot,
+// and proposes the value that may have been chosen there, else any value accepted
+// there, else value. It returns the chosen value and its proposal number like
+// Propose, and the highest instance a promising acceptor accepted anything for.
+func (p *Proposer) Recover(ctx context.Context, instance int, value interface{}, ballotNumber int, config Configuration) (interface{}, ProposalNumber, int, error) {
+	p.prepareMu.Lock()
+	round, prepareRounds, err := p.runPrepare(ctx, instance, ballotNumber, config)
+	p.prepareMu.Unlock()
+	if err != nil {
+		return nil, ProposalNumber{}, 0, err
+	}
+	if adopted := round.AdoptedValue(); adopted != nil {
+		value = adopted
+	}
+
+	chosen, acceptRounds, err := p.accept(ctx, instance, value, round.Number, config)
+	if err != nil {
+		p.mu.Lock()
+		if p.prepared == round {
+			p.prepared = nil
+		}
+		p.mu.Unlock()
+		return nil, ProposalNumber{}, round.LastAccepted(), err
+	}
+	p.metrics.decided(prepareRounds + acceptRounds)
+	return chosen, round.Number, round.LastAccepted(), nil
+}
+
+// preparedRound returns the round whose promises the proposer currently holds, or nil.
+func (p *Proposer) preparedRound() *core.Round {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.prepared
+}
+
+// prepare runs phase 1 for instance and returns the round a quorum promised, or nil.
+func (p *Proposer) prepare(ctx context.Context, instance int, ballotNumber int, config Configuration) *core.Round {
+	p.prepareMu.Lock()
+	defer p.prepareMu.Unlock()
+	round, _, _ := p.runPrepare(ctx, instance, ballotNumber, config)
+	return round
+}
+
+// runPrepare runs phase 1 and returns the promised round and the number of rounds
+// it took. It fails with ErrPreempted when the last round was rejected, with
+// ErrNoQuorum when it timed out, or with the error of ctx.
+func (p *Proposer) runPrepare(ctx context.Context, instance int, ballotNumber int, config Configuration) (*core.Round, int, error) {
+	p.mu.Lock()
+	p.prepared = nil
+	p.proposalNumber.BallotNumber = max(ballotNumber, p.highestSeen)
+	p.mu.Unlock()
+
+	failure := ErrNoQuorum
+	for attempt := range p.policy.MaxRetries {
+		if attempt > 0 {
+			p.metrics.retried(prepareMessageType)
+			if !sleep(ctx, p.policy.backoff(attempt)) {
+				return nil, attempt, contextError(ctx.Err())
 			}
+		}
+		p.mu.Lock()
+		p.proposalNumber.BallotNumber++
+		number := p.proposalNumber
+		p.mu.Unlock()
+
+		round := core.NewRound(instance, number, config)
+		round.RequestID = p.newRequestID()
+		responses := p.register(round.RequestID)
+		prepare := round.Prepare()
+		send(ctx, p.prepareChan, prepare)
+
+		switch err := p.await(ctx, round, responses, round.Promised); {
+		case err == errPhaseTimeout:
+			failure = ErrNoQuorum
+			log.Printf("Info: Time out on propose with Prepare:%v, retrying...", prepare)
+		case err != nil:
+			log.Printf("Error: Time out on propose with Prepare:%v", prepare)
+			return nil, attempt + 1, contextError(err)
+		case round.Promised():
+			p.mu.Lock()
+			p.prepared = round
+			p.mu.Unlock()
+			return round, attempt + 1, nil
+		default:
+			failure = ErrPreempted
+			p.metrics.roundRejected(prepareMessageType)
+			p.mu.Lock()
+			log.Printf("Info: Prepare:%v rejected, acceptors promised ballot %d, retrying...", prepare, p.highestSeen)
+			p.proposalNumber.BallotNumber = max(p.proposalNumber.BallotNumber, p.highestSeen)
+			p.mu.Unlock()
+		}
+	}
 
+	return nil, p.policy.MaxRetries, failure
+}
+
+// accept runs phase 2 and returns the chosen value and the number of rounds it
+// took. It fails like runPrepare.
+func (p *Proposer) accept(ctx context.Context, instance int, value interface{}, number ProposalNumber, config Configuration) (interface{}, int, error) {
+	for attempt := range p.policy.MaxRetries {
+		if attempt > 0 {
+			p.metrics.retried(acceptMessageType)
+			if !sleep(ctx, p.policy.backoff(attempt)) {
+				return nil, attempt, contextError(ctx.Err())
+			}
 		}
-		if accepts > p.numberOfAccepters/2 {
-			return value
+		round := core.NewRound(instance, number, config)
+		round.RequestID = p.newRequestID()
+		responses := p.register(round.RequestID)
+		accept := round.Accept(value)
+		send(ctx, p.acceptChan, accept)
+
+		switch err := p.await(ctx, round, responses, round.Chosen); {
+		case err == errPhaseTimeout:
+			log.Printf("Info: Time out on propose with Accept:%v, retrying...", accept)
+		case err != nil:
+			log.Printf("Error: Time out on propose with Accept:%v", accept)
+			return nil, attempt + 1, contextError(err)
+		case round.Chosen():
+			return value, attempt + 1, nil
+		default:
+			p.metrics.roundRejected(acceptMessageType)
+			// A higher ballot was promised, so retrying the same ballot cannot succeed.
+			log.Printf("Info: Accept:%v rejected, acceptors promised ballot %d", accept, round.PromisedHint().BallotNumber)
+			return nil, attempt + 1, ErrPreempted
 		}
 	}
 
+	return nil, p.policy.MaxRetries, ErrNoQuorum
+}
+
+// contextError returns err, the error of a done context, as a failed proposal.
+func contextError(err error) error {
+	return fmt.Errorf("%w: %w", ErrNoConsensus, err)
+}
+
+// await feeds the responses routed to round into it until done reports true or a
+// quorum rejected it. It blocks without polling and fails with errPhaseTimeout once
+// the phase deadline passed, or with the error of ctx. The round is unregistered
+// when await returns.
+func (p *Proposer) await(ctx context.Context, round *core.Round, responses *inflightRound, done func() bool) error {
+	defer p.unregister(round.RequestID)
+	deadline := time.NewTimer(p.policy.PhaseTimeout)
+	defer deadline.Stop()
+
+	for !done() && !round.Rejected() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline.C:
+			return errPhaseTimeout
+		case promise := <-responses.promises:
+			round.AddPromise(promise)
+		case ack := <-responses.accepted:
+			round.AddAccepted(ack)
+		case nack := <-responses.nacks:
+			round.AddNack(nack)
+		}
+	}
 	return nil
 }
+
+// sleep waits for d and reports whether ctx was still running afterwards.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (p *Proposer) newRequestID() string {
+	return fmt.Sprintf("%s-%d", p.id, p.requests.Add(1))
+}
+
+func (p *Proposer) register(requestID string) *inflightRound {
+	responses := &inflightRound{
+		promises: make(chan Promise, inflightChanSize),
+		accepted: make(chan Accepted, inflightChanSize),
+		nacks:    make(chan Nack, inflightChanSize),
+	}
+	p.inflightMu.Lock()
+	p.inflight[requestID] = responses
+	p.inflightMu.Unlock()
+	return responses
+}
+
+func (p *Proposer) unregister(requestID string) {
+	p.inflightMu.Lock()
+	delete(p.inflight, requestID)
+	p.inflightMu.Unlock()
+}
+
+func (p *Proposer) lookup(requestID string) (*inflightRound, bool) {
+	p.inflightMu.Lock()
+	defer p.inflightMu.Unlock()
+	responses, ok := p.inflight[requestID]
+	return responses, ok
+}
+
+// HandlePromise routes promise to the round that sent the prepare it answers and
+// reports whether that round is still in flight.
+func (p *Proposer) HandlePromise(promise Promise) bool {
+	responses, ok := p.lookup(promise.RequestID)
+	if !ok {
+		return false
+	}
+	select {
+	case responses.promises <- promise:
+	default:
+	}
+	return true
+}
+
+// HandleAccepted routes accepted to the round that sent the accept it answers and
+// reports whether that round is still in flight.
+func (p *Proposer) HandleAccepted(accepted Accepted) bool {
+	responses, ok := p.lookup(accepted.RequestID)
+	if !ok {
+		return false
+	}
+	select {
+	case responses.accepted <- accepted:
+	default:
+	}
+	return true
+}
+
+// HandleNack remembers the ballot nack reports and routes it to the round it
+// rejects. It reports whether that round is still in flight.
+func (p *Proposer) HandleNack(nack Nack) bool {
+	p.mu.Lock()
+	p.highestSeen = max(p.highestSeen, nack.PromisedNumber.BallotNumber)
+	p.mu.Unlock()
+
+	responses, ok := p.lookup(nack.RequestID)
+	if !ok {
+		return false
+	}
+	select {
+	case responses.nacks <- nack:
+	default:
+	}
+	return true
+}
@@ -3,375 +3,765 @@ package paxos
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/streadway/amqp"
+	"github.com/beka-birhanu/paxos-lab-activity2/core"
 )
 
 const (
-	acceptorQueueKey    = "FOR_ACCEPTORS"
-	proposerQueueKey    = "FOR_PROPOSERS"
-	prepareMessageType  = "PREPARE"
+	prepareMessageType  = core.PrepareMessage
 	promiseMessageType  = "PROMISE"
-	acceptMessageType   = "ACCEPT"
+	acceptMessageType   = core.AcceptMessage
 	acceptedMessageType = "ACCEPTED"
+	nackMessageType     = "NACK"
+
+	// roleChanSize bounds the messages queued for the local acceptor and proposer.
+	// Messages beyond it are dropped like lost network messages, which Paxos
+	// tolerates, instead of blocking the server loop.
+	roleChanSize = 128
+)
+
+var (
+	ErrNoConsensus  = errors.New("consensus not reached")
+	ErrNotLeader    = errors.New("not the leader")
+	ErrServerClosed = errors.New("server closed")
+	ErrCompacted    = errors.New("instance compacted")
+	// ErrFutureInstance rejects proposals for an instance past the next free one.
+	ErrFutureInstance = errors.New("instance past the next free one")
+	// ErrNoQuorum and ErrPreempted say why consensus was not reached: too few
+	// acceptors answered in time, or they promised a higher ballot of another
+	// proposer.
+	ErrNoQuorum  = fmt.Errorf("%w: no quorum of acceptors answered", ErrNoConsensus)
+	ErrPreempted = fmt.Errorf("%w: preempted by a higher ballot", ErrNoConsensus)
 )
 
 type Server struct {
-	acceptor             *Acceptor
-	proposer             *Proposer
-	mqConn               *amqp.Connection
-	proposing            bool
-	acceptorPrepareChan  chan Prepare
-	acceptorPromiseChan  chan Promise
-	acceptorAcceptChan   chan Accept
-	acceptorAcceptedChan chan Accepted
-	proposerPrepareChan  chan Prepare
-	proposerPromiseChan  chan Promise
-	proposerAcceptChan   chan Accept
-	proposerAcceptedChan chan Accepted
-	mu                   sync.RWMutex
+	cfg        Config
+	acceptor   *Acceptor
+	proposer   *Proposer
+	learner    *Learner
+	metrics    *Metrics
+	tracer     *Tracer
+	auth       Authenticator
+	wire       *wireVersions
+	log        *Log
+	kv         *KVStore
+	membership *membership
+	reconfigMu sync.Mutex
+	keyed      keyedCalls
+	catchingUp atomic.Bool
+	transport  Transport
+	leadership *leadership
+	started    atomic.Bool
+	closing    atomic.Bool
+	closeOnce  sync.Once
+	// closed is closed once the server starts shutting down.
+	closed chan struct{}
+	// proposeMu lets proposals run concurrently but excludes them while campaigning.
+	proposeMu                sync.RWMutex
+	instanceMu               sync.Mutex
+	nextProposal             int
+	freeInstances            []int
+	batchRequests            chan *batchRequest
+	fast                     *fastRounds
+	acceptorPrepareChan      chan Prepare
+	acceptorPromiseChan      chan Promise
+	acceptorAcceptChan       chan Accept
+	acceptorAcceptedChan     chan Accepted
+	acceptorNackChan         chan Nack
+	acceptorAnyChan          chan Any
+	acceptorFastAcceptChan   chan FastAccept
+	acceptorFastAcceptedChan chan Accepted
+	proposerPrepareChan      chan Prepare
+	proposerAcceptChan       chan Accept
+	learnerAcceptedChan      chan Accepted
+	learnerFastAcceptedChan  chan Accepted
 }
 
-func NewServer(mqConn *amqp.Connection, serverID string, numberOfAccepters int) *Server {
+// NewServer creates a Paxos server that talks to its cluster over transport. The
+// acceptor state is persisted in cfg.DataDir and restored from it when the server
+// restarts. The server closes transport when it stops.
+func NewServer(transport Transport, cfg Config) (*Server, error) {
 	log.Println("Initializing server...")
-	server := &Server{mqConn: mqConn,
-		acceptorPrepareChan:  make(chan Prepare),
-		acceptorPromiseChan:  make(chan Promise),
-		acceptorAcceptChan:   make(chan Accept),
-		acceptorAcceptedChan: make(chan Accepted),
-		proposerPrepareChan:  make(chan Prepare),
-		proposerPromiseChan:  make(chan Promise),
-		proposerAcceptChan:   make(chan Accept),
-		proposerAcceptedChan: make(chan Accepted),
-	}
-
-	server.acceptor = NewAcceptor(
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	cfg = cfg.withDefaults()
+	if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory %s: %w", cfg.DataDir, err)
+	}
+	wal, err := OpenWAL(filepath.Join(cfg.DataDir, "acceptor.wal"))
+	if err != nil {
+		return nil, err
+	}
+
+	server := &Server{transport: transport,
+		cfg:  cfg,
+		auth: cfg.Authenticator,
+		// Every live server sends a heartbeat or an acknowledgement many times per
+		// election timeout, so one that stays silent for three is gone.
+		wire:                     newWireVersions(cfg.WireVersion, 3*cfg.ElectionTimeout),
+		log:                      NewLog(),
+		kv:                       NewKVStore(),
+		keyed:                    keyedCalls{calls: make(map[string]*keyedCall)},
+		metrics:                  NewMetrics(),
+		closed:                   make(chan struct{}),
+		batchRequests:            make(chan *batchRequest),
+		fast:                     &fastRounds{pending: make(map[int]time.Time)},
+		acceptorPrepareChan:      make(chan Prepare, roleChanSize),
+		acceptorPromiseChan:      make(chan Promise, roleChanSize),
+		acceptorAcceptChan:       make(chan Accept, roleChanSize),
+		acceptorAcceptedChan:     make(chan Accepted, roleChanSize),
+		acceptorNackChan:         make(chan Nack, roleChanSize),
+		acceptorAnyChan:          make(chan Any, roleChanSize),
+		acceptorFastAcceptChan:   make(chan FastAccept, roleChanSize),
+		acceptorFastAcceptedChan: make(chan Accepted, roleChanSize),
+		proposerPrepareChan:      make(chan Prepare, roleChanSize),
+		proposerAcceptChan:       make(chan Accept, roleChanSize),
+		learnerAcceptedChan:      make(chan Accepted, roleChanSize),
+		learnerFastAcceptedChan:  make(chan Accepted, roleChanSize),
+	}
+
+	if cfg.TraceFile != "" {
+		server.tracer, err = OpenTracer(cfg.ID, cfg.TraceFile)
+		if err != nil {
+			wal.Close()
+			return nil, err
+		}
+	}
+	server.membership = newMembership(server.log, cfg.initialConfiguration(), cfg.ReconfigWindow)
+	server.leadership = newLeadership(cfg, server.membership.current)
+	if err := server.loadSnapshot(); err != nil {
+		wal.Close()
+		return nil, err
+	}
+
+	server.acceptor, err = NewAcceptor(
+		cfg.ID,
+		wal,
 		server.acceptorPrepareChan,
 		server.acceptorPromiseChan,
 		server.acceptorAcceptChan,
 		server.acceptorAcceptedChan,
+		server.acceptorNackChan,
+		server.acceptorAnyChan,
+		server.acceptorFastAcceptChan,
+		server.acceptorFastAcceptedChan,
 	)
+	if err != nil {
+		wal.Close()
+		return nil, fmt.Errorf("failed to restore acceptor state: %w", err)
+	}
 	server.proposer = NewProposer(
-		serverID,
-		numberOfAccepters,
-		3,
+		cfg.ID,
+		cfg.retryPolicy(),
 		server.proposerPrepareChan,
-		server.proposerPromiseChan,
 		server.proposerAcceptChan,
-		server.proposerAcceptedChan,
 	)
 
+	server.proposer.metrics = server.metrics
+	server.registerGauges()
+	server.learner = NewLearner(server.log, server.membership.configFor, server.learnerAcceptedChan, server.learnerFastAcceptedChan)
+
 	log.Println("Server initialized.")
-	return server
+	return server, nil
 }
 
-func (s *Server) Serve() {
+// Handler returns the HTTP API of the server.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/proposals", s.proposalsHandler)
+	mux.HandleFunc("/porpose", s.proposeHandler)
+	mux.HandleFunc("/log", s.logHandler)
+	mux.HandleFunc("GET /metrics", s.metricsHandler)
+	mux.HandleFunc("GET /snapshot", s.snapshotHandler)
+	mux.HandleFunc("GET /decisions", s.decisionsHandler)
+	mux.HandleFunc("GET /decisions/{instance}", s.decisionHandler)
+	mux.HandleFunc("/leader", s.leaderHandler)
+	mux.HandleFunc("/kv/{key}", s.kvHandler)
+	mux.HandleFunc("POST /kv/{key}/cas", s.casHandler)
+	mux.HandleFunc("/members", s.membersHandler)
+	mux.HandleFunc("DELETE /members/{id}", s.membersHandler)
+	return mux
+}
+
+// Serve exposes the HTTP API on cfg.ListenAddr and runs the server until ctx is
+// done. It then stops accepting requests, waits for the ones in flight and shuts
+// the server down like Run.
+func (s *Server) Serve(ctx context.Context) error {
 	log.Println("Starting server...")
-	http.HandleFunc("/porpose", s.proposeHandler)
+	listener, err := net.Listen("tcp", s.cfg.ListenAddr)
+	if err != nil {
+		s.started.Store(true)
+		s.close()
+		return fmt.Errorf("failed to listen on %s: %w", s.cfg.ListenAddr, err)
+	}
+	httpServer := &http.Server{Handler: s.Handler()}
+	httpErr := make(chan error, 1)
 	go func() {
-		if err := http.ListenAndServe(":8080", nil); err != nil {
-			log.Fatalf("Error: while starting HTTP server: %s", err)
-		}
-		log.Println("HTTP server listening on :8080")
+		httpErr <- httpServer.Serve(listener)
 	}()
+	log.Printf("HTTP server listening on %s", listener.Addr())
 
-	ch, err := s.mqConn.Channel()
-	if err != nil {
-		log.Fatalf("Failed to open RabbitMQ channel: %v", err)
+	runCtx, stopRun := context.WithCancel(context.Background())
+	defer stopRun()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- s.Run(runCtx)
+	}()
+
+	select {
+	case <-ctx.Done():
+	case err = <-httpErr:
+		err = fmt.Errorf("HTTP server failed: %w", err)
 	}
-	defer ch.Close()
 
-	err = createFanout(ch, proposerQueueKey)
-	if err != nil {
-		log.Fatalf("Failed to create RabbitMQ proposer fanout: %v", err)
-		return
+	// Requests in flight still need the protocol running to finish, so the HTTP
+	// server stops before the rest of the server.
+	s.beginClose()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error: HTTP server did not shut down cleanly: %s", err)
+		httpServer.Close()
 	}
-	log.Println("Proposer fanout exchange created.")
+	stopRun()
+	return errors.Join(err, <-runErr)
+}
 
-	proposerQueue, err := getMessageQueue(ch, proposerQueueKey)
-	if err != nil {
-		log.Fatalf("Failed to open RabbitMQ proposer queue: %v", err)
-		return
+// Run starts the acceptor, the learner, the leader election and the key-value state
+// machine, and relays messages between the transport and the local roles until ctx
+// is done. It then stops taking new proposals, waits up to cfg.ShutdownTimeout for
+// the ones in flight, stops every background loop and closes the transport, the
+// write-ahead log and the trace. A server runs once; create a new one on the same
+// data directory to restart it.
+func (s *Server) Run(ctx context.Context) error {
+	if !s.started.CompareAndSwap(false, true) {
+		return ErrServerClosed
 	}
-	log.Println("Proposer queue initialized.")
 
-	err = createFanout(ch, acceptorQueueKey)
-	if err != nil {
-		log.Fatalf("Failed to create RabbitMQ acceptor fanout: %v", err)
-		return
+	loopCtx, stop := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, loop := range []func(context.Context){
+		s.acceptor.Start,
+		s.learner.Start,
+		s.runLeadership,
+		s.runStateMachine,
+		s.runSnapshots,
+		s.runBatcher,
+		s.runFastRounds,
+		s.relay,
+	} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			loop(loopCtx)
+		}()
 	}
-	log.Println("Acceptor fanout exchange created.")
 
-	acceptorQueue, err := getMessageQueue(ch, acceptorQueueKey)
-	if err != nil {
-		log.Fatalf("Failed to open RabbitMQ acceptor queue: %v", err)
-		return
+	<-ctx.Done()
+	log.Println("Shutting down server...")
+	s.beginClose()
+	s.drainProposals()
+	stop()
+	wg.Wait()
+	err := s.close()
+	log.Println("Server stopped.")
+	return err
+}
+
+// beginClose makes new proposals fail with ErrServerClosed.
+func (s *Server) beginClose() {
+	s.closeOnce.Do(func() {
+		s.closing.Store(true)
+		close(s.closed)
+	})
+}
+
+// untilClosed returns a context that is also cancelled when the server starts
+// shutting down.
+func (s *Server) untilClosed(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-s.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// drainProposals waits until the proposals in flight finished or the shutdown
+// timeout passed.
+func (s *Server) drainProposals() {
+	drained := make(chan struct{})
+	go func() {
+		s.proposeMu.Lock()
+		s.proposeMu.Unlock()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(s.cfg.ShutdownTimeout):
+		log.Println("Info: Shutting down with proposals still in flight.")
 	}
+}
 
-	log.Println("Acceptor queue initialized.")
-	go s.acceptor.Start()
+// close releases the transport, the write-ahead log and the trace.
+func (s *Server) close() error {
+	s.beginClose()
+	return errors.Join(s.transport.Close(), s.acceptor.Close(), s.tracer.Close())
+}
 
+// relay moves messages between the transport and the local acceptor, proposer and
+// learner until ctx is done.
+func (s *Server) relay(ctx context.Context) {
 	for {
 		select {
+		case <-ctx.Done():
+			return
+
 		case prepare := <-s.proposerPrepareChan:
 			log.Printf("Publishing PREPARE message: %+v", prepare)
-			body, err := json.Marshal(prepare)
-			if err != nil {
-				log.Printf("Error marshaling PREPARE message: %v", err)
-				continue
-			}
-			message := QueueMessage{
-				Type: prepareMessageType,
-				Body: body,
-			}
-			publish(ch, acceptorQueueKey, message)
+			s.broadcast(prepareMessageType, prepare, s.transport.BroadcastToAcceptors)
 
 		case promise := <-s.acceptorPromiseChan:
 			log.Printf("Publishing PROMISE message: %+v", promise)
-			body, err := json.Marshal(promise)
-			if err != nil {
-				log.Printf("Error marshaling PROMISE message: %v", err)
-				continue
-			}
-			message := QueueMessage{
-				Type: promiseMessageType,
-				Body: body,
-			}
-			publish(ch, proposerQueueKey, message)
+			s.broadcast(promiseMessageType, promise, s.transport.BroadcastToProposers)
 
 		case accept := <-s.proposerAcceptChan:
 			log.Printf("Publishing ACCEPT message: %+v", accept)
-			body, err := json.Marshal(accept)
-			if err != nil {
-				log.Printf("Error marshaling ACCEPT message: %v", err)
-				continue
-			}
-			message := QueueMessage{
-				Type: acceptMessageType,
-				Body: body,
-			}
-			publish(ch, acceptorQueueKey, message)
+			s.broadcast(acceptMessageType, accept, s.transport.BroadcastToAcceptors)
 
 		case accepted := <-s.acceptorAcceptedChan:
 			log.Printf("Publishing ACCEPTED message: %+v", accepted)
-			body, err := json.Marshal(accepted)
-			if err != nil {
-				log.Printf("Error marshaling ACCEPTED message: %v", err)
-				continue
-			}
-			message := QueueMessage{
-				Type: acceptedMessageType,
-				Body: body,
-			}
-			publish(ch, proposerQueueKey, message)
+			s.broadcast(acceptedMessageType, accepted, s.transport.BroadcastToProposers)
+
+		case accepted := <-s.acceptorFastAcceptedChan:
+			log.Printf("Publishing FAST_ACCEPTED message: %+v", accepted)
+			s.broadcast(fastAcceptedMessageType, accepted, s.transport.BroadcastToProposers)
 
-		case messageForProposer := <-proposerQueue:
-			s.mu.RLock()
-			if !s.proposing {
-				s.mu.RUnlock()
-				log.Println("Skipping message for proposer, not proposing.")
+		case nack := <-s.acceptorNackChan:
+			log.Printf("Publishing NACK message: %+v", nack)
+			s.metrics.ballotRejected(nack.Phase)
+			s.broadcast(nackMessageType, nack, s.transport.BroadcastToProposers)
+
+		case envelope := <-s.transport.Receive():
+			if reason, err := s.authenticate(envelope.Message); err != nil {
+				log.Printf("Error: Dropping %s message from %q: %s", envelope.Message.Type, envelope.Message.Sender, err)
+				s.metrics.messageRejected(reason)
 				continue
 			}
-			log.Println("Handling message for proposer.")
-			s.handleMessageForProposer(messageForProposer.Body)
-			s.mu.RUnlock()
-
-		case messageForAcceptor := <-acceptorQueue:
-			log.Println("Handling message for acceptor.")
-			s.handleMessageForAcceptor(messageForAcceptor.Body)
+			s.wire.observe(envelope.Message, time.Now())
+			s.metrics.messageReceived(envelope.Message.Type)
+			s.tracer.receive(envelope.Message)
+			switch envelope.Role {
+			case ProposerRole:
+				log.Println("Handling message for proposer.")
+				s.handleMessageForProposer(envelope.Message)
+
+			case AcceptorRole:
+				log.Println("Handling message for acceptor.")
+				s.handleMessageForAcceptor(envelope.Message)
+			}
 		}
 	}
 }
 
-func (s *Server) handleMessageForProposer(msgByte []byte) {
+// handleMessageForProposer routes responses to the in-flight round that sent the
+// request they answer. Responses to rounds that already finished are dropped.
+func (s *Server) handleMessageForProposer(message QueueMessage) {
 	log.Println("Processing message for proposer...")
-	var message QueueMessage
-	if err := json.Unmarshal(msgByte, &message); err != nil {
-		log.Printf("Error: Failed to unmarshal message: %s", err)
-		return
-	}
 
 	switch message.Type {
+	case heartbeatAckMessageType:
+		var ack HeartbeatAck
+		if err := decodeBody(message, &ack); err != nil {
+			log.Printf("Error: Failed to unmarshal HeartbeatAck: %s", err)
+			return
+		}
+		s.leadership.observeAck(ack, time.Now())
+
 	case promiseMessageType:
 		var promise Promise
-		if err := json.Unmarshal(message.Body, &promise); err != nil {
+		if err := decodeBody(message, &promise); err != nil {
 			log.Printf("Error: Failed to unmarshal Promise: %s", err)
 			return
 		}
 		log.Printf("Received PROMISE message: %+v", promise)
-		s.proposerPromiseChan <- promise
+		if !s.proposer.HandlePromise(promise) {
+			log.Printf("Info: Skipping PROMISE for request %s, not in flight", promise.RequestID)
+		}
 
 	case acceptedMessageType:
 		var accepted Accepted
-		if err := json.Unmarshal(message.Body, &accepted); err != nil {
+		if err := decodeBody(message, &accepted); err != nil {
 			log.Printf("Error: Failed to unmarshal Accepted: %s", err)
 			return
 		}
 		log.Printf("Received ACCEPTED message: %+v", accepted)
-		s.proposerAcceptedChan <- accepted
+		// Every server learns from ACCEPTED, whether or not it is proposing.
+		select {
+		case s.learnerAcceptedChan <- accepted:
+		default:
+			log.Printf("Info: Dropping ACCEPTED message for learner, queue full")
+		}
+		s.proposer.HandleAccepted(accepted)
+
+	case fastAcceptedMessageType:
+		var accepted Accepted
+		if err := decodeBody(message, &accepted); err != nil {
+			log.Printf("Error: Failed to unmarshal Accepted: %s", err)
+			return
+		}
+		log.Printf("Received FAST_ACCEPTED message: %+v", accepted)
+		s.fast.note(accepted.Instance, time.Now())
+		select {
+		case s.learnerFastAcceptedChan <- accepted:
+		default:
+			log.Printf("Info: Dropping FAST_ACCEPTED message for learner, queue full")
+		}
+
+	case nackMessageType:
+		var nack Nack
+		if err := decodeBody(message, &nack); err != nil {
+			log.Printf("Error: Failed to unmarshal Nack: %s", err)
+			return
+		}
+		log.Printf("Received NACK message: %+v", nack)
+		s.proposer.HandleNack(nack)
 
 	default:
 		log.Printf("Info: Unknown message type received: %s", message.Type)
 	}
 }
 
-func (s *Server) handleMessageForAcceptor(msgByte []byte) {
+func (s *Server) handleMessageForAcceptor(message QueueMessage) {
 	log.Println("Processing message for acceptor...")
-	var message QueueMessage
-	if err := json.Unmarshal(msgByte, &message); err != nil {
-		log.Printf("Error: Failed to unmarshal message: %s", err)
-		return
-	}
 
 	log.Printf("Received message for acceptor: %+v", message)
 	switch message.Type {
 	case prepareMessageType:
 		var prepare Prepare
-		if err := json.Unmarshal(message.Body, &prepare); err != nil {
+		if err := decodeBody(message, &prepare); err != nil {
 			log.Printf("Error: Failed to unmarshal Prepare: %s", err)
 			return
 		}
+		if !s.leadership.allowPrepare(prepare.ProposalNumber.ProposerID, time.Now()) {
+			log.Printf("Info: Ignoring PREPARE from %s while leader lease is granted", prepare.ProposalNumber.ProposerID)
+			return
+		}
 		log.Printf("Processing PREPARE message: %+v", prepare)
-		s.acceptorPrepareChan <- prepare
+		select {
+		case s.acceptorPrepareChan <- prepare:
+		default:
+			log.Printf("Info: Dropping PREPARE message, queue full")
+		}
 
 	case acceptMessageType:
 		var accept Accept
-		if err := json.Unmarshal(message.Body, &accept); err != nil {
+		if err := decodeBody(message, &accept); err != nil {
 			log.Printf("Error: Failed to unmarshal Accept: %s", err)
 			return
 		}
 		log.Printf("Processing ACCEPT message: %+v", accept)
-		s.acceptorAcceptChan <- accept
+		select {
+		case s.acceptorAcceptChan <- accept:
+		default:
+			log.Printf("Info: Dropping ACCEPT message, queue full")
+		}
+
+	case anyMessageType:
+		var open Any
+		if err := decodeBody(message, &open); err != nil {
+			log.Printf("Error: Failed to unmarshal Any: %s", err)
+			return
+		}
+		if !s.leadership.allowPrepare(open.ProposalNumber.ProposerID, time.Now()) {
+			log.Printf("Info: Ignoring ANY from %s while leader lease is granted", open.ProposalNumber.ProposerID)
+			return
+		}
+		select {
+		case s.acceptorAnyChan <- open:
+		default:
+			log.Printf("Info: Dropping ANY message, queue full")
+		}
+
+	case fastAcceptMessageType:
+		var fa FastAccept
+		if err := decodeBody(message, &fa); err != nil {
+			log.Printf("Error: Failed to unmarshal FastAccept: %s", err)
+			return
+		}
+		// The coordinator recovers instances clients proposed for that stall, even
+		// those no acceptor accepted anything for.
+		s.fast.note(fa.Instance, time.Now())
+		select {
+		case s.acceptorFastAcceptChan <- fa:
+		default:
+			log.Printf("Info: Dropping FAST_ACCEPT message, queue full")
+		}
+
+	case decideMessageType:
+		var decide Decide
+		if err := decodeBody(message, &decide); err != nil {
+			log.Printf("Error: Failed to unmarshal Decide: %s", err)
+			return
+		}
+		s.onDecide(decide)
+
+	case heartbeatMessageType:
+		var hb Heartbeat
+		if err := decodeBody(message, &hb); err != nil {
+			log.Printf("Error: Failed to unmarshal Heartbeat: %s", err)
+			return
+		}
+		s.onHeartbeat(hb)
 
 	default:
 		log.Printf("Info: Unknown message type received: %s", message.Type)
 	}
 }
 
-func publish(ch *amqp.Channel, queueKey string, message QueueMessage) {
-	log.Printf("Publishing message to %s: %+v", queueKey, message)
-	messageBody, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Error: Failed to marshal message: %v", err)
+// proposeHandler serves /porpose, the original plain-text API kept for existing
+// clients. New clients use /v1/proposals.
+func (s *Server) proposeHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received propose request.")
+	if !s.leadership.isLeader() {
+		s.forwardToLeader(w, r)
 		return
 	}
 
-	err = ch.Publish(
-		queueKey,
-		"",
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        messageBody,
-		},
-	)
-	if err != nil {
-		log.Printf("Failed to publish message: %v", err)
-	} else {
-		log.Printf("Message published to %s", queueKey)
+	var body struct {
+		Message string
 	}
-}
 
-func createFanout(ch *amqp.Channel, exchangeName string) error {
-	log.Printf("Creating fanout exchange: %s", exchangeName)
-	err := ch.ExchangeDeclare(
-		exchangeName,
-		"fanout",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		log.Printf("Error: while decoding propose request: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid request payload")
+		return
+	}
+
+	log.Printf("Proposing value: %s", body.Message)
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ProposeTimeout)
+	defer cancel()
+
+	var pos position
+	var err error
+	if key := r.Header.Get(IdempotencyHeader); key != "" {
+		pos, err = s.proposeOnce(ctx, key, body.Message)
+	} else {
+		pos, err = s.propose(ctx, body.Message)
+	}
+	instance := pos.instance
+	if errors.Is(err, ErrNotLeader) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "Leadership lost")
+		return
+	}
+	if errors.Is(err, ErrServerClosed) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "Server shutting down")
+		return
+	}
 	if err != nil {
-		log.Printf("Failed to declare exchange %s: %v", exchangeName, err)
+		log.Println("Consensus not reached.")
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, "Consensus not reached")
+		return
 	}
-	return err
+
+	log.Printf("Consensus reached on instance %d: %v", instance, body.Message)
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Consensus reached on instance %d: %v", instance, body.Message)
 }
 
-func getMessageQueue(ch *amqp.Channel, queueKey string) (<-chan amqp.Delivery, error) {
-	log.Printf("Initializing queue for key: %s", queueKey)
-	q, err := ch.QueueDeclare(
-		"",
-		false,
-		true,
-		true,
-		false,
-		nil,
-	)
-	if err != nil {
-		log.Printf("Failed to declare queue: %v", err)
-		return nil, err
+// Propose gets value chosen in the log and returns the instance it was decided in,
+// alone or in a batch with other values. Only the leader proposes; other servers
+// return ErrNotLeader.
+func (s *Server) Propose(ctx context.Context, value interface{}) (int, error) {
+	pos, err := s.propose(ctx, value)
+	return pos.instance, err
+}
+
+// proposeEntry gets value chosen for the next free log instance and returns where
+// it was chosen. Concurrent calls propose for different instances in parallel, as
+// far as the reconfiguration window allows.
+func (s *Server) proposeEntry(ctx context.Context, value interface{}) (position, error) {
+	if s.cfg.FastRounds {
+		return s.proposeFast(ctx, value)
+	}
+	s.proposeMu.RLock()
+	defer s.proposeMu.RUnlock()
+	if s.closing.Load() {
+		return position{}, ErrServerClosed
+	}
+	if !s.leadership.isLeader() {
+		return position{}, ErrNotLeader
+	}
+	start := time.Now()
+	result := "error"
+	defer func() {
+		s.metrics.proposed(result, time.Since(start))
+		log.Println("Proposing completed.")
+	}()
+
+	// Another proposer may already own the instance, in which case its value is
+	// committed there and ours moves on to another instance.
+	for {
+		instance := s.reserveInstance()
+		config, err := s.configFor(ctx, instance)
+		if err != nil {
+			s.releaseInstance(instance)
+			return position{}, err
+		}
+		chosen, ballot, err := s.proposer.Propose(ctx, instance, value, s.acceptor.GetBallotNumber(), config)
+		if err != nil {
+			s.releaseInstance(instance)
+			result = "no_consensus"
+			return position{}, err
+		}
+
+		s.commit(instance, chosen)
+		if core.SameValue(chosen, value) {
+			result = "decided"
+			return position{instance: instance, ballot: ballot}, nil
+		}
+		s.metrics.instanceRetried("classic")
+		log.Printf("Instance %d already decided with %v, retrying on another instance.", instance, chosen)
 	}
+}
 
-	err = ch.QueueBind(
-		q.Name,
-		"",
-		queueKey,
-		false,
-		nil,
-	)
-	if err != nil {
-		log.Printf("Failed to bind queue: %v", err)
-		return nil, err
+// reserveInstance returns an undecided instance no other local proposal is working
+// on. Instances released by failed proposals are reused first, so the log has no
+// gaps once proposals succeed again.
+func (s *Server) reserveInstance() int {
+	s.instanceMu.Lock()
+	defer s.instanceMu.Unlock()
+
+	for len(s.freeInstances) > 0 {
+		slices.Sort(s.freeInstances)
+		instance := s.freeInstances[0]
+		s.freeInstances = s.freeInstances[1:]
+		if _, ok := s.log.Get(instance); !ok && instance >= s.log.NextInstance() {
+			return instance
+		}
 	}
+	instance := max(s.nextProposal, s.log.NextInstance())
+	s.nextProposal = instance + 1
+	return instance
+}
 
-	msgs, err := ch.Consume(
-		q.Name,
-		"",
-		true,
-		true,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		log.Printf("Failed to start consuming messages: %v", err)
-		return nil, err
+func (s *Server) releaseInstance(instance int) {
+	s.instanceMu.Lock()
+	defer s.instanceMu.Unlock()
+	s.freeInstances = append(s.freeInstances, instance)
+}
+
+// claimInstance reserves instance for a proposal targeting it and reports whether
+// it did; it does not while another local proposal works on instance. It fails
+// with ErrFutureInstance past the next free instance, which would leave a gap in
+// the log.
+func (s *Server) claimInstance(instance int) (bool, error) {
+	s.instanceMu.Lock()
+	defer s.instanceMu.Unlock()
+
+	if i := slices.Index(s.freeInstances, instance); i >= 0 {
+		s.freeInstances = slices.Delete(s.freeInstances, i, i+1)
+		return true, nil
+	}
+	next := max(s.nextProposal, s.log.NextInstance())
+	switch {
+	case instance > next:
+		return false, fmt.Errorf("%w: instance %d, next free instance %d", ErrFutureInstance, instance, next)
+	case instance == next:
+		s.nextProposal = instance + 1
+		return true, nil
 	}
-	return msgs, nil
+	return false, nil
 }
 
-func (s *Server) proposeHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("Received propose request.")
-	var body struct {
-		Message string
+// configFor waits until the configuration of instance is known, which limits the
+// proposals in flight to the reconfiguration window.
+func (s *Server) configFor(ctx context.Context, instance int) (Configuration, error) {
+	for {
+		changed := s.log.Changed()
+		config, err := s.membership.configFor(instance)
+		if err == nil {
+			return config, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return Configuration{}, fmt.Errorf("%w: %w", err, ctx.Err())
+		}
 	}
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		log.Printf("Error: while decoding propose request: %s", err)
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Invalid request payload")
+// Log returns the log of values decided through this server.
+func (s *Server) Log() *Log {
+	return s.log
+}
+
+// logHandler serves the log entries from the from parameter on, up to the optional
+// to parameter. It serves them locally only while this server holds the leader
+// lease, so a read never misses an entry that was already decided.
+func (s *Server) logHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.leadership.isLeader() {
+		s.forwardToLeader(w, r)
+		return
+	}
+	if !s.leadership.hasLease(time.Now()) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "Leader lease not held")
 		return
 	}
 
-	log.Printf("Proposing value: %s", body.Message)
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	s.mu.Lock()
-	s.proposing = true
-	s.mu.Unlock()
-	defer func(cancel func()) {
-		cancel()
-		s.mu.Lock()
-		s.proposing = false
-		s.mu.Unlock()
-		log.Println("Proposing completed.")
-	}(cancel)
+	from := s.log.FirstInstance()
+	if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+		var err error
+		from, err = strconv.Atoi(fromStr)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid from parameter")
+			return
+		}
+	}
+	to := 0
+	if toStr := r.URL.Query().Get("to"); toStr != "" {
+		var err error
+		to, err = strconv.Atoi(toStr)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid to parameter")
+			return
+		}
+	}
+	if from < s.log.FirstInstance() {
+		w.WriteHeader(http.StatusGone)
+		fmt.Fprintf(w, "Log compacted up to instance %d, fetch /snapshot", s.log.FirstInstance()-1)
+		return
+	}
 
-	value := s.proposer.Propose(ctx, body.Message, s.acceptor.GetBallotNumber())
-	if value != nil {
-		log.Printf("Consensus reached: %v", value)
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, "Consensus reached: %v", value)
-	} else {
-		log.Println("Consensus not reached.")
-		w.WriteHeader(http.StatusConflict)
-		fmt.Fprint(w, "Consensus not reached")
+	w.Header().Set("Content-Type", "application/json")
+	entries := s.log.Entries(from)
+	if to > 0 {
+		entries = slices.DeleteFunc(entries, func(entry LogEntry) bool { return entry.Instance > to })
+	}
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		log.Printf("Error: while encoding log entries: %s", err)
 	}
 }
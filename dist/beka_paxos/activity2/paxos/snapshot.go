@@ -0,0 +1,185 @@
+package paxos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const snapshotFile = "snapshot.json"
+
+var snapshotClient = &http.Client{Timeout: 30 * time.Second}
+
+// errLogCompacted is returned when the requested log entries are only available in
+// a snapshot anymore.
+var errLogCompacted = errors.New("log entries compacted")
+
+// Snapshot is the state of the key-value store and the membership after applying
+// every instance up to Index.
+type Snapshot struct {
+	Index          int                   `json:"index"`
+	Data           map[string]string     `json:"data"`
+	Commands       []appliedCommand      `json:"commands,omitempty"`
+	Configurations []configurationChange `json:"configurations"`
+}
+
+func (s *Server) snapshotPath() string {
+	return filepath.Join(s.cfg.DataDir, snapshotFile)
+}
+
+// loadSnapshot restores the last snapshot saved in the data directory, if any.
+func (s *Server) loadSnapshot() error {
+	data, err := os.ReadFile(s.snapshotPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	s.installSnapshot(snap)
+	log.Printf("Restored snapshot up to instance %d", snap.Index)
+	return nil
+}
+
+// saveSnapshot durably replaces the saved snapshot with snap.
+func (s *Server) saveSnapshot(snap Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.snapshotPath() + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.snapshotPath()); err != nil {
+		return err
+	}
+	return syncDir(s.cfg.DataDir)
+}
+
+// installSnapshot replaces the local state with snap unless it is already ahead.
+func (s *Server) installSnapshot(snap Snapshot) {
+	s.kv.Restore(snap.Index, snap.Data, snap.Commands)
+	s.membership.restore(snap.Index, snap.Configurations)
+	s.log.Restore(snap.Index)
+}
+
+// runSnapshots periodically snapshots the state machine once enough entries were
+// applied since the last snapshot.
+func (s *Server) runSnapshots(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.SnapshotInterval)
+	defer ticker.Stop()
+
+	last := s.log.FirstInstance() - 1
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if s.kv.Applied()-last < s.cfg.SnapshotThreshold {
+			continue
+		}
+		index, err := s.takeSnapshot()
+		if err != nil {
+			log.Printf("Error: Failed to take snapshot: %s", err)
+			continue
+		}
+		last = index
+	}
+}
+
+// takeSnapshot saves the applied state and compacts the log and the acceptor up to
+// SnapshotRetain entries before it. The retained entries let lagging servers catch
+// up without transferring the whole snapshot.
+func (s *Server) takeSnapshot() (int, error) {
+	index, data, commands := s.kv.Snapshot()
+	snap := Snapshot{
+		Index:          index,
+		Data:           data,
+		Commands:       commands,
+		Configurations: s.membership.snapshot(index),
+	}
+	if err := s.saveSnapshot(snap); err != nil {
+		return 0, err
+	}
+
+	compactTo := index - s.cfg.SnapshotRetain
+	if compactTo > 0 {
+		s.log.Truncate(compactTo)
+		if err := s.acceptor.Compact(compactTo); err != nil {
+			return 0, fmt.Errorf("failed to compact acceptor: %w", err)
+		}
+	}
+	log.Printf("Info: Took snapshot up to instance %d, compacted up to instance %d", index, max(compactTo, 0))
+	return index, nil
+}
+
+// snapshotHandler serves the saved snapshot to servers that fell behind the
+// compacted log.
+func (s *Server) snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := os.ReadFile(s.snapshotPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "No snapshot")
+		return
+	}
+	if err != nil {
+		log.Printf("Error: while reading snapshot: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func fetchSnapshot(address string) (Snapshot, error) {
+	u := url.URL{Scheme: "http", Host: address, Path: "/snapshot"}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	req.Header.Set(forwardedHeader, "catch-up")
+
+	resp, err := snapshotClient.Do(req)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Snapshot{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var snap Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		return Snapshot{}, err
+	}
+	return snap, nil
+}
@@ -0,0 +1,165 @@
+package paxos
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	TraceSend    = "send"
+	TraceReceive = "receive"
+	TraceDecide  = "decide"
+)
+
+// TraceEvent is one line of a protocol trace. Peer is the sender of a received
+// message; sent messages are broadcasts. Clock is a Lamport clock carried on
+// every message, so events of several nodes can be merged into an order that
+// respects causality even when their wall clocks disagree.
+type TraceEvent struct {
+	Time      time.Time       `json:"time"`
+	Clock     uint64          `json:"clock"`
+	Node      string          `json:"node"`
+	Event     string          `json:"event"`
+	Type      string          `json:"type,omitempty"`
+	Peer      string          `json:"peer,omitempty"`
+	Instance  int             `json:"instance,omitempty"`
+	Ballot    *ProposalNumber `json:"ballot,omitempty"`
+	Promised  *ProposalNumber `json:"promised,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+	Value     interface{}     `json:"value,omitempty"`
+}
+
+// Tracer writes the trace events of one node as JSON lines. A nil *Tracer traces
+// nothing and keeps no clock, so messages then carry clock 0.
+type Tracer struct {
+	mu    sync.Mutex
+	node  string
+	clock uint64
+	w     io.Writer
+	file  *os.File
+}
+
+func NewTracer(node string, w io.Writer) *Tracer {
+	return &Tracer{node: node, w: w}
+}
+
+// OpenTracer appends the trace of node to the file at path.
+func OpenTracer(node, path string) (*Tracer, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file %s: %w", path, err)
+	}
+	t := NewTracer(node, file)
+	t.file = file
+	return t, nil
+}
+
+func (t *Tracer) Close() error {
+	if t == nil || t.file == nil {
+		return nil
+	}
+	return t.file.Close()
+}
+
+// send records the broadcast of message and stamps it with the next clock value.
+func (t *Tracer) send(message *QueueMessage) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.clock++
+	message.Clock = t.clock
+	t.write(TraceSend, *message, "")
+}
+
+// receive records message and advances the clock past the sender's.
+func (t *Tracer) receive(message QueueMessage) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.clock = max(t.clock, message.Clock) + 1
+	t.write(TraceReceive, message, message.Sender)
+}
+
+// decide records that value was chosen for instance.
+func (t *Tracer) decide(instance int, value interface{}) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.clock++
+	t.writeEvent(TraceEvent{Event: TraceDecide, Instance: instance, Value: value})
+}
+
+func (t *Tracer) write(event string, message QueueMessage, peer string) {
+	// Every message type names its instance and ballot the same way, so one
+	// struct picks them out of any body.
+	var fields struct {
+		Instance       int             `json:"instance"`
+		ProposalNumber *ProposalNumber `json:"proposal_number"`
+		PromisedNumber *ProposalNumber `json:"promised_number"`
+		RequestID      string          `json:"request_id"`
+		Value          interface{}     `json:"value"`
+	}
+	if body := newBody(message.Type); body != nil && decodeBody(message, body) == nil {
+		if data, err := json.Marshal(body); err == nil {
+			json.Unmarshal(data, &fields)
+		}
+	}
+
+	t.writeEvent(TraceEvent{
+		Event:     event,
+		Type:      message.Type,
+		Peer:      peer,
+		Instance:  fields.Instance,
+		Ballot:    fields.ProposalNumber,
+		Promised:  fields.PromisedNumber,
+		RequestID: fields.RequestID,
+		Value:     fields.Value,
+	})
+}
+
+func (t *Tracer) writeEvent(event TraceEvent) {
+	event.Time = time.Now()
+	event.Clock = t.clock
+	event.Node = t.node
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error: Failed to encode trace event: %s", err)
+		return
+	}
+	if _, err := t.w.Write(append(line, '\n')); err != nil {
+		log.Printf("Error: Failed to write trace event: %s", err)
+	}
+}
+
+// ReadTrace decodes the JSON-lines trace in r.
+func ReadTrace(r io.Reader) ([]TraceEvent, error) {
+	var events []TraceEvent
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event TraceEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
@@ -0,0 +1,87 @@
+package paxos
+
+import "sync"
+
+type Role string
+
+const (
+	AcceptorRole Role = acceptorQueueKey
+	ProposerRole Role = proposerQueueKey
+)
+
+// Envelope is a message received from the cluster for one of the local roles.
+type Envelope struct {
+	Role    Role         `json:"role"`
+	Message QueueMessage `json:"message"`
+}
+
+// Transport carries messages between the servers of a cluster. Broadcasts reach every
+// server, including the sender, the way a fanout exchange does.
+type Transport interface {
+	BroadcastToAcceptors(message QueueMessage) error
+	BroadcastToProposers(message QueueMessage) error
+	Receive() <-chan Envelope
+	Close() error
+}
+
+// mailbox is an unbounded queue in front of a receive channel, so that a server
+// broadcasting to itself never blocks on its own inbox.
+type mailbox struct {
+	mu      sync.Mutex
+	pending []Envelope
+	notify  chan struct{}
+	out     chan Envelope
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newMailbox() *mailbox {
+	m := &mailbox{
+		notify: make(chan struct{}, 1),
+		out:    make(chan Envelope),
+		done:   make(chan struct{}),
+	}
+	go m.pump()
+	return m
+}
+
+func (m *mailbox) put(envelope Envelope) {
+	m.mu.Lock()
+	m.pending = append(m.pending, envelope)
+	m.mu.Unlock()
+
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (m *mailbox) pump() {
+	for {
+		m.mu.Lock()
+		if len(m.pending) == 0 {
+			m.mu.Unlock()
+			select {
+			case <-m.notify:
+				continue
+			case <-m.done:
+				return
+			}
+		}
+		next := m.pending[0]
+		m.mu.Unlock()
+
+		select {
+		case m.out <- next:
+			m.mu.Lock()
+			m.pending = m.pending[1:]
+			m.mu.Unlock()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+func (m *mailbox) close() {
+	m.once.Do(func() { close(m.done) })
+}
@@ -0,0 +1,182 @@
+package paxos
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	acceptorQueueKey = "FOR_ACCEPTORS"
+	proposerQueueKey = "FOR_PROPOSERS"
+)
+
+// AMQPTransport broadcasts through the FOR_ACCEPTORS and FOR_PROPOSERS fanout
+// exchanges of a RabbitMQ broker.
+type AMQPTransport struct {
+	conn  *amqp.Connection
+	once  sync.Once
+	ch    *amqp.Channel
+	mu    sync.Mutex
+	inbox *mailbox
+}
+
+// NewAMQPTransport declares the fanout exchanges on mqConn and starts consuming from
+// an exclusive queue bound to each of them. The transport owns mqConn and closes it
+// on Close or when setting up fails.
+func NewAMQPTransport(mqConn *amqp.Connection) (*AMQPTransport, error) {
+	ch, err := mqConn.Channel()
+	if err != nil {
+		mqConn.Close()
+		return nil, fmt.Errorf("failed to open RabbitMQ channel: %w", err)
+	}
+	t := &AMQPTransport{conn: mqConn, ch: ch, inbox: newMailbox()}
+
+	for _, role := range []Role{ProposerRole, AcceptorRole} {
+		if err := createFanout(ch, string(role)); err != nil {
+			t.Close()
+			return nil, fmt.Errorf("failed to create RabbitMQ fanout %s: %w", role, err)
+		}
+		queue, err := getMessageQueue(ch, string(role))
+		if err != nil {
+			t.Close()
+			return nil, fmt.Errorf("failed to open RabbitMQ queue %s: %w", role, err)
+		}
+		log.Printf("Queue for %s initialized.", role)
+		go t.consume(role, queue)
+	}
+
+	return t, nil
+}
+
+func (t *AMQPTransport) BroadcastToAcceptors(message QueueMessage) error {
+	return t.publish(acceptorQueueKey, message)
+}
+
+func (t *AMQPTransport) BroadcastToProposers(message QueueMessage) error {
+	return t.publish(proposerQueueKey, message)
+}
+
+func (t *AMQPTransport) Receive() <-chan Envelope {
+	return t.inbox.out
+}
+
+// Close closes the channel and the connection the transport was created with.
+func (t *AMQPTransport) Close() error {
+	var err error
+	t.once.Do(func() {
+		t.inbox.close()
+		err = errors.Join(t.ch.Close(), t.conn.Close())
+	})
+	return err
+}
+
+func (t *AMQPTransport) consume(role Role, deliveries <-chan amqp.Delivery) {
+	for delivery := range deliveries {
+		message, err := DecodeMessage(delivery.Body)
+		if err != nil {
+			log.Printf("Error: Failed to unmarshal message: %s", err)
+			continue
+		}
+		t.inbox.put(Envelope{Role: role, Message: message})
+	}
+}
+
+func (t *AMQPTransport) publish(queueKey string, message QueueMessage) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return publish(t.ch, queueKey, message)
+}
+
+func publish(ch *amqp.Channel, queueKey string, message QueueMessage) error {
+	log.Printf("Publishing message to %s: %+v", queueKey, message)
+	messageBody, err := EncodeMessage(message)
+	if err != nil {
+		log.Printf("Error: Failed to marshal message: %v", err)
+		return err
+	}
+	contentType := "application/json"
+	if message.Version > JSONWireVersion {
+		contentType = "application/octet-stream"
+	}
+
+	err = ch.Publish(
+		queueKey,
+		"",
+		false,
+		false,
+		amqp.Publishing{
+			ContentType: contentType,
+			Body:        messageBody,
+		},
+	)
+	if err != nil {
+		log.Printf("Failed to publish message: %v", err)
+		return err
+	}
+	log.Printf("Message published to %s", queueKey)
+	return nil
+}
+
+func createFanout(ch *amqp.Channel, exchangeName string) error {
+	log.Printf("Creating fanout exchange: %s", exchangeName)
+	err := ch.ExchangeDeclare(
+		exchangeName,
+		"fanout",
+		true,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		log.Printf("Failed to declare exchange %s: %v", exchangeName, err)
+	}
+	return err
+}
+
+func getMessageQueue(ch *amqp.Channel, queueKey string) (<-chan amqp.Delivery, error) {
+	log.Printf("Initializing queue for key: %s", queueKey)
+	q, err := ch.QueueDeclare(
+		"",
+		false,
+		true,
+		true,
+		false,
+		nil,
+	)
+	if err != nil {
+		log.Printf("Failed to declare queue: %v", err)
+		return nil, err
+	}
+
+	err = ch.QueueBind(
+		q.Name,
+		"",
+		queueKey,
+		false,
+		nil,
+	)
+	if err != nil {
+		log.Printf("Failed to bind queue: %v", err)
+		return nil, err
+	}
+
+	msgs, err := ch.Consume(
+		q.Name,
+		"",
+		true,
+		true,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		log.Printf("Failed to start consuming messages: %v", err)
+		return nil, err
+	}
+	return msgs, nil
+}
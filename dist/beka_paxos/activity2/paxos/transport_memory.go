@@ -0,0 +1,88 @@
+package paxos
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrTransportClosed = errors.New("transport closed")
+
+// MemoryBus connects in-process transports, standing in for the broker when a whole
+// cluster runs inside one process.
+type MemoryBus struct {
+	mu         sync.RWMutex
+	transports []*MemoryTransport
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+// Join attaches a new transport to the bus.
+func (b *MemoryBus) Join() *MemoryTransport {
+	t := &MemoryTransport{bus: b, inbox: newMailbox()}
+	b.mu.Lock()
+	b.transports = append(b.transports, t)
+	b.mu.Unlock()
+	return t
+}
+
+func (b *MemoryBus) broadcast(envelope Envelope) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, t := range b.transports {
+		t.inbox.put(envelope)
+	}
+}
+
+func (b *MemoryBus) leave(t *MemoryTransport) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, joined := range b.transports {
+		if joined == t {
+			b.transports = append(b.transports[:i], b.transports[i+1:]...)
+			return
+		}
+	}
+}
+
+type MemoryTransport struct {
+	bus    *MemoryBus
+	inbox  *mailbox
+	mu     sync.RWMutex
+	closed bool
+}
+
+func (t *MemoryTransport) BroadcastToAcceptors(message QueueMessage) error {
+	return t.broadcast(Envelope{Role: AcceptorRole, Message: message})
+}
+
+func (t *MemoryTransport) BroadcastToProposers(message QueueMessage) error {
+	return t.broadcast(Envelope{Role: ProposerRole, Message: message})
+}
+
+func (t *MemoryTransport) broadcast(envelope Envelope) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return ErrTransportClosed
+	}
+	t.bus.broadcast(envelope)
+	return nil
+}
+
+func (t *MemoryTransport) Receive() <-chan Envelope {
+	return t.inbox.out
+}
+
+func (t *MemoryTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	t.bus.leave(t)
+	t.inbox.close()
+	return nil
+}
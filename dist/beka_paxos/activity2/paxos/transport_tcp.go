@@ -0,0 +1,322 @@
+package paxos
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	tcpDialTimeout = time.Second
+	// tcpQueueSize bounds the frames queued for one peer. Frames beyond it are
+	// dropped like lost messages.
+	tcpQueueSize = 256
+	// tcpMaxRedialBackoff bounds the wait between dials of a peer that is down.
+	tcpMaxRedialBackoff = 5 * time.Second
+)
+
+// TCPTransport connects servers directly in a full mesh, so a cluster can run
+// without a broker. Envelopes of JSON messages are sent as newline-delimited JSON,
+// the ones of binary messages as length-prefixed binary frames. Every peer has its
+// own writer goroutine that dials it lazily, so a slow or unreachable peer never
+// stalls a broadcast. While a peer is down, frames for it are dropped and it is
+// redialed with a growing backoff.
+type TCPTransport struct {
+	listener net.Listener
+	inbox    *mailbox
+	peers    []*tcpPeer
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+type tcpPeer struct {
+	addr   string
+	queue  chan []byte
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewTCPTransport listens on listenAddr and broadcasts to peers, the listen addresses
+// of every other server in the cluster.
+func NewTCPTransport(listenAddr string, peers []string) (*TCPTransport, error) {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
+	}
+
+	t := &TCPTransport{
+		listener: listener,
+		inbox:    newMailbox(),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	for _, addr := range peers {
+		ctx, cancel := context.WithCancel(context.Background())
+		peer := &tcpPeer{addr: addr, queue: make(chan []byte, tcpQueueSize), ctx: ctx, cancel: cancel}
+		t.peers = append(t.peers, peer)
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			peer.run()
+		}()
+	}
+
+	t.wg.Add(1)
+	go t.acceptLoop()
+	log.Printf("TCP transport listening on %s with %d peers", listener.Addr(), len(peers))
+	return t, nil
+}
+
+// Addr returns the address the transport listens on.
+func (t *TCPTransport) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+func (t *TCPTransport) BroadcastToAcceptors(message QueueMessage) error {
+	return t.broadcast(Envelope{Role: AcceptorRole, Message: message})
+}
+
+func (t *TCPTransport) BroadcastToProposers(message QueueMessage) error {
+	return t.broadcast(Envelope{Role: ProposerRole, Message: message})
+}
+
+// broadcast delivers envelope locally and queues it for every peer.
+func (t *TCPTransport) broadcast(envelope Envelope) error {
+	t.mu.Lock()
+	closed := t.closed
+	t.mu.Unlock()
+	if closed {
+		return ErrTransportClosed
+	}
+
+	t.inbox.put(envelope)
+	if len(t.peers) == 0 {
+		return nil
+	}
+	frame, err := encodeEnvelope(envelope)
+	if err != nil {
+		return err
+	}
+	for _, peer := range t.peers {
+		peer.send(frame)
+	}
+	return nil
+}
+
+func (t *TCPTransport) Receive() <-chan Envelope {
+	return t.inbox.out
+}
+
+func (t *TCPTransport) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	err := t.listener.Close()
+	for conn := range t.conns {
+		conn.Close()
+	}
+	t.mu.Unlock()
+
+	for _, peer := range t.peers {
+		peer.cancel()
+	}
+	t.wg.Wait()
+	t.inbox.close()
+	return err
+}
+
+func (t *TCPTransport) acceptLoop() {
+	defer t.wg.Done()
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Error: Failed to accept TCP connection: %s", err)
+			continue
+		}
+
+		t.mu.Lock()
+		if t.closed {
+			t.mu.Unlock()
+			conn.Close()
+			return
+		}
+		t.conns[conn] = struct{}{}
+		t.mu.Unlock()
+
+		t.wg.Add(1)
+		go t.readLoop(conn)
+	}
+}
+
+func (t *TCPTransport) readLoop(conn net.Conn) {
+	defer t.wg.Done()
+	defer func() {
+		t.mu.Lock()
+		delete(t.conns, conn)
+		t.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		frame, binaryFrame, err := readFrame(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("Error: Failed to read from %s: %s", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		envelope, err := decodeEnvelope(frame, binaryFrame)
+		if err != nil {
+			log.Printf("Error: Failed to unmarshal envelope from %s: %s", conn.RemoteAddr(), err)
+			continue
+		}
+		t.inbox.put(envelope)
+	}
+}
+
+// encodeEnvelope frames envelope in the wire version of its message.
+func encodeEnvelope(envelope Envelope) ([]byte, error) {
+	if envelope.Message.Version <= JSONWireVersion {
+		data, err := json.Marshal(envelope)
+		return append(data, '\n'), err
+	}
+	message, err := EncodeMessage(envelope.Message)
+	if err != nil {
+		return nil, err
+	}
+	payload := appendString(nil, string(envelope.Role))
+	payload = append(payload, message...)
+	frame := binary.AppendUvarint([]byte{binaryMagic}, uint64(len(payload)))
+	return append(frame, payload...), nil
+}
+
+// readFrame reads the next JSON line or binary frame from r.
+func readFrame(r *bufio.Reader) ([]byte, bool, error) {
+	for {
+		first, err := r.ReadByte()
+		if err != nil {
+			return nil, false, err
+		}
+		switch first {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '{':
+			r.UnreadByte()
+			// Read the line a buffer at a time, so a peer that never sends a newline
+			// cannot make it grow past the maximum frame size.
+			var line []byte
+			for {
+				chunk, err := r.ReadSlice('\n')
+				if len(line)+len(chunk) > maxFrameSize {
+					return nil, false, errors.New("line exceeds the maximum frame size")
+				}
+				line = append(line, chunk...)
+				if err != bufio.ErrBufferFull {
+					return line, false, err
+				}
+			}
+		case binaryMagic:
+			size, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, false, err
+			}
+			if size > maxFrameSize {
+				return nil, false, fmt.Errorf("frame of %d bytes exceeds the maximum frame size", size)
+			}
+			frame := make([]byte, size)
+			_, err = io.ReadFull(r, frame)
+			return frame, true, err
+		default:
+			return nil, false, fmt.Errorf("unexpected byte %#x", first)
+		}
+	}
+}
+
+func decodeEnvelope(frame []byte, binaryFrame bool) (Envelope, error) {
+	if !binaryFrame {
+		var envelope Envelope
+		err := json.Unmarshal(frame, &envelope)
+		envelope.Message.Version = JSONWireVersion
+		return envelope, err
+	}
+	r := &binaryReader{data: frame}
+	role := Role(r.string())
+	if r.err != nil {
+		return Envelope{}, r.err
+	}
+	message, err := DecodeMessage(r.data)
+	return Envelope{Role: role, Message: message}, err
+}
+
+// send queues frame for the peer, dropping it when the queue is full.
+func (p *tcpPeer) send(frame []byte) {
+	select {
+	case p.queue <- frame:
+	default:
+	}
+}
+
+// run writes the queued frames to the peer until its context is canceled. After a
+// failed dial, frames are dropped until the backoff passed.
+func (p *tcpPeer) run() {
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	dialer := net.Dialer{Timeout: tcpDialTimeout}
+	var backoff time.Duration
+	var retryAt time.Time
+
+	for {
+		var frame []byte
+		select {
+		case <-p.ctx.Done():
+			return
+		case frame = <-p.queue:
+		}
+
+		if conn == nil {
+			if time.Now().Before(retryAt) {
+				continue
+			}
+			c, err := dialer.DialContext(p.ctx, "tcp", p.addr)
+			if err != nil {
+				if backoff == 0 {
+					log.Printf("Info: Peer %s unreachable, dropping its messages: %s", p.addr, err)
+				}
+				backoff = min(max(2*backoff, tcpDialTimeout/10), tcpMaxRedialBackoff)
+				retryAt = time.Now().Add(backoff)
+				continue
+			}
+			if backoff > 0 {
+				log.Printf("Info: Reconnected to peer %s", p.addr)
+			}
+			conn, backoff = c, 0
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(tcpDialTimeout))
+		if _, err := conn.Write(frame); err != nil {
+			log.Printf("Info: Failed to write to peer %s: %s", p.addr, err)
+			conn.Close()
+			conn = nil
+		}
+	}
+}
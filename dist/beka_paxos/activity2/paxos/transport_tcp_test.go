@@ -0,0 +1,77 @@
+package paxos
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// endless reads as an endless run of 'a'.
+type endless struct{}
+
+func (endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	return len(p), nil
+}
+
+func TestReadFrameBoundsLines(t *testing.T) {
+	r := bufio.NewReader(io.MultiReader(strings.NewReader("{"), endless{}))
+	if _, _, err := readFrame(r); err == nil {
+		t.Fatal("read a line without newline past the maximum frame size")
+	}
+
+	r = bufio.NewReader(strings.NewReader("\n{\"role\":\"a\"}\n"))
+	line, binaryFrame, err := readFrame(r)
+	if err != nil || binaryFrame || string(line) != "{\"role\":\"a\"}\n" {
+		t.Fatalf("got %q, %t, %v", line, binaryFrame, err)
+	}
+}
+
+func TestTCPTransportBroadcast(t *testing.T) {
+	// The first peer accepts nothing, so dialing it fails or hangs; it must not
+	// delay delivery to the second.
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downAddr := down.Addr().String()
+	down.Close()
+
+	receiver, err := NewTCPTransport("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+	sender, err := NewTCPTransport("127.0.0.1:0", []string{downAddr, receiver.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	start := time.Now()
+	for _, version := range []int{JSONWireVersion, BinaryWireVersion} {
+		message := QueueMessage{Type: heartbeatMessageType, Sender: "sender", Version: version, Body: []byte("{}")}
+		if err := sender.BroadcastToAcceptors(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("broadcasts took %s", elapsed)
+	}
+
+	for _, version := range []int{JSONWireVersion, BinaryWireVersion} {
+		select {
+		case envelope := <-receiver.Receive():
+			if envelope.Role != AcceptorRole || envelope.Message.Sender != "sender" || envelope.Message.Version != version {
+				t.Fatalf("received %+v", envelope)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("version %d message not received", version)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package paxos
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/core"
+)
+
+const walHeaderSize = 8
+
+// ErrWALCorrupted means a WAL record other than the last one is damaged. Dropping
+// it would silently forget promises or accepts, so the acceptor refuses to start.
+var ErrWALCorrupted = errors.New("WAL corrupted")
+
+// WAL is an append-only file of acceptor state changes. Every record is framed as
+// a 4-byte payload length and a 4-byte CRC-32 of the payload, followed by the
+// payload, and is fsynced before Append returns. Payloads are records in the
+// binary wire format, so accepted values keep their types across restarts; logs
+// written before hold JSON payloads, which are still read.
+type WAL struct {
+	path string
+	file *os.File
+}
+
+// OpenWAL opens the write-ahead log at path, creating it if it does not exist.
+func OpenWAL(path string) (*WAL, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL %s: %w", path, err)
+	}
+	return &WAL{path: path, file: file}, nil
+}
+
+// Replay reads every record from the start of the log. A crash during Append can
+// only tear the last record, so a damaged record no intact record follows is
+// truncated away and later appends continue from the last intact one. Damage
+// anywhere else fails with ErrWALCorrupted.
+func (w *WAL) Replay() ([]core.Record, error) {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []core.Record
+	offset := 0
+	for offset < len(data) {
+		record, size, err := decodeWALRecord(data[offset:])
+		if err != nil {
+			if !tornTail(data[offset:]) {
+				return nil, fmt.Errorf("%w: record at offset %d: %s", ErrWALCorrupted, offset, err)
+			}
+			log.Printf("Info: Truncating torn WAL record at offset %d: %s", offset, err)
+			break
+		}
+		records = append(records, record)
+		offset += size
+	}
+
+	if err := w.file.Truncate(int64(offset)); err != nil {
+		return nil, err
+	}
+	if _, err := w.file.Seek(int64(offset), io.SeekStart); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// tornTail reports whether the damaged record at the start of data is the tail of
+// an interrupted append, which no intact record follows.
+func tornTail(data []byte) bool {
+	for i := 1; i < len(data); i++ {
+		if _, _, err := decodeWALRecord(data[i:]); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Append durably writes record to the end of the log.
+func (w *WAL) Append(record core.Record) error {
+	buf, err := encodeWALRecord(record)
+	if err != nil {
+		return err
+	}
+	if _, err := w.file.Write(buf); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Rewrite atomically replaces the whole log with records. A crash leaves either the
+// old or the new log in place.
+func (w *WAL) Rewrite(records []core.Record) error {
+	tmpPath := w.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		buf, err := encodeWALRecord(record)
+		if err == nil {
+			_, err = tmp.Write(buf)
+		}
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, w.path); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := syncDir(filepath.Dir(w.path)); err != nil {
+		log.Printf("Error: Failed to sync WAL directory: %s", err)
+	}
+
+	w.file.Close()
+	w.file = tmp
+	return nil
+}
+
+func encodeWALRecord(record core.Record) ([]byte, error) {
+	body, err := binaryCodec{}.EncodeBody(record)
+	if err != nil {
+		return nil, err
+	}
+	payload := append([]byte{binaryMagic, BinaryWireVersion}, body...)
+
+	buf := make([]byte, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[walHeaderSize:], payload)
+	return buf, nil
+}
+
+// decodeWALRecord decodes the record at the start of data and returns its framed
+// size.
+func decodeWALRecord(data []byte) (core.Record, int, error) {
+	var record core.Record
+	if len(data) < walHeaderSize {
+		return record, 0, errors.New("short header")
+	}
+	length := binary.BigEndian.Uint32(data[:4])
+	checksum := binary.BigEndian.Uint32(data[4:walHeaderSize])
+	if uint64(length) > uint64(len(data)-walHeaderSize) {
+		return record, 0, errors.New("short payload")
+	}
+	size := walHeaderSize + int(length)
+	payload := data[walHeaderSize:size]
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return record, size, errors.New("bad checksum")
+	}
+
+	var err error
+	switch {
+	case len(payload) > 0 && payload[0] == '{':
+		err = json.Unmarshal(payload, &record)
+	case len(payload) >= 2 && payload[0] == binaryMagic && payload[1] == BinaryWireVersion:
+		err = binaryCodec{}.DecodeBody(payload[2:], &record)
+	default:
+		err = errors.New("unknown payload format")
+	}
+	return record, size, err
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (w *WAL) Close() error {
+	return w.file.Close()
+}
@@ -0,0 +1,181 @@
+package paxos
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/core"
+)
+
+var walRecords = []core.Record{
+	{Type: core.PromiseRecord, ProposalNumber: ProposalNumber{BallotNumber: 1, ProposerID: "node1"}},
+	{Type: core.AcceptRecord, Instance: 1, ProposalNumber: ProposalNumber{BallotNumber: 1, ProposerID: "node1"}, Value: int64(42)},
+	{Type: core.AcceptRecord, Instance: 2, ProposalNumber: ProposalNumber{BallotNumber: 1, ProposerID: "node1"}, Value: "value"},
+	{Type: core.PromiseRecord, ProposalNumber: ProposalNumber{BallotNumber: 2, ProposerID: "node2"}},
+	{Type: core.AcceptRecord, Instance: 3, ProposalNumber: ProposalNumber{BallotNumber: 2, ProposerID: "node2"}, Value: 1.5},
+	{Type: core.AcceptRecord, Instance: 4, ProposalNumber: ProposalNumber{BallotNumber: 2, ProposerID: "node2"}, Value: []byte{0, 1}},
+	{Type: core.AcceptRecord, Instance: 5, ProposalNumber: ProposalNumber{BallotNumber: 2, ProposerID: "node2"}, Value: Batch{Values: []interface{}{"a", "b"}}},
+	{Type: core.CompactRecord, Instance: 2},
+}
+
+// writeWAL appends records to a new WAL and returns its path and the offsets at
+// which each record ends.
+func writeWAL(t *testing.T, records []core.Record) (string, []int) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "acceptor.wal")
+	wal, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	var ends []int
+	size := 0
+	for _, record := range records {
+		if err := wal.Append(record); err != nil {
+			t.Fatal(err)
+		}
+		buf, err := encodeWALRecord(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += len(buf)
+		ends = append(ends, size)
+	}
+	return path, ends
+}
+
+func replayWAL(t *testing.T, path string) ([]core.Record, error) {
+	t.Helper()
+	wal, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	return wal.Replay()
+}
+
+func equalRecords(a, b []core.Record) bool {
+	return len(a) == len(b) && (len(a) == 0 || reflect.DeepEqual(a, b))
+}
+
+func TestWALReplayKeepsValueTypes(t *testing.T) {
+	path, _ := writeWAL(t, walRecords)
+	records, err := replayWAL(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, walRecords) {
+		t.Fatalf("replayed %#v, want %#v", records, walRecords)
+	}
+}
+
+func TestWALReplayTruncatedAtEveryOffset(t *testing.T) {
+	path, ends := writeWAL(t, walRecords)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra := core.Record{Type: core.PromiseRecord, ProposalNumber: ProposalNumber{BallotNumber: 3, ProposerID: "node3"}}
+
+	for cut := 0; cut <= len(data); cut++ {
+		torn := filepath.Join(t.TempDir(), "torn.wal")
+		if err := os.WriteFile(torn, data[:cut], 0o644); err != nil {
+			t.Fatal(err)
+		}
+		intact := 0
+		for intact < len(ends) && ends[intact] <= cut {
+			intact++
+		}
+
+		wal, err := OpenWAL(torn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := wal.Replay()
+		if err != nil {
+			t.Fatalf("cut at %d: %s", cut, err)
+		}
+		if !equalRecords(records, walRecords[:intact]) {
+			t.Fatalf("cut at %d: replayed %d records, want %d", cut, len(records), intact)
+		}
+		// Appends continue right after the last intact record.
+		if err := wal.Append(extra); err != nil {
+			t.Fatal(err)
+		}
+		wal.Close()
+
+		records, err = replayWAL(t, torn)
+		if err != nil {
+			t.Fatalf("cut at %d, after append: %s", cut, err)
+		}
+		want := append(append([]core.Record{}, walRecords[:intact]...), extra)
+		if !equalRecords(records, want) {
+			t.Fatalf("cut at %d, after append: replayed %d records, want %d", cut, len(records), len(want))
+		}
+	}
+}
+
+func TestWALReplayCorruption(t *testing.T) {
+	path, ends := writeWAL(t, walRecords)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+		records int
+		err     error
+	}{
+		{"payload of first record", func(d []byte) []byte { d[walHeaderSize] ^= 0xFF; return d }, 0, ErrWALCorrupted},
+		{"length of middle record", func(d []byte) []byte { d[ends[1]] = 0xFF; return d }, 0, ErrWALCorrupted},
+		{"checksum of last record", func(d []byte) []byte { d[ends[len(ends)-2]+4] ^= 0xFF; return d }, len(walRecords) - 1, nil},
+		{"zeros after last record", func(d []byte) []byte { return append(d, make([]byte, 64)...) }, len(walRecords), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corrupted := filepath.Join(t.TempDir(), "corrupted.wal")
+			if err := os.WriteFile(corrupted, tt.corrupt(append([]byte{}, data...)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			records, err := replayWAL(t, corrupted)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err == nil && !equalRecords(records, walRecords[:tt.records]) {
+				t.Fatalf("replayed %d records, want %d", len(records), tt.records)
+			}
+		})
+	}
+}
+
+func TestWALReplayReadsJSONRecords(t *testing.T) {
+	record := core.Record{Type: core.AcceptRecord, Instance: 1, ProposalNumber: ProposalNumber{BallotNumber: 3, ProposerID: "node1"}, Value: "old"}
+	payload, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, walHeaderSize, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload))
+	path := filepath.Join(t.TempDir(), "acceptor.wal")
+	if err := os.WriteFile(path, append(buf, payload...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := replayWAL(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, []core.Record{record}) {
+		t.Fatalf("replayed %#v, want %#v", records, record)
+	}
+}
@@ -0,0 +1,130 @@
+package sim
+
+import (
+	"container/heap"
+	"math/rand"
+)
+
+type eventKind int
+
+const (
+	deliverEvent eventKind = iota
+	timeoutEvent
+	faultEvent
+	restartEvent
+	fastAcceptEvent
+)
+
+type event struct {
+	at      int64
+	seq     int64
+	kind    eventKind
+	from    string
+	to      string
+	message interface{}
+	token   int
+}
+
+// eventQueue orders events by virtual time, breaking ties by scheduling order so
+// that a run only depends on its seed.
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *eventQueue) Push(x any) { *q = append(*q, x.(*event)) }
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// network is a virtual network that drops, duplicates, delays and therefore
+// reorders messages, and that can be split into two partitions.
+type network struct {
+	cfg       *Config
+	rng       *rand.Rand
+	queue     eventQueue
+	now       int64
+	seq       int64
+	partition map[string]int
+	down      map[string]bool
+	stats     Stats
+}
+
+func newNetwork(cfg *Config, rng *rand.Rand) *network {
+	return &network{
+		cfg:       cfg,
+		rng:       rng,
+		partition: make(map[string]int),
+		down:      make(map[string]bool),
+	}
+}
+
+func (n *network) schedule(e *event) {
+	n.seq++
+	e.seq = n.seq
+	heap.Push(&n.queue, e)
+}
+
+func (n *network) next() *event {
+	if len(n.queue) == 0 {
+		return nil
+	}
+	e := heap.Pop(&n.queue).(*event)
+	n.now = e.at
+	return e
+}
+
+func (n *network) delay() int64 {
+	return n.cfg.MinDelay + n.rng.Int63n(n.cfg.MaxDelay-n.cfg.MinDelay+1)
+}
+
+func (n *network) send(from, to string, message interface{}) {
+	n.stats.Sent++
+	if n.rng.Float64() < n.cfg.DropRate {
+		n.stats.Dropped++
+		return
+	}
+	n.schedule(&event{at: n.now + n.delay(), kind: deliverEvent, from: from, to: to, message: message})
+	if n.rng.Float64() < n.cfg.DuplicateRate {
+		n.stats.Duplicated++
+		n.schedule(&event{at: n.now + n.delay(), kind: deliverEvent, from: from, to: to, message: message})
+	}
+}
+
+// reachable reports whether a message from one node can be delivered to another
+// right now. Messages in flight when a partition starts or a node crashes are lost.
+func (n *network) reachable(from, to string) bool {
+	return !n.down[to] && n.partition[from] == n.partition[to]
+}
+
+func (n *network) split(nodes []string) {
+	for _, node := range nodes {
+		n.partition[node] = n.rng.Intn(2)
+	}
+	n.stats.Partitions++
+}
+
+func (n *network) heal() {
+	clear(n.partition)
+}
+
+func (n *network) partitioned() bool {
+	for _, side := range n.partition {
+		if side != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+package sim
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/core"
+	"github.com/beka-birhanu/paxos-lab-activity2/paxos"
+)
+
+const (
+	idle = iota
+	preparing
+	accepting
+	fastAccepting
+)
+
+// proposerNode drives core.Round the way paxos.Proposer does, but from simulated
+// events instead of channels and wall-clock timers.
+type proposerNode struct {
+	sim         *simulation
+	id          string
+	number      paxos.ProposalNumber
+	highestSeen int
+	instance    int
+	sequence    int
+	phase       int
+	round       *core.Round
+	prepared    *core.Round
+	proposing   interface{}
+	fastVotes   *core.Votes
+	opened      paxos.ProposalNumber
+	timer       int
+}
+
+func newProposerNode(s *simulation, id string) *proposerNode {
+	return &proposerNode{
+		sim:      s,
+		id:       id,
+		number:   paxos.ProposalNumber{ProposerID: id},
+		instance: 1,
+	}
+}
+
+func (p *proposerNode) ownValue() string {
+	return fmt.Sprintf("%s-%d", p.id, p.sequence)
+}
+
+// start proposes for the current instance, skipping phase 1 when the last
+// successful prepare still covers it. On the prepared instance itself, the value
+// the promises reported has to be proposed again. With fast rounds, instances past
+// everything the promising acceptors accepted go through the fast ballot instead.
+func (p *proposerNode) start() {
+	if p.instance > p.sim.cfg.Instances {
+		p.phase = idle
+		return
+	}
+	if p.prepared != nil && p.prepared.Covers(p.instance, p.sim.config) {
+		if p.sim.cfg.FastRounds && p.instance > p.prepared.LastAccepted() {
+			p.startFast()
+			return
+		}
+		value := interface{}(p.ownValue())
+		if p.instance == p.prepared.Instance {
+			if adopted := p.prepared.AdoptedValue(); adopted != nil {
+				value = adopted
+			}
+		}
+		p.startAccept(value)
+		return
+	}
+	p.startPrepare()
+}
+
+func (p *proposerNode) startPrepare() {
+	p.prepared = nil
+	p.number.BallotNumber = max(p.number.BallotNumber, p.highestSeen) + 1
+	p.round = core.NewRound(p.instance, p.number, p.sim.config)
+	p.phase = preparing
+	p.sim.broadcastToAcceptors(p.id, p.round.Prepare())
+	p.armTimer()
+}
+
+func (p *proposerNode) startAccept(value interface{}) {
+	p.round = core.NewRound(p.instance, p.number, p.sim.config)
+	p.proposing = value
+	p.phase = accepting
+	p.sim.broadcastToAcceptors(p.id, p.round.Accept(value))
+	p.armTimer()
+}
+
+// startFast opens the fast ballot of the prepared round from the current instance
+// on, which is resent for every instance so restarted acceptors rejoin it, and
+// sends the own value straight to the acceptors like a client would. Clients only
+// learn of a new fast ballot once it is open, so the first value is sent after
+// ANY had time to arrive.
+func (p *proposerNode) startFast() {
+	p.round = nil
+	p.proposing = nil
+	p.fastVotes = core.NewVotes()
+	p.phase = fastAccepting
+	p.sim.broadcastToAcceptors(p.id, paxos.Any{Instance: p.instance, ProposalNumber: p.prepared.Number})
+	p.armTimer()
+	if p.opened == p.prepared.Number {
+		p.sendFastAccept()
+		return
+	}
+	p.opened = p.prepared.Number
+	p.sim.net.schedule(&event{at: p.sim.net.now + p.sim.cfg.MaxDelay, kind: fastAcceptEvent, to: p.id, token: p.timer})
+}
+
+func (p *proposerNode) sendFastAccept() {
+	p.sim.broadcastToAcceptors(p.id, paxos.FastAccept{ClientID: p.id, Instance: p.instance, Value: p.ownValue()})
+}
+
+// onTimeout retries the current instance. A fast ballot that stalled is recovered
+// like one that collided: phase 1 with a higher ballot closes it and finds the
+// value that may have been chosen there.
+func (p *proposerNode) onTimeout() {
+	if p.phase == fastAccepting {
+		p.recover("stalled")
+		return
+	}
+	p.start()
+}
+
+func (p *proposerNode) recover(reason string) {
+	p.sim.tracef("recover %s instance %d: fast ballot %s", p.id, p.instance, reason)
+	p.sim.net.stats.Recoveries++
+	p.startPrepare()
+}
+
+func (p *proposerNode) armTimer() {
+	p.timer++
+	timeout := p.sim.cfg.Timeout + p.sim.rng.Int63n(p.sim.cfg.Timeout+1)
+	p.sim.net.schedule(&event{at: p.sim.net.now + timeout, kind: timeoutEvent, to: p.id, token: p.timer})
+}
+
+func (p *proposerNode) onPromise(promise paxos.Promise) {
+	p.highestSeen = max(p.highestSeen, promise.ProposalNumber.BallotNumber)
+	if p.phase != preparing || !p.round.AddPromise(promise) || !p.round.Promised() {
+		return
+	}
+
+	p.prepared = p.round
+	value := p.round.AdoptedValue()
+	if value == nil && p.sim.cfg.FastRounds && p.instance > p.round.LastAccepted() {
+		p.startFast()
+		return
+	}
+	if value == nil {
+		value = p.ownValue()
+	}
+	p.startAccept(value)
+}
+
+func (p *proposerNode) onAccepted(accepted paxos.Accepted) {
+	p.highestSeen = max(p.highestSeen, accepted.ProposalNumber.BallotNumber)
+	if p.phase != accepting || !p.round.AddAccepted(accepted) || !p.round.Chosen() {
+		return
+	}
+
+	p.learn()
+}
+
+// onFastAccepted counts a vote of the fast ballot for the current instance. The
+// instance is recovered as soon as no value can reach a fast quorum anymore.
+func (p *proposerNode) onFastAccepted(accepted paxos.Accepted) {
+	p.highestSeen = max(p.highestSeen, accepted.ProposalNumber.BallotNumber)
+	if p.phase != fastAccepting || accepted.Instance != p.instance {
+		return
+	}
+	value, chosen, collided := p.fastVotes.AddFast(accepted, p.sim.config)
+	switch {
+	case chosen:
+		p.sim.net.stats.FastDecisions++
+		p.proposing = value
+		p.learn()
+	case collided:
+		p.recover("collided")
+	}
+}
+
+// learn moves on to the next instance once p.proposing was chosen for the current one.
+func (p *proposerNode) learn() {
+	p.sim.tracef("learn %s instance %d = %v", p.id, p.instance, p.proposing)
+	p.sim.checker.learn(p.id, p.instance, p.proposing)
+	if reflect.DeepEqual(p.proposing, p.ownValue()) {
+		p.sequence++
+	}
+	p.instance++
+	p.phase = idle
+	p.start()
+}
+
+// onNack restarts phase 1 past the promised ballot once the current round can no
+// longer reach a quorum.
+func (p *proposerNode) onNack(nack paxos.Nack) {
+	p.highestSeen = max(p.highestSeen, nack.PromisedNumber.BallotNumber)
+	if p.phase == idle || p.phase == fastAccepting || !p.round.AddNack(nack) || !p.round.Rejected() {
+		return
+	}
+
+	p.sim.tracef("rejected %s instance %d ballot %d", p.id, p.instance, p.round.Number.BallotNumber)
+	p.startPrepare()
+}
+
+// crash drops every in-flight round. The ballot counter survives, as reusing a
+// proposal number with a different value would be unsafe by construction.
+func (p *proposerNode) crash() {
+	p.phase = idle
+	p.round = nil
+	p.prepared = nil
+	p.fastVotes = nil
+	p.opened = paxos.ProposalNumber{}
+	p.timer++
+}
@@ -0,0 +1,469 @@
+// Package sim runs Acceptors and Proposers of the paxos package over a deterministic
+// virtual network and checks that at most one value is ever chosen per instance,
+// in classic rounds and, optionally, in fast ballots with coordinator recovery.
+// Every random decision comes from the configured seed, so a failing seed replays
+// the exact same run.
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/beka-birhanu/paxos-lab-activity2/core"
+	"github.com/beka-birhanu/paxos-lab-activity2/paxos"
+)
+
+type Config struct {
+	Seed      int64
+	Acceptors int
+	Proposers int
+	// Instances is the number of log instances every proposer tries to fill.
+	Instances int
+
+	DropRate      float64
+	DuplicateRate float64
+	// MinDelay and MaxDelay bound the virtual milliseconds a message is in flight.
+	MinDelay int64
+	MaxDelay int64
+	// Timeout is the virtual milliseconds a proposer waits for a quorum before it
+	// retries with a higher ballot; the actual wait is jittered up to twice as long.
+	Timeout int64
+
+	// Every FaultInterval virtual milliseconds the network is split or healed with
+	// probability PartitionRate, and a node crashes with probability CrashRate. A
+	// crashed node restarts after up to RestartDelay virtual milliseconds.
+	FaultInterval int64
+	PartitionRate float64
+	CrashRate     float64
+	RestartDelay  int64
+
+	// Quorum is the quorum system of the acceptors, majorities when nil. It is not
+	// validated, so quorums that need not intersect can be simulated as well.
+	Quorum *paxos.QuorumSpec
+
+	// FastRounds makes every proposer open a fast ballot with ANY once its phase 1
+	// covers an instance, and send its values there with FAST_ACCEPT. Instances whose
+	// fast ballot collides or stalls are recovered in a classic round.
+	FastRounds bool
+
+	// Volatile runs the acceptors without a write-ahead log, so a crash makes them
+	// forget their promises. It exists to show the checker catching unsafe setups.
+	Volatile bool
+	MaxTime  int64
+	// Trace receives a line for every simulated event when it is not nil.
+	Trace io.Writer
+}
+
+// DefaultConfig returns a lossy, partition- and crash-prone configuration.
+func DefaultConfig(seed int64) Config {
+	return Config{
+		Seed:          seed,
+		Acceptors:     5,
+		Proposers:     3,
+		Instances:     5,
+		DropRate:      0.1,
+		DuplicateRate: 0.1,
+		MinDelay:      1,
+		MaxDelay:      20,
+		Timeout:       60,
+		FaultInterval: 50,
+		PartitionRate: 0.2,
+		CrashRate:     0.2,
+		RestartDelay:  200,
+		MaxTime:       60_000,
+	}
+}
+
+type Stats struct {
+	Sent       int
+	Dropped    int
+	Duplicated int
+	Partitions int
+	Crashes    int
+	// FastDecisions counts the instances proposers learned from a fast quorum, and
+	// Recoveries the fast ballots they recovered in a classic round.
+	FastDecisions int
+	Recoveries    int
+}
+
+type Result struct {
+	Seed  int64
+	Time  int64
+	Stats Stats
+	// Chosen maps every instance a quorum accepted a value for to that value.
+	Chosen map[int]interface{}
+	// Violation is set when the run broke the safety invariant.
+	Violation error
+}
+
+type simulation struct {
+	cfg       Config
+	rng       *rand.Rand
+	net       *network
+	dataDir   string
+	acceptors []*acceptorNode
+	proposers []*proposerNode
+	nodes     []string
+	config    paxos.Configuration
+	checker   *checker
+}
+
+// Run simulates one cluster with cfg until every proposer filled its instances, the
+// safety invariant is broken or MaxTime elapses.
+func Run(cfg Config) (Result, error) {
+	dataDir, err := os.MkdirTemp("", "paxos-sim-")
+	if err != nil {
+		return Result{}, err
+	}
+	defer os.RemoveAll(dataDir)
+
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	s := &simulation{
+		cfg:     cfg,
+		rng:     rng,
+		dataDir: dataDir,
+		config:  paxos.Configuration{Quorum: cfg.Quorum},
+	}
+	s.net = newNetwork(&s.cfg, rng)
+	if cfg.FastRounds && !s.config.FastCapable() {
+		return Result{}, errors.New("fast rounds need a quorum system where every acceptor has one vote")
+	}
+
+	for i := range cfg.Acceptors {
+		a := &acceptorNode{id: fmt.Sprintf("a%d", i)}
+		if err := s.startAcceptor(a); err != nil {
+			return Result{}, err
+		}
+		s.acceptors = append(s.acceptors, a)
+		s.nodes = append(s.nodes, a.id)
+		s.config.Members = append(s.config.Members, a.id)
+	}
+	s.checker = newChecker(s.config)
+	for i := range cfg.Proposers {
+		p := newProposerNode(s, fmt.Sprintf("p%d", i))
+		s.proposers = append(s.proposers, p)
+		s.nodes = append(s.nodes, p.id)
+	}
+
+	for _, p := range s.proposers {
+		s.net.schedule(&event{at: rng.Int63n(cfg.Timeout + 1), kind: timeoutEvent, to: p.id, token: p.timer})
+	}
+	if cfg.FaultInterval > 0 {
+		s.net.schedule(&event{at: cfg.FaultInterval, kind: faultEvent})
+	}
+
+	for s.checker.violation == nil && !s.finished() {
+		e := s.net.next()
+		if e == nil || e.at > cfg.MaxTime {
+			break
+		}
+		s.handle(e)
+	}
+
+	for _, a := range s.acceptors {
+		if a.wal != nil {
+			a.wal.Close()
+		}
+	}
+	return Result{
+		Seed:      cfg.Seed,
+		Time:      s.net.now,
+		Stats:     s.net.stats,
+		Chosen:    s.checker.chosen,
+		Violation: s.checker.violation,
+	}, nil
+}
+
+func (s *simulation) finished() bool {
+	for _, p := range s.proposers {
+		if p.instance <= s.cfg.Instances {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *simulation) tracef(format string, args ...interface{}) {
+	if s.cfg.Trace != nil {
+		fmt.Fprintf(s.cfg.Trace, "t=%06d "+format+"\n", append([]interface{}{s.net.now}, args...)...)
+	}
+}
+
+func (s *simulation) handle(e *event) {
+	switch e.kind {
+	case deliverEvent:
+		if !s.net.reachable(e.from, e.to) {
+			s.net.stats.Dropped++
+			s.tracef("lost %s->%s %T%+v", e.from, e.to, e.message, e.message)
+			return
+		}
+		s.tracef("deliver %s->%s %T%+v", e.from, e.to, e.message, e.message)
+		s.deliver(e.from, e.to, e.message)
+
+	case timeoutEvent:
+		p := s.proposer(e.to)
+		if !s.net.down[p.id] && e.token == p.timer {
+			s.tracef("timeout %s", p.id)
+			p.onTimeout()
+		}
+
+	case faultEvent:
+		s.injectFaults()
+		s.net.schedule(&event{at: s.net.now + s.cfg.FaultInterval, kind: faultEvent})
+
+	case restartEvent:
+		s.restart(e.to)
+
+	case fastAcceptEvent:
+		p := s.proposer(e.to)
+		if !s.net.down[p.id] && e.token == p.timer {
+			p.sendFastAccept()
+		}
+	}
+}
+
+func (s *simulation) deliver(from, to string, message interface{}) {
+	switch m := message.(type) {
+	case paxos.Prepare:
+		acceptor := s.acceptor(to).acceptor
+		if promise, ok := acceptor.HandlePrepare(m); ok {
+			s.broadcastToProposers(to, promise)
+		} else {
+			s.broadcastToProposers(to, acceptor.Nack("PREPARE", m.RequestID, m.Instance, m.ProposalNumber))
+		}
+	case paxos.Accept:
+		acceptor := s.acceptor(to).acceptor
+		if accepted, ok := acceptor.HandleAccept(m); ok {
+			s.checker.observe(accepted)
+			s.broadcastToProposers(to, accepted)
+		} else {
+			s.broadcastToProposers(to, acceptor.Nack("ACCEPT", m.RequestID, m.Instance, m.ProposalNumber))
+		}
+	case paxos.Any:
+		s.acceptor(to).acceptor.HandleAny(m)
+	case paxos.FastAccept:
+		if accepted, ok := s.acceptor(to).acceptor.HandleFastAccept(m); ok {
+			s.checker.observeFast(accepted)
+			s.broadcastToProposers(to, fastAccepted{accepted})
+		}
+	case paxos.Promise:
+		s.proposer(to).onPromise(m)
+	case paxos.Accepted:
+		s.proposer(to).onAccepted(m)
+	case paxos.Nack:
+		s.proposer(to).onNack(m)
+	case fastAccepted:
+		s.proposer(to).onFastAccepted(m.Accepted)
+	}
+}
+
+// fastAccepted is an Accepted sent in a fast ballot, which proposers count per value.
+type fastAccepted struct {
+	paxos.Accepted
+}
+
+func (s *simulation) broadcastToAcceptors(from string, message interface{}) {
+	for _, a := range s.acceptors {
+		s.net.send(from, a.id, message)
+	}
+}
+
+// broadcastToProposers mirrors the FOR_PROPOSERS fanout: every proposer sees every
+// response and filters the ones for its own round.
+func (s *simulation) broadcastToProposers(from string, message interface{}) {
+	for _, p := range s.proposers {
+		s.net.send(from, p.id, message)
+	}
+}
+
+func (s *simulation) injectFaults() {
+	if s.rng.Float64() < s.cfg.PartitionRate {
+		if s.net.partitioned() {
+			s.tracef("heal")
+			s.net.heal()
+		} else {
+			s.net.split(s.nodes)
+			s.tracef("partition %v", s.net.partition)
+		}
+	}
+
+	if s.rng.Float64() < s.cfg.CrashRate {
+		node := s.nodes[s.rng.Intn(len(s.nodes))]
+		if s.net.down[node] {
+			return
+		}
+		s.tracef("crash %s", node)
+		s.net.stats.Crashes++
+		s.crash(node)
+		restartAt := s.net.now + 1 + s.rng.Int63n(s.cfg.RestartDelay+1)
+		s.net.schedule(&event{at: restartAt, kind: restartEvent, to: node})
+	}
+}
+
+func (s *simulation) crash(node string) {
+	s.net.down[node] = true
+	if a := s.acceptor(node); a != nil {
+		if a.wal != nil {
+			a.wal.Close()
+			a.wal = nil
+		}
+		a.acceptor = nil
+		return
+	}
+	s.proposer(node).crash()
+}
+
+func (s *simulation) restart(node string) {
+	s.tracef("restart %s", node)
+	delete(s.net.down, node)
+	if a := s.acceptor(node); a != nil {
+		if err := s.startAcceptor(a); err != nil {
+			s.checker.violation = fmt.Errorf("failed to restart acceptor %s: %w", node, err)
+		}
+		return
+	}
+	s.proposer(node).start()
+}
+
+// startAcceptor (re)creates the acceptor of a, restoring its state from its WAL
+// unless the simulation is volatile.
+func (s *simulation) startAcceptor(a *acceptorNode) error {
+	var wal *paxos.WAL
+	if !s.cfg.Volatile {
+		var err error
+		wal, err = paxos.OpenWAL(filepath.Join(s.dataDir, a.id+".wal"))
+		if err != nil {
+			return err
+		}
+	}
+
+	acceptor, err := paxos.NewAcceptor(a.id, wal, nil, nil, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	a.acceptor = acceptor
+	a.wal = wal
+	return nil
+}
+
+func (s *simulation) acceptor(id string) *acceptorNode {
+	for _, a := range s.acceptors {
+		if a.id == id {
+			return a
+		}
+	}
+	return nil
+}
+
+func (s *simulation) proposer(id string) *proposerNode {
+	for _, p := range s.proposers {
+		if p.id == id {
+			return p
+		}
+	}
+	return nil
+}
+
+type acceptorNode struct {
+	id       string
+	acceptor *paxos.Acceptor
+	wal      *paxos.WAL
+}
+
+// checker watches every Accepted an acceptor sends and records a value as chosen
+// once a phase-2 quorum accepted it under the same proposal number, or a fast
+// quorum accepted it in a fast ballot.
+type checker struct {
+	config    paxos.Configuration
+	votes     map[int]map[paxos.ProposalNumber]map[string]bool
+	values    map[int]map[paxos.ProposalNumber]interface{}
+	fastVotes map[int]map[paxos.ProposalNumber]map[string]map[string]bool
+	chosen    map[int]interface{}
+	violation error
+}
+
+func newChecker(config paxos.Configuration) *checker {
+	return &checker{
+		config:    config,
+		votes:     make(map[int]map[paxos.ProposalNumber]map[string]bool),
+		values:    make(map[int]map[paxos.ProposalNumber]interface{}),
+		fastVotes: make(map[int]map[paxos.ProposalNumber]map[string]map[string]bool),
+		chosen:    make(map[int]interface{}),
+	}
+}
+
+func (c *checker) observe(accepted paxos.Accepted) {
+	if c.fastVotes[accepted.Instance][accepted.ProposalNumber] != nil {
+		c.violation = fmt.Errorf("instance %d: proposal %+v used in both a classic and a fast round",
+			accepted.Instance, accepted.ProposalNumber)
+		return
+	}
+	if c.votes[accepted.Instance] == nil {
+		c.votes[accepted.Instance] = make(map[paxos.ProposalNumber]map[string]bool)
+		c.values[accepted.Instance] = make(map[paxos.ProposalNumber]interface{})
+	}
+	votes := c.votes[accepted.Instance]
+	if votes[accepted.ProposalNumber] == nil {
+		votes[accepted.ProposalNumber] = make(map[string]bool)
+		c.values[accepted.Instance][accepted.ProposalNumber] = accepted.Value
+	}
+	if value := c.values[accepted.Instance][accepted.ProposalNumber]; !reflect.DeepEqual(value, accepted.Value) {
+		c.violation = fmt.Errorf("instance %d: proposal %+v carries both %v and %v",
+			accepted.Instance, accepted.ProposalNumber, value, accepted.Value)
+		return
+	}
+
+	votes[accepted.ProposalNumber][accepted.AcceptorID] = true
+	if c.config.IsQuorum(paxos.Phase2, votes[accepted.ProposalNumber]) {
+		c.choose(accepted.Instance, accepted.Value)
+	}
+}
+
+// observeFast watches an Accepted sent in a fast ballot, where acceptors may accept
+// different values under one proposal number.
+func (c *checker) observeFast(accepted paxos.Accepted) {
+	if c.votes[accepted.Instance][accepted.ProposalNumber] != nil {
+		c.violation = fmt.Errorf("instance %d: proposal %+v used in both a classic and a fast round",
+			accepted.Instance, accepted.ProposalNumber)
+		return
+	}
+	if c.fastVotes[accepted.Instance] == nil {
+		c.fastVotes[accepted.Instance] = make(map[paxos.ProposalNumber]map[string]map[string]bool)
+	}
+	values := c.fastVotes[accepted.Instance][accepted.ProposalNumber]
+	if values == nil {
+		values = make(map[string]map[string]bool)
+		c.fastVotes[accepted.Instance][accepted.ProposalNumber] = values
+	}
+	key := core.ValueKey(accepted.Value)
+	if values[key] == nil {
+		values[key] = make(map[string]bool)
+	}
+	values[key][accepted.AcceptorID] = true
+	if len(values[key]) >= c.config.FastQuorum() {
+		c.choose(accepted.Instance, accepted.Value)
+	}
+}
+
+func (c *checker) choose(instance int, value interface{}) {
+	if chosen, ok := c.chosen[instance]; ok {
+		if !reflect.DeepEqual(chosen, value) {
+			c.violation = fmt.Errorf("instance %d: both %v and %v were chosen", instance, chosen, value)
+		}
+		return
+	}
+	c.chosen[instance] = value
+}
+
+// learn checks a value a proposer believes chosen against what the acceptors did.
+func (c *checker) learn(proposerID string, instance int, value interface{}) {
+	chosen, ok := c.chosen[instance]
+	if !ok || !reflect.DeepEqual(chosen, value) {
+		c.violation = fmt.Errorf("instance %d: %s learned %v but the acceptors chose %v", instance, proposerID, value, chosen)
+	}
+}
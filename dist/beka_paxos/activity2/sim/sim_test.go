@@ -0,0 +1,74 @@
+package sim
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"testing"
+)
+
+// seeds is the number of consecutive seeds every test simulates.
+const seeds = 100
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+func TestSafety(t *testing.T) {
+	for seed := int64(1); seed <= seeds; seed++ {
+		result, err := Run(DefaultConfig(seed))
+		if err != nil {
+			t.Fatalf("seed %d: %s", seed, err)
+		}
+		if result.Violation != nil {
+			t.Errorf("seed %d: safety violation at t=%d: %s", seed, result.Time, result.Violation)
+		}
+	}
+}
+
+// TestCheckerCatchesVolatileAcceptors makes sure the checker is not vacuous:
+// acceptors that forget their promises on a crash must break safety in some run.
+func TestCheckerCatchesVolatileAcceptors(t *testing.T) {
+	violations := 0
+	for seed := int64(1); seed <= seeds; seed++ {
+		cfg := DefaultConfig(seed)
+		cfg.Volatile = true
+		result, err := Run(cfg)
+		if err != nil {
+			t.Fatalf("seed %d: %s", seed, err)
+		}
+		if result.Violation != nil {
+			violations++
+		}
+	}
+	if violations == 0 {
+		t.Fatalf("no violation in %d runs with volatile acceptors", seeds)
+	}
+}
+
+// TestFastRoundSafety runs fast ballots with coordinator recovery, and makes sure
+// values were decided in fast quorums as well as recovered in classic rounds.
+func TestFastRoundSafety(t *testing.T) {
+	var fast, recovered int
+	for seed := int64(1); seed <= seeds; seed++ {
+		cfg := DefaultConfig(seed)
+		cfg.FastRounds = true
+		result, err := Run(cfg)
+		if err != nil {
+			t.Fatalf("seed %d: %s", seed, err)
+		}
+		if result.Violation != nil {
+			t.Errorf("seed %d: safety violation at t=%d: %s", seed, result.Time, result.Violation)
+		}
+		fast += result.Stats.FastDecisions
+		recovered += result.Stats.Recoveries
+	}
+	if fast == 0 || recovered == 0 {
+		t.Fatalf("%d fast decisions and %d recoveries in %d runs, want both", fast, recovered, seeds)
+	}
+}